@@ -0,0 +1,604 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"proteggo_api/tools"
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// Gets the albums, newest first, with the url of their cover image
+func GetAlbumsHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cursor := c.Query("cursor")
+		pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		docs, nextCursor, prevCursor, err := getDocumentsPage(c, db.Collection(types.FIREBASE_ALBUMS_COLLECTION).Query, cursor, pageSize, firestore.Desc, nil)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		albums := []types.Album{}
+		coversRefs := []*firestore.DocumentRef{}
+		for _, doc := range docs {
+			album := convertDocumentToAlbum(doc.Data())
+			albums = append(albums, album)
+
+			if album.CoverImageId != "" {
+				coversRefs = append(coversRefs, db.Collection(types.FIREBASE_IMAGES_COLLECTION).Doc(album.CoverImageId))
+			}
+		}
+
+		covers, err := db.GetAll(c, coversRefs)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		coversUrls := map[string]string{}
+		for _, cover := range covers {
+			if cover.Exists() && canReadImage(c, cover.Data()) {
+				coversUrls[cover.Ref.ID], _ = cover.Data()[types.FIREBASE_IMAGES_FIELDS_URL].(string)
+			}
+		}
+
+		for i, album := range albums {
+			albums[i].CoverImageUrl = coversUrls[album.CoverImageId]
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"albums":     albums,
+			"nextCursor": nextCursor,
+			"prevCursor": prevCursor,
+		})
+	}
+}
+
+// Gets an album with its images in order and its version as the ETag. Non admins only get the approved images which
+// are not in the trash.
+func GetAlbumHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(types.FIREBASE_ALBUMS_FIELDS_ID)
+
+		data, err := tools.GetFirestoreDocument(c, db, types.FIREBASE_ALBUMS_COLLECTION, id)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		if data == nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "album " + id + " does not exist",
+			})
+			return
+		}
+
+		album := convertDocumentToAlbum(data)
+
+		refs := make([]*firestore.DocumentRef, len(album.ImagesIds))
+		for i, imageId := range album.ImagesIds {
+			refs[i] = db.Collection(types.FIREBASE_IMAGES_COLLECTION).Doc(imageId)
+		}
+
+		docs, err := db.GetAll(c, refs)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		images := []types.Image{}
+		album.ImagesIds = []string{}
+		for _, doc := range docs {
+			if !doc.Exists() || !canReadImage(c, doc.Data()) {
+				continue
+			}
+
+			image := convertDocumentToImage(doc.Ref.ID, doc.Data())
+			images = append(images, image)
+			album.ImagesIds = append(album.ImagesIds, image.Id)
+
+			if image.Id == album.CoverImageId {
+				album.CoverImageUrl = image.Url
+			}
+		}
+
+		tools.SetETagHeader(c, id, data)
+		c.JSON(http.StatusOK, gin.H{
+			"album":  album,
+			"images": images,
+		})
+	}
+}
+
+// Creates an album from a title, an optional description, the ids of its images in order and an optional cover image
+func CreateAlbumHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		form, err := c.MultipartForm()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		title, err := parseAlbumTitle(c.PostForm(types.FIREBASE_ALBUMS_FIELDS_TITLE))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		imagesIds, err := parseAlbumImagesIds(form.Value[types.FIREBASE_ALBUMS_FIELDS_IMAGES_IDS])
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		album := types.Album{
+			Title:        title,
+			Description:  c.PostForm(types.FIREBASE_ALBUMS_FIELDS_DESCRIPTION),
+			ImagesIds:    imagesIds,
+			CoverImageId: c.PostForm(types.FIREBASE_ALBUMS_FIELDS_COVER_IMAGE_ID),
+			CreatedBy:    getPostEditor(c).uid,
+		}
+
+		err = checkAlbumCoverImageId(album.ImagesIds, album.CoverImageId)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		album.CoverImageId = resolveAlbumCoverImageId(album.ImagesIds, album.CoverImageId)
+
+		albumRef := db.Collection(types.FIREBASE_ALBUMS_COLLECTION).NewDoc()
+		album.Id = albumRef.ID
+
+		err = db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+			countImages, err := prepareAlbumImagesChange(tx, db, nil, album.ImagesIds)
+			if err != nil {
+				return err
+			}
+
+			err = tx.Create(albumRef, map[string]interface{}{
+				types.FIREBASE_ALBUMS_FIELDS_ID:             album.Id,
+				types.FIREBASE_ALBUMS_FIELDS_TITLE:          album.Title,
+				types.FIREBASE_ALBUMS_FIELDS_DESCRIPTION:    album.Description,
+				types.FIREBASE_ALBUMS_FIELDS_IMAGES_IDS:     album.ImagesIds,
+				types.FIREBASE_ALBUMS_FIELDS_COVER_IMAGE_ID: album.CoverImageId,
+				types.FIREBASE_ALBUMS_FIELDS_CREATED_BY:     album.CreatedBy,
+				types.FIREBASE_ALBUMS_FIELDS_CREATED_AT:     firestore.ServerTimestamp,
+				types.FIREBASE_ALBUMS_FIELDS_UPDATED_AT:     nil,
+				types.FIREBASE_ALBUMS_FIELDS_VERSION:        1,
+			})
+			if err != nil {
+				return err
+			}
+
+			return countImages()
+		})
+
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		// Read the album back for the creation time set by the server
+		data, err := tools.GetFirestoreDocument(c, db, types.FIREBASE_ALBUMS_COLLECTION, album.Id)
+		if err == nil && data == nil {
+			err = errors.New("album " + album.Id + " does not exist")
+		}
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"album": convertDocumentToAlbum(data),
+		})
+	}
+}
+
+// Edits the title, description, images or cover of an album, omitted fields are left untouched. The images are
+// replaced in the given order, which is how they are reordered.
+func UpdateAlbumHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(types.FIREBASE_ALBUMS_FIELDS_ID)
+
+		form, err := c.MultipartForm()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		expectedVersions, err := tools.ParseIfMatch(c)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		title, titleProvided := c.GetPostForm(types.FIREBASE_ALBUMS_FIELDS_TITLE)
+		if titleProvided {
+			title, err = parseAlbumTitle(title)
+			if err != nil {
+				tools.LogError(logger, c, err)
+				return
+			}
+		}
+
+		// A single empty value removes all the images, as multipart forms can not send empty lists
+		imagesValues, imagesProvided := form.Value[types.FIREBASE_ALBUMS_FIELDS_IMAGES_IDS]
+		imagesIds, err := parseAlbumImagesIds(imagesValues)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		description, descriptionProvided := c.GetPostForm(types.FIREBASE_ALBUMS_FIELDS_DESCRIPTION)
+		coverImageId, coverProvided := c.GetPostForm(types.FIREBASE_ALBUMS_FIELDS_COVER_IMAGE_ID)
+
+		album, err := updateAlbum(c, db, id, expectedVersions, func(album types.Album) (types.Album, error) {
+			if titleProvided {
+				album.Title = title
+			}
+
+			if descriptionProvided {
+				album.Description = description
+			}
+
+			if imagesProvided {
+				album.ImagesIds = imagesIds
+			}
+
+			if coverProvided {
+				err := checkAlbumCoverImageId(album.ImagesIds, coverImageId)
+				if err != nil {
+					return album, err
+				}
+
+				album.CoverImageId = coverImageId
+			}
+
+			return album, nil
+		})
+
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"album": album,
+		})
+	}
+}
+
+// Adds images at the end of an album, images already in it keep their place
+func AddAlbumImagesHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(types.FIREBASE_ALBUMS_FIELDS_ID)
+
+		form, err := c.MultipartForm()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		imagesIds, err := parseAlbumImagesIds(form.Value[types.FIREBASE_ALBUMS_FIELDS_IMAGES_IDS])
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		album, err := updateAlbum(c, db, id, nil, func(album types.Album) (types.Album, error) {
+			for _, imageId := range imagesIds {
+				if indexOfString(album.ImagesIds, imageId) < 0 {
+					album.ImagesIds = append(album.ImagesIds, imageId)
+				}
+			}
+
+			return album, nil
+		})
+
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"album": album,
+		})
+	}
+}
+
+// Removes images given as comma separated ids from an album, the images themselves are kept
+func RemoveAlbumImagesHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(types.FIREBASE_ALBUMS_FIELDS_ID)
+		imagesIds := removeEmptyStrings(strings.Split(c.Query(types.FIREBASE_ALBUMS_FIELDS_IMAGES_IDS), ","))
+
+		album, err := updateAlbum(c, db, id, nil, func(album types.Album) (types.Album, error) {
+			kept := []string{}
+			for _, imageId := range album.ImagesIds {
+				if indexOfString(imagesIds, imageId) < 0 {
+					kept = append(kept, imageId)
+				}
+			}
+
+			album.ImagesIds = kept
+			return album, nil
+		})
+
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"album": album,
+		})
+	}
+}
+
+// Deletes an album, its images are kept and can be deleted once they are in no album nor post
+func DeleteAlbumHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(types.FIREBASE_ALBUMS_FIELDS_ID)
+
+		expectedVersions, err := tools.ParseIfMatch(c)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		err = db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+			data, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_ALBUMS_COLLECTION, id)
+			if err != nil {
+				return err
+			}
+
+			if data == nil {
+				return errors.New("album " + id + " does not exist")
+			}
+
+			err = tools.CheckDocumentVersion(expectedVersions, id, data)
+			if err != nil {
+				return err
+			}
+
+			uncountImages, err := prepareAlbumImagesChange(tx, db, convertDocumentToAlbum(data).ImagesIds, nil)
+			if err != nil {
+				return err
+			}
+
+			err = tx.Delete(db.Collection(types.FIREBASE_ALBUMS_COLLECTION).Doc(id))
+			if err != nil {
+				return err
+			}
+
+			return uncountImages()
+		})
+
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "ok",
+		})
+	}
+}
+
+// Changes an album in a transaction and keeps the albums count of its images in step. The version is only checked
+// when expected versions are given, adding and removing images do not depend on the rest of the album.
+func updateAlbum(c context.Context, db *firestore.Client, id string, expectedVersions map[string]int64, change func(album types.Album) (types.Album, error)) (types.Album, error) {
+	var album types.Album
+
+	err := db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+		data, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_ALBUMS_COLLECTION, id)
+		if err != nil {
+			return err
+		}
+
+		if data == nil {
+			return errors.New("album " + id + " does not exist")
+		}
+
+		if expectedVersions != nil {
+			err = tools.CheckDocumentVersion(expectedVersions, id, data)
+			if err != nil {
+				return err
+			}
+		}
+
+		current := convertDocumentToAlbum(data)
+		album, err = change(current)
+		if err != nil {
+			return err
+		}
+
+		if len(album.ImagesIds) > types.ALBUM_MAX_IMAGES {
+			return fmt.Errorf("an album can have at most %d images", types.ALBUM_MAX_IMAGES)
+		}
+
+		// A cover removed from the album falls back to the first image
+		album.CoverImageId = resolveAlbumCoverImageId(album.ImagesIds, album.CoverImageId)
+
+		countImages, err := prepareAlbumImagesChange(tx, db, current.ImagesIds, album.ImagesIds)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Update(db.Collection(types.FIREBASE_ALBUMS_COLLECTION).Doc(id), []firestore.Update{
+			{Path: types.FIREBASE_ALBUMS_FIELDS_TITLE, Value: album.Title},
+			{Path: types.FIREBASE_ALBUMS_FIELDS_DESCRIPTION, Value: album.Description},
+			{Path: types.FIREBASE_ALBUMS_FIELDS_IMAGES_IDS, Value: album.ImagesIds},
+			{Path: types.FIREBASE_ALBUMS_FIELDS_COVER_IMAGE_ID, Value: album.CoverImageId},
+			{Path: types.FIREBASE_ALBUMS_FIELDS_UPDATED_AT, Value: firestore.ServerTimestamp},
+			{Path: types.FIREBASE_ALBUMS_FIELDS_VERSION, Value: firestore.Increment(1)},
+		})
+		if err != nil {
+			return err
+		}
+
+		return countImages()
+	})
+	if err != nil {
+		return album, err
+	}
+
+	// Read the album back for the update time set by the server
+	data, err := tools.GetFirestoreDocument(c, db, types.FIREBASE_ALBUMS_COLLECTION, id)
+	if err == nil && data == nil {
+		err = errors.New("album " + id + " does not exist")
+	}
+	if err != nil {
+		return album, err
+	}
+
+	return convertDocumentToAlbum(data), nil
+}
+
+// Reads the images added to and removed from an album and returns the writes changing their albums count, so all the
+// reads of the transaction happen before its writes. Added images must be approved and not in the trash, removed
+// images which no longer exist are skipped.
+func prepareAlbumImagesChange(tx *firestore.Transaction, db *firestore.Client, oldImagesIds []string, newImagesIds []string) (func() error, error) {
+	added, removed := diffStrings(oldImagesIds, newImagesIds)
+
+	deltas := map[string]int{}
+	for _, imageId := range added {
+		image, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_IMAGES_COLLECTION, imageId)
+		if err != nil {
+			return nil, err
+		}
+
+		if image == nil || tools.IsDocumentInTrash(image) {
+			return nil, errors.New("image " + imageId + " does not exist")
+		}
+
+		if !tools.IsImageModerationApproved(image) {
+			return nil, errors.New("image " + imageId + " is not approved by moderation")
+		}
+
+		deltas[imageId] = 1
+	}
+
+	for _, imageId := range removed {
+		image, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_IMAGES_COLLECTION, imageId)
+		if err != nil {
+			return nil, err
+		}
+
+		if image != nil {
+			deltas[imageId] = -1
+		}
+	}
+
+	return func() error {
+		for imageId, delta := range deltas {
+			err := tx.Update(db.Collection(types.FIREBASE_IMAGES_COLLECTION).Doc(imageId), []firestore.Update{
+				{Path: types.FIREBASE_IMAGES_FIELDS_ALBUMS_COUNT, Value: firestore.Increment(delta)},
+				{Path: types.FIREBASE_IMAGES_FIELDS_VERSION, Value: firestore.Increment(1)},
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, nil
+}
+
+// Checks that a chosen cover is one of the images of the album, an empty cover falls back to the first image
+func checkAlbumCoverImageId(imagesIds []string, coverImageId string) error {
+	if coverImageId != "" && indexOfString(imagesIds, coverImageId) < 0 {
+		return errors.New("cover image " + coverImageId + " is not in the album")
+	}
+
+	return nil
+}
+
+// Returns the cover of an album, the first image when none was chosen or the chosen one left the album
+func resolveAlbumCoverImageId(imagesIds []string, coverImageId string) string {
+	if coverImageId != "" && indexOfString(imagesIds, coverImageId) >= 0 {
+		return coverImageId
+	}
+
+	if len(imagesIds) == 0 {
+		return ""
+	}
+
+	return imagesIds[0]
+}
+
+func parseAlbumTitle(title string) (string, error) {
+	title = strings.TrimSpace(title)
+
+	if title == "" {
+		return "", errors.New("title is required")
+	}
+
+	if utf8.RuneCountInString(title) > types.ALBUM_TITLE_MAX_LENGTH {
+		return "", fmt.Errorf("title can be at most %d characters long", types.ALBUM_TITLE_MAX_LENGTH)
+	}
+
+	return title, nil
+}
+
+// Removes empty and repeated ids, keeping the order of the first occurrences
+func parseAlbumImagesIds(values []string) ([]string, error) {
+	imagesIds := []string{}
+	for _, imageId := range removeEmptyStrings(values) {
+		if indexOfString(imagesIds, imageId) < 0 {
+			imagesIds = append(imagesIds, imageId)
+		}
+	}
+
+	if len(imagesIds) > types.ALBUM_MAX_IMAGES {
+		return nil, fmt.Errorf("an album can have at most %d images", types.ALBUM_MAX_IMAGES)
+	}
+
+	return imagesIds, nil
+}
+
+// Returns whether the user can see the image, images hidden from the listings are only seen by admins
+func canReadImage(c *gin.Context, image map[string]interface{}) bool {
+	return tools.IsAdminUser(c) || (tools.IsImageModerationApproved(image) && !tools.IsDocumentInTrash(image))
+}
+
+// Converts an album document data to an Album
+func convertDocumentToAlbum(data map[string]interface{}) types.Album {
+	album := types.Album{
+		ImagesIds: convertInterfaceToArrayString(data[types.FIREBASE_ALBUMS_FIELDS_IMAGES_IDS]),
+		Version:   tools.GetDocumentVersion(data),
+	}
+
+	album.Id, _ = data[types.FIREBASE_ALBUMS_FIELDS_ID].(string)
+	album.Title, _ = data[types.FIREBASE_ALBUMS_FIELDS_TITLE].(string)
+	album.Description, _ = data[types.FIREBASE_ALBUMS_FIELDS_DESCRIPTION].(string)
+	album.CoverImageId, _ = data[types.FIREBASE_ALBUMS_FIELDS_COVER_IMAGE_ID].(string)
+	album.CreatedBy, _ = data[types.FIREBASE_ALBUMS_FIELDS_CREATED_BY].(string)
+
+	if createdAt, ok := data[types.FIREBASE_ALBUMS_FIELDS_CREATED_AT].(time.Time); ok {
+		album.CreatedAt = createdAt.Format(time.RFC3339)
+	}
+
+	if updatedAt, ok := data[types.FIREBASE_ALBUMS_FIELDS_UPDATED_AT].(time.Time); ok {
+		album.UpdatedAt = updatedAt.Format(time.RFC3339)
+	}
+
+	return album
+}
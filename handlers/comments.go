@@ -0,0 +1,568 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"proteggo_api/tools"
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// Gets the top level comments of a post, oldest first
+func GetCommentsHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		postId := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+
+		query := db.Collection(types.FIREBASE_COMMENTS_COLLECTION).
+			Where(types.FIREBASE_COMMENTS_FIELDS_POST_ID, "==", postId).
+			Where(types.FIREBASE_COMMENTS_FIELDS_PARENT_ID, "==", nil)
+
+		getCommentsPage(c, logger, db, postId, query)
+	}
+}
+
+// Gets the replies to a comment, oldest first
+func GetCommentRepliesHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		postId := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+
+		query := db.Collection(types.FIREBASE_COMMENTS_COLLECTION).
+			Where(types.FIREBASE_COMMENTS_FIELDS_POST_ID, "==", postId).
+			Where(types.FIREBASE_COMMENTS_FIELDS_PARENT_ID, "==", c.Param("commentId"))
+
+		getCommentsPage(c, logger, db, postId, query)
+	}
+}
+
+// Comments on a post the user can read, or replies to another comment when parentId is given. Replies to replies join
+// the thread of the top level comment.
+func CreateCommentHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		postId := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+		parentId := c.PostForm(types.FIREBASE_COMMENTS_FIELDS_PARENT_ID)
+
+		body, err := parseCommentBody(c.PostForm(types.FIREBASE_COMMENTS_FIELDS_BODY))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		settings, err := tools.GetCommentsSettings(c, db)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		// Comments of admins never wait for an approval
+		status := types.COMMENT_STATUS_APPROVED
+		if settings.RequireApproval && !tools.IsAdminUser(c) {
+			status = types.COMMENT_STATUS_PENDING
+		}
+
+		commentRef := db.Collection(types.FIREBASE_COMMENTS_COLLECTION).NewDoc()
+		comment := types.Comment{
+			Id:        commentRef.ID,
+			PostId:    postId,
+			AuthorUid: getPostEditor(c).uid,
+			Body:      body,
+			Status:    status,
+		}
+
+		err = db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+			post, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_POSTS_COLLECTION, postId)
+			if err != nil {
+				return err
+			}
+
+			if post == nil || !canReadPost(c, convertDocumentToPost(post)) {
+				return errors.New("post " + postId + " does not exist")
+			}
+
+			comment.ParentId = ""
+			if parentId != "" {
+				parent, err := getPostComment(tx, db, postId, parentId)
+				if err != nil {
+					return err
+				}
+
+				comment.ParentId = parent.Id
+				if parent.ParentId != "" {
+					comment.ParentId = parent.ParentId
+				}
+			}
+
+			var parentValue interface{}
+			if comment.ParentId != "" {
+				parentValue = comment.ParentId
+			}
+
+			err = tx.Create(commentRef, map[string]interface{}{
+				types.FIREBASE_COMMENTS_FIELDS_ID:            comment.Id,
+				types.FIREBASE_COMMENTS_FIELDS_POST_ID:       postId,
+				types.FIREBASE_COMMENTS_FIELDS_PARENT_ID:     parentValue,
+				types.FIREBASE_COMMENTS_FIELDS_AUTHOR_UID:    comment.AuthorUid,
+				types.FIREBASE_COMMENTS_FIELDS_BODY:          body,
+				types.FIREBASE_COMMENTS_FIELDS_STATUS:        status,
+				types.FIREBASE_COMMENTS_FIELDS_REPLIES_COUNT: 0,
+				types.FIREBASE_COMMENTS_FIELDS_CREATED_AT:    firestore.ServerTimestamp,
+				types.FIREBASE_COMMENTS_FIELDS_UPDATED_AT:    nil,
+			})
+			if err != nil {
+				return err
+			}
+
+			if status == types.COMMENT_STATUS_APPROVED {
+				return changeCommentCounts(tx, db, comment, 1)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"comment": comment,
+		})
+	}
+}
+
+// Edits the body of a comment, only its author can edit it while they can read the post. Edited comments wait for an
+// approval again when approvals are required.
+func UpdateCommentHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		postId := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+		commentId := c.Param("commentId")
+
+		body, err := parseCommentBody(c.PostForm(types.FIREBASE_COMMENTS_FIELDS_BODY))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		settings, err := tools.GetCommentsSettings(c, db)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		editor := getPostEditor(c)
+
+		err = db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+			post, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_POSTS_COLLECTION, postId)
+			if err != nil {
+				return err
+			}
+
+			// Posts which became private or were moved to the trash since the comment was written are not commented anymore
+			if post == nil || !canReadPost(c, convertDocumentToPost(post)) {
+				return errors.New("post " + postId + " does not exist")
+			}
+
+			comment, err := getPostComment(tx, db, postId, commentId)
+			if err != nil {
+				return err
+			}
+
+			if editor.uid == "" || comment.AuthorUid != editor.uid {
+				return fmt.Errorf("%w: comment %v", tools.ErrForbidden, commentId)
+			}
+
+			updates := []firestore.Update{
+				{Path: types.FIREBASE_COMMENTS_FIELDS_BODY, Value: body},
+				{Path: types.FIREBASE_COMMENTS_FIELDS_UPDATED_AT, Value: firestore.ServerTimestamp},
+			}
+
+			// Hidden comments stay hidden, approved comments are reviewed again
+			if settings.RequireApproval && !editor.admin && comment.Status == types.COMMENT_STATUS_APPROVED {
+				updates = append(updates, firestore.Update{Path: types.FIREBASE_COMMENTS_FIELDS_STATUS, Value: types.COMMENT_STATUS_PENDING})
+
+				err = changeCommentCounts(tx, db, comment, -1)
+				if err != nil {
+					return err
+				}
+			}
+
+			return tx.Update(db.Collection(types.FIREBASE_COMMENTS_COLLECTION).Doc(commentId), updates)
+		})
+
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "ok",
+		})
+	}
+}
+
+// Deletes a comment with its replies, comments can be deleted by their author and admins. A transaction is limited
+// to 500 writes, so the replies are deleted in batches and the comment goes with the last one.
+func DeleteCommentHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		postId := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+		commentId := c.Param("commentId")
+
+		editor := getPostEditor(c)
+
+		for deleted := false; !deleted; {
+			err := db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+				deleted = false
+
+				comment, err := getPostComment(tx, db, postId, commentId)
+				if err != nil {
+					return err
+				}
+
+				if !editor.admin && (editor.uid == "" || comment.AuthorUid != editor.uid) {
+					return fmt.Errorf("%w: comment %v", tools.ErrForbidden, commentId)
+				}
+
+				// Read a batch of the replies, all reads have to be done before the writes
+				var replies []*firestore.DocumentSnapshot
+				if comment.ParentId == "" {
+					replies, err = tx.Documents(db.Collection(types.FIREBASE_COMMENTS_COLLECTION).
+						Where(types.FIREBASE_COMMENTS_FIELDS_POST_ID, "==", postId).
+						Where(types.FIREBASE_COMMENTS_FIELDS_PARENT_ID, "==", commentId).
+						Limit(types.TRASH_PURGE_BATCH_SIZE)).GetAll()
+					if err != nil {
+						return err
+					}
+				}
+
+				// Only approved comments are counted
+				approved := 0
+				for _, reply := range replies {
+					if reply.Data()[types.FIREBASE_COMMENTS_FIELDS_STATUS] == types.COMMENT_STATUS_APPROVED {
+						approved++
+					}
+
+					err = tx.Delete(reply.Ref)
+					if err != nil {
+						return err
+					}
+				}
+
+				// More replies are left, the counts of the post and of the comment follow the deleted ones
+				if len(replies) == types.TRASH_PURGE_BATCH_SIZE {
+					if approved > 0 {
+						return changeCommentCounts(tx, db, types.Comment{PostId: postId, ParentId: commentId}, -approved)
+					}
+					return nil
+				}
+
+				// The replies count of the deleted comment goes with it
+				if comment.Status == types.COMMENT_STATUS_APPROVED {
+					approved++
+				}
+
+				if approved > 0 {
+					err = changeCommentCounts(tx, db, comment, -approved)
+					if err != nil {
+						return err
+					}
+				}
+
+				deleted = true
+				return tx.Delete(db.Collection(types.FIREBASE_COMMENTS_COLLECTION).Doc(commentId))
+			})
+
+			if err != nil {
+				tools.LogError(logger, c, err)
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "ok",
+		})
+	}
+}
+
+// Lists the comments with a status, pending comments by default, oldest first
+func GetModeratedCommentsHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := c.DefaultQuery(types.FIREBASE_COMMENTS_FIELDS_STATUS, types.COMMENT_STATUS_PENDING)
+		if !isCommentStatus(status) {
+			tools.LogError(logger, c, errors.New("status must be one of pending, approved or hidden"))
+			return
+		}
+
+		cursor := c.Query("cursor")
+		pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		query := db.Collection(types.FIREBASE_COMMENTS_COLLECTION).Where(types.FIREBASE_COMMENTS_FIELDS_STATUS, "==", status)
+
+		docs, nextCursor, prevCursor, err := getDocumentsPage(c, query, cursor, pageSize, firestore.Asc, nil)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		comments := []types.Comment{}
+		for _, doc := range docs {
+			comments = append(comments, convertDocumentToComment(doc.Data()))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"comments":   comments,
+			"nextCursor": nextCursor,
+			"prevCursor": prevCursor,
+		})
+	}
+}
+
+// Approves or hides comments, the comment counts of their posts follow
+func ModerateCommentsHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		form, err := c.MultipartForm()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		commentsIds := form.Value["commentsIds"]
+		decision := c.PostForm("decision")
+
+		if decision != types.COMMENT_STATUS_APPROVED && decision != types.COMMENT_STATUS_HIDDEN {
+			tools.LogError(logger, c, errors.New("decision must be either approved or hidden"))
+			return
+		}
+
+		moderatedBy := getPostEditor(c).uid
+
+		var moderatedIds []string
+		var failedIds []string
+
+		for _, commentId := range commentsIds {
+			err := db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+				data, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_COMMENTS_COLLECTION, commentId)
+				if err != nil {
+					return err
+				}
+
+				if data == nil {
+					return errors.New("comment " + commentId + " does not exist")
+				}
+
+				comment := convertDocumentToComment(data)
+
+				// The counts only change when the comment is shown or stops being shown
+				if comment.Status != types.COMMENT_STATUS_APPROVED && decision == types.COMMENT_STATUS_APPROVED {
+					err = changeCommentCounts(tx, db, comment, 1)
+				} else if comment.Status == types.COMMENT_STATUS_APPROVED && decision != types.COMMENT_STATUS_APPROVED {
+					err = changeCommentCounts(tx, db, comment, -1)
+				}
+				if err != nil {
+					return err
+				}
+
+				return tx.Update(db.Collection(types.FIREBASE_COMMENTS_COLLECTION).Doc(commentId), []firestore.Update{
+					{Path: types.FIREBASE_COMMENTS_FIELDS_STATUS, Value: decision},
+					{Path: types.FIREBASE_COMMENTS_FIELDS_MODERATED_BY, Value: moderatedBy},
+					{Path: types.FIREBASE_COMMENTS_FIELDS_MODERATED_AT, Value: firestore.ServerTimestamp},
+				})
+			})
+
+			if err != nil {
+				logger.Log(logging.Entry{
+					Severity: logging.Error,
+					Payload:  "Error moderating comment " + commentId,
+					Labels:   map[string]string{"error": err.Error()},
+				})
+				failedIds = append(failedIds, commentId)
+				continue
+			}
+
+			moderatedIds = append(moderatedIds, commentId)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"moderatedIds": moderatedIds,
+			"failedIds":    failedIds,
+		})
+	}
+}
+
+func GetCommentsSettingsHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settings, err := tools.GetCommentsSettings(c, db)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"settings": settings,
+		})
+	}
+}
+
+func SetCommentsSettingsHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requireApproval, err := strconv.ParseBool(c.PostForm(types.FIREBASE_COMMENTS_SETTINGS_FIELDS_REQUIRE_APPROVAL))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		err = tools.SetFirestoreDocument(c, db, types.FIREBASE_SETTINGS_COLLECTION, types.FIREBASE_SETTINGS_COMMENTS_DOCUMENT, map[string]interface{}{
+			types.FIREBASE_COMMENTS_SETTINGS_FIELDS_REQUIRE_APPROVAL: requireApproval,
+		})
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"settings": types.CommentsSettings{RequireApproval: requireApproval},
+		})
+	}
+}
+
+// Responds with a page of the comments of the query, after checking the user can read their post. Non admins only
+// get approved comments, admins can filter them by status.
+func getCommentsPage(c *gin.Context, logger *logging.Logger, db *firestore.Client, postId string, query firestore.Query) {
+	cursor := c.Query("cursor")
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	if err != nil {
+		tools.LogError(logger, c, err)
+		return
+	}
+
+	post, err := tools.GetFirestoreDocument(c, db, types.FIREBASE_POSTS_COLLECTION, postId)
+	if err != nil {
+		tools.LogError(logger, c, err)
+		return
+	}
+
+	if post == nil || !canReadPost(c, convertDocumentToPost(post)) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "post " + postId + " does not exist",
+		})
+		return
+	}
+
+	if !tools.IsAdminUser(c) {
+		query = query.Where(types.FIREBASE_COMMENTS_FIELDS_STATUS, "==", types.COMMENT_STATUS_APPROVED)
+	} else if status, ok := c.GetQuery(types.FIREBASE_COMMENTS_FIELDS_STATUS); ok {
+		if !isCommentStatus(status) {
+			tools.LogError(logger, c, errors.New("status must be one of pending, approved or hidden"))
+			return
+		}
+
+		query = query.Where(types.FIREBASE_COMMENTS_FIELDS_STATUS, "==", status)
+	}
+
+	// Discussions are read in the order they happened
+	docs, nextCursor, prevCursor, err := getDocumentsPage(c, query, cursor, pageSize, firestore.Asc, nil)
+	if err != nil {
+		tools.LogError(logger, c, err)
+		return
+	}
+
+	comments := []types.Comment{}
+	for _, doc := range docs {
+		comments = append(comments, convertDocumentToComment(doc.Data()))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"comments":   comments,
+		"nextCursor": nextCursor,
+		"prevCursor": prevCursor,
+	})
+}
+
+// Reads a comment of a post within the transaction
+func getPostComment(tx *firestore.Transaction, db *firestore.Client, postId string, commentId string) (types.Comment, error) {
+	data, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_COMMENTS_COLLECTION, commentId)
+	if err != nil {
+		return types.Comment{}, err
+	}
+
+	if data == nil || data[types.FIREBASE_COMMENTS_FIELDS_POST_ID] != postId {
+		return types.Comment{}, errors.New("comment " + commentId + " does not exist")
+	}
+
+	return convertDocumentToComment(data), nil
+}
+
+// Changes the comments count of the post and the replies count of the parent comment when the comment is a reply
+func changeCommentCounts(tx *firestore.Transaction, db *firestore.Client, comment types.Comment, delta int) error {
+	err := tx.Update(db.Collection(types.FIREBASE_POSTS_COLLECTION).Doc(comment.PostId), []firestore.Update{
+		{Path: types.FIREBASE_POSTS_FIELDS_COMMENTS_COUNT, Value: firestore.Increment(delta)},
+	})
+	if err != nil {
+		return err
+	}
+
+	if comment.ParentId == "" {
+		return nil
+	}
+
+	return tx.Update(db.Collection(types.FIREBASE_COMMENTS_COLLECTION).Doc(comment.ParentId), []firestore.Update{
+		{Path: types.FIREBASE_COMMENTS_FIELDS_REPLIES_COUNT, Value: firestore.Increment(delta)},
+	})
+}
+
+func parseCommentBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+
+	if body == "" {
+		return "", errors.New("body is required")
+	}
+
+	if len([]rune(body)) > types.COMMENT_BODY_MAX_LENGTH {
+		return "", fmt.Errorf("body can not be longer than %d characters", types.COMMENT_BODY_MAX_LENGTH)
+	}
+
+	return body, nil
+}
+
+func isCommentStatus(status string) bool {
+	switch status {
+	case types.COMMENT_STATUS_PENDING, types.COMMENT_STATUS_APPROVED, types.COMMENT_STATUS_HIDDEN:
+		return true
+	}
+
+	return false
+}
+
+// Converts a comment document data to a Comment
+func convertDocumentToComment(data map[string]interface{}) types.Comment {
+	comment := types.Comment{}
+
+	comment.Id, _ = data[types.FIREBASE_COMMENTS_FIELDS_ID].(string)
+	comment.PostId, _ = data[types.FIREBASE_COMMENTS_FIELDS_POST_ID].(string)
+	comment.ParentId, _ = data[types.FIREBASE_COMMENTS_FIELDS_PARENT_ID].(string)
+	comment.AuthorUid, _ = data[types.FIREBASE_COMMENTS_FIELDS_AUTHOR_UID].(string)
+	comment.Body, _ = data[types.FIREBASE_COMMENTS_FIELDS_BODY].(string)
+	comment.Status, _ = data[types.FIREBASE_COMMENTS_FIELDS_STATUS].(string)
+	comment.RepliesCount, _ = data[types.FIREBASE_COMMENTS_FIELDS_REPLIES_COUNT].(int64)
+
+	if createdAt, ok := data[types.FIREBASE_COMMENTS_FIELDS_CREATED_AT].(time.Time); ok {
+		comment.CreatedAt = createdAt.Format(time.RFC3339)
+	}
+
+	if updatedAt, ok := data[types.FIREBASE_COMMENTS_FIELDS_UPDATED_AT].(time.Time); ok {
+		comment.UpdatedAt = updatedAt.Format(time.RFC3339)
+	}
+
+	return comment
+}
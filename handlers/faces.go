@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"proteggo_api/tools"
 	"proteggo_api/types"
 	"strconv"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/logging"
@@ -26,6 +29,17 @@ func SetObscuredOverlayHandler(logger *logging.Logger, firestoreClient *firestor
 		imagesIds := form.Value["imagesIds"]
 		obscuredStoragePaths := form.Value["obscuredStoragePaths"]
 
+		// The faces and text regions covered by each overlay, comma separated, so revisions can show what was obscured
+		obscuredFacesIds := form.Value["obscuredFacesIds"]
+		obscuredRegionsIds := form.Value["obscuredRegionsIds"]
+
+		// Each obscured overlay is only set if the image did not change since the client read it
+		expectedVersions, err := tools.ParseIfMatch(c)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
 		var failedIds []string
 		var obscuredOverlays []types.ObscuredOverlay
 
@@ -33,6 +47,34 @@ func SetObscuredOverlayHandler(logger *logging.Logger, firestoreClient *firestor
 		for i, imageId := range imagesIds {
 			tempObscuredStoragePath := obscuredStoragePaths[i]
 
+			// Check the version before replacing the obscured overlay in storage
+			imageDoc, err := tools.GetFirestoreDocument(c, firestoreClient, types.FIREBASE_IMAGES_COLLECTION, imageId)
+			if err == nil && imageDoc == nil {
+				err = errors.New("image " + imageId + " does not exist")
+			}
+			if err == nil {
+				err = tools.CheckDocumentVersion(expectedVersions, imageId, imageDoc)
+			}
+			if err != nil {
+				failedIds = append(failedIds, imageId)
+				tools.LogError(logger, c, err)
+				continue
+			}
+
+			facesIds, err := parseObscuredIds(valueAtIndex(obscuredFacesIds, i), imageDoc[types.FIREBASE_IMAGES_FIELDS_FACES_IDS])
+			if err != nil {
+				failedIds = append(failedIds, imageId)
+				tools.LogError(logger, c, err)
+				continue
+			}
+
+			regionsIds, err := parseObscuredIds(valueAtIndex(obscuredRegionsIds, i), imageDoc[types.FIREBASE_IMAGES_FIELDS_REGIONS_IDS])
+			if err != nil {
+				failedIds = append(failedIds, imageId)
+				tools.LogError(logger, c, err)
+				continue
+			}
+
 			// Check if the temp obscured image exists
 			exists, err := tools.CheckIfImageExistsInStorage(c, tempObscuredStoragePath, storage)
 			if err != nil {
@@ -47,8 +89,14 @@ func SetObscuredOverlayHandler(logger *logging.Logger, firestoreClient *firestor
 				continue
 			}
 
-			// Move the obscured image to the obscured overlay folder
-			obscuredStoragePath := types.FIREBASE_STORAGE_OBSCURED_FACES_OVERLAY_FOLDER + imageId + ".png"
+			// Move the obscured image to a new version in the obscured overlay folder, the live version is never overwritten
+			obscuredStoragePath, err := newObscuredStoragePath(types.FIREBASE_STORAGE_OBSCURED_FACES_OVERLAY_FOLDER, imageId, ".png")
+			if err != nil {
+				failedIds = append(failedIds, imageId)
+				tools.LogError(logger, c, err)
+				continue
+			}
+
 			err = tools.MoveObjectInStorage(c, tempObscuredStoragePath, obscuredStoragePath, storage)
 			if err != nil {
 				failedIds = append(failedIds, imageId)
@@ -61,25 +109,47 @@ func SetObscuredOverlayHandler(logger *logging.Logger, firestoreClient *firestor
 			if err != nil {
 				failedIds = append(failedIds, imageId)
 				tools.LogError(logger, c, err)
+				deleteOrphanedObscuredObject(c, logger, storage, obscuredStoragePath)
+				continue
+			}
+
+			// Draw the overlay over the original, the rendition is what is served outside the app
+			imageStoragePath, _ := imageDoc[types.FIREBASE_IMAGES_FIELDS_STORAGE_PATH].(string)
+			obscuredImageStoragePath, obscuredImageUrl, err := renderObscuredImage(c, logger, firestoreClient, storage, imageId, imageStoragePath, obscuredStoragePath)
+			if err != nil {
+				failedIds = append(failedIds, imageId)
+				tools.LogError(logger, c, err)
+				deleteOrphanedObscuredObject(c, logger, storage, obscuredStoragePath)
 				continue
 			}
 
-			// Update the image document with the obscured overlay url and storage path
-			err = tools.UpdateFirestoreDocument(c, firestoreClient, types.FIREBASE_IMAGES_COLLECTION, imageId, map[string]interface{}{
+			// Switch the image to the new version, only if it did not change since the version was checked
+			err = tools.UpdateFirestoreDocumentIfMatch(c, firestoreClient, types.FIREBASE_IMAGES_COLLECTION, imageId, expectedVersions, map[string]interface{}{
 				types.FIREBASE_IMAGES_FIELDS_FACES_OBSCURED_OVERLAY_URL:          url,
 				types.FIREBASE_IMAGES_FIELDS_FACES_OBSCURED_OVERLAY_STORAGE_PATH: obscuredStoragePath,
+				types.FIREBASE_IMAGES_FIELDS_OBSCURED_URL:                        obscuredImageUrl,
+				types.FIREBASE_IMAGES_FIELDS_OBSCURED_STORAGE_PATH:               obscuredImageStoragePath,
+				types.FIREBASE_IMAGES_FIELDS_OBSCURED_FACES_IDS:                  facesIds,
+				types.FIREBASE_IMAGES_FIELDS_OBSCURED_REGIONS_IDS:                regionsIds,
+				types.FIREBASE_IMAGES_FIELDS_OBSCURED_VERSIONS_STORAGE_PATHS:     firestore.ArrayUnion(obscuredStoragePath, obscuredImageStoragePath),
 			})
 
 			if err != nil {
 				failedIds = append(failedIds, imageId)
 				tools.LogError(logger, c, err)
+				deleteOrphanedObscuredObject(c, logger, storage, obscuredStoragePath)
+				deleteOrphanedObscuredObject(c, logger, storage, obscuredImageStoragePath)
 				continue
 			}
 
 			obscuredOverlays = append(obscuredOverlays, types.ObscuredOverlay{
-				Id:          imageId,
-				Url:         url,
-				StoragePath: obscuredStoragePath,
+				Id:               imageId,
+				Url:              url,
+				StoragePath:      obscuredStoragePath,
+				ImageUrl:         obscuredImageUrl,
+				ImageStoragePath: obscuredImageStoragePath,
+				FacesIds:         facesIds,
+				RegionsIds:       regionsIds,
 			})
 		}
 
@@ -103,6 +173,7 @@ func CreateTempObscuredOverlayHandler(logger *logging.Logger, firestoreClient *f
 		imageWidth := form.Value["imageWidth"][0]
 		imageHeight := form.Value["imageHeight"][0]
 		facesIdsToObscure := form.Value["facesIdsToObscure"]
+		regionsIdsToObscure := form.Value["regionsIdsToObscure"]
 
 		// Cast the image width and height to int
 		imageWidthInt, err := strconv.Atoi(imageWidth)
@@ -124,6 +195,14 @@ func CreateTempObscuredOverlayHandler(logger *logging.Logger, firestoreClient *f
 			return
 		}
 
+		// Get text regions vertices from the image, those are obscured together with the faces
+		regionsVertices, err := tools.GetRegionsVertices(imageId, regionsIdsToObscure, c, firestoreClient)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+		faceVertices = append(faceVertices, regionsVertices...)
+
 		// Create obscured overlay in the temp folder
 		obscuredTempStoragePath := types.FIREBASE_STORAGE_TEMP_FOLDER + imageId + ".png"
 
@@ -169,6 +248,52 @@ func CreateTempObscuredOverlayHandler(logger *logging.Logger, firestoreClient *f
 	}
 }
 
+// Gets the faces of an image with their versions, needed to delete them
+func GetFacesHandler(logger *logging.Logger, firestoreClient *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		faces := []types.Face{}
+
+		imageId, imageIdProvided := c.GetQuery("imageId")
+		if !imageIdProvided {
+			tools.LogError(logger, c, errors.New("Image ID not provided"))
+			return
+		}
+
+		docs, err := firestoreClient.Collection(types.FIREBASE_FACES_COLLECTION).Where(types.FIREBASE_FACES_FIELDS_IMAGE_ID, "==", imageId).Documents(c).GetAll()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		for _, doc := range docs {
+			vertices, err := tools.ConvertFirestoreVertices(doc.Data()[types.FIREBASE_FACES_FIELDS_VERTICES])
+			if err != nil {
+				tools.LogError(logger, c, err)
+				return
+			}
+
+			face := types.Face{
+				Id:       doc.Ref.ID,
+				Vertices: vertices,
+				ImageId:  imageId,
+				Version:  tools.GetDocumentVersion(doc.Data()),
+			}
+			face.Url, _ = doc.Data()[types.FIREBASE_FACES_FIELDS_URL].(string)
+			face.StoragePath, _ = doc.Data()[types.FIREBASE_FACES_FIELDS_STORAGE_PATH].(string)
+			face.Emotion, _ = doc.Data()[types.FIREBASE_FACES_FIELDS_EMOTION].(string)
+			if createdAt, ok := doc.Data()[types.FIREBASE_FACES_FIELDS_CREATED_AT].(time.Time); ok {
+				face.CreatedAt = createdAt.Format(time.RFC3339)
+			}
+
+			faces = append(faces, face)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"faces": faces,
+		})
+	}
+}
+
 func GetFacesOverlayHandler(logger *logging.Logger, firestoreClient *firestore.Client, storage *storage.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get Image ID
@@ -225,6 +350,7 @@ func GetFacesOverlayHandler(logger *logging.Logger, firestoreClient *firestore.C
 			return
 		}
 
+		tools.SetETagHeader(c, imageId, imageDoc)
 		c.JSON(http.StatusOK, gin.H{
 			"overlayId":          imageId,
 			"overlayUrl":         overlayUrl,
@@ -246,6 +372,13 @@ func DeleteFacesHandler(logger *logging.Logger, firestoreClient *firestore.Clien
 			return
 		}
 
+		// Each face is only deleted if it did not change since the client read it
+		expectedVersions, err := tools.ParseIfMatch(c)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
 		// collect ids for return
 		var deletedIds []string
 		var failedIds []string
@@ -253,7 +386,7 @@ func DeleteFacesHandler(logger *logging.Logger, firestoreClient *firestore.Clien
 		// Iterate over the faces and delete each one
 		for id, storagePath := range faces {
 			// Delete Firestore document
-			err := tools.DeleteFirestoreDocument(c, firestoreClient, types.FIREBASE_FACES_COLLECTION, id)
+			err := tools.DeleteFirestoreDocumentIfMatch(c, firestoreClient, types.FIREBASE_FACES_COLLECTION, id, expectedVersions)
 			if err != nil {
 				tools.LogError(logger, c, err)
 				failedIds = append(failedIds, id)
@@ -278,6 +411,8 @@ func DeleteFacesHandler(logger *logging.Logger, firestoreClient *firestore.Clien
 	}
 }
 
+// Removes the obscured overlays of images. The objects stay in storage for the posts and revisions showing them, they
+// are listed in the versions of the image and deleted with it.
 func DeleteObscuredFacesOverlayHandler(logger *logging.Logger, firestoreClient *firestore.Client, storage *storage.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the hash tags from the multipart form
@@ -289,43 +424,72 @@ func DeleteObscuredFacesOverlayHandler(logger *logging.Logger, firestoreClient *
 
 		imagesIds := form.Value["imagesIds"]
 
+		// Each obscured overlay is only removed if the image did not change since the client read it
+		expectedVersions, err := tools.ParseIfMatch(c)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
 		var deletedIds []string
 		var failedIds []string
 
 		for _, imageId := range imagesIds {
-			// Get the obscured faces overlay storage path
-			obscuredOverlayStoragePath := types.FIREBASE_STORAGE_OBSCURED_FACES_OVERLAY_FOLDER + imageId + ".png"
-
-			// TODO: obscured overlays are not created for each image like, overlays. So in order to avoid errors it would be necessary to provide explicitly the obscured ids to delete. But for now just check if it exists, if not log warning
-			// Check if the obscured overlay exists
-			exists, err := tools.CheckIfImageExistsInStorage(c, obscuredOverlayStoragePath, storage)
+			imageDoc, err := tools.GetFirestoreDocument(c, firestoreClient, types.FIREBASE_IMAGES_COLLECTION, imageId)
+			if err == nil && imageDoc == nil {
+				err = errors.New("image " + imageId + " does not exist")
+			}
 			if err != nil {
 				tools.LogError(logger, c, err)
 				failedIds = append(failedIds, imageId)
 				continue
 			}
 
-			if !exists {
-				logger.Log(logging.Entry{
-					Severity: logging.Warning,
-					Payload:  "Obscured overlay does not exist for image id: " + imageId,
-					Labels:   map[string]string{"status": "warning"},
-				})
-				continue
-			}
+			// Get the obscured faces overlay storage path, overlays set before they were versioned are named after the image
+			obscuredOverlayStoragePath, _ := imageDoc[types.FIREBASE_IMAGES_FIELDS_FACES_OBSCURED_OVERLAY_STORAGE_PATH].(string)
+			if obscuredOverlayStoragePath == "" {
+				obscuredOverlayStoragePath = types.FIREBASE_STORAGE_OBSCURED_FACES_OVERLAY_FOLDER + imageId + ".png"
 
-			// Delete the overlay from storage
-			if exists {
-				err = tools.DeleteObjectFromStorage(c, obscuredOverlayStoragePath, storage)
+				// TODO: obscured overlays are not created for each image like, overlays. So in order to avoid errors it would be necessary to provide explicitly the obscured ids to delete. But for now just check if it exists, if not log warning
+				exists, err := tools.CheckIfImageExistsInStorage(c, obscuredOverlayStoragePath, storage)
 				if err != nil {
 					tools.LogError(logger, c, err)
 					failedIds = append(failedIds, imageId)
+					continue
+				}
 
+				if !exists {
+					logger.Log(logging.Entry{
+						Severity: logging.Warning,
+						Payload:  "Obscured overlay does not exist for image id: " + imageId,
+						Labels:   map[string]string{"status": "warning"},
+					})
 					continue
 				}
+			}
 
-				deletedIds = append(deletedIds, imageId)
+			// The overlay and its rendition over the original are kept as versions of the image
+			versionsStoragePaths := []interface{}{obscuredOverlayStoragePath}
+			if path, ok := imageDoc[types.FIREBASE_IMAGES_FIELDS_OBSCURED_STORAGE_PATH].(string); ok && path != "" {
+				versionsStoragePaths = append(versionsStoragePaths, path)
 			}
+
+			err = tools.UpdateFirestoreDocumentIfMatch(c, firestoreClient, types.FIREBASE_IMAGES_COLLECTION, imageId, expectedVersions, map[string]interface{}{
+				types.FIREBASE_IMAGES_FIELDS_FACES_OBSCURED_OVERLAY_URL:          "",
+				types.FIREBASE_IMAGES_FIELDS_FACES_OBSCURED_OVERLAY_STORAGE_PATH: "",
+				types.FIREBASE_IMAGES_FIELDS_OBSCURED_URL:                        "",
+				types.FIREBASE_IMAGES_FIELDS_OBSCURED_STORAGE_PATH:               "",
+				types.FIREBASE_IMAGES_FIELDS_OBSCURED_FACES_IDS:                  nil,
+				types.FIREBASE_IMAGES_FIELDS_OBSCURED_REGIONS_IDS:                nil,
+				types.FIREBASE_IMAGES_FIELDS_OBSCURED_VERSIONS_STORAGE_PATHS:     firestore.ArrayUnion(versionsStoragePaths...),
+			})
+			if err != nil {
+				tools.LogError(logger, c, err)
+				failedIds = append(failedIds, imageId)
+				continue
+			}
+
+			deletedIds = append(deletedIds, imageId)
 		}
 
 		c.JSON(http.StatusOK, gin.H{
@@ -370,3 +534,200 @@ func DeleteFacesOverlayHandler(logger *logging.Logger, firestoreClient *firestor
 		})
 	}
 }
+
+// Creates an obscured copy of an animated image, faces tracks not selected stay visible
+func CreateObscuredAnimationHandler(logger *logging.Logger, firestoreClient *firestore.Client, storage *storage.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		imageId, imageIdProvided := c.GetPostForm("imageId")
+		if !imageIdProvided {
+			tools.LogError(logger, c, errors.New("Image ID not provided"))
+			return
+		}
+
+		// All faces tracks are obscured if none are selected
+		tracksIdsToObscure := c.PostFormArray("tracksIdsToObscure")
+
+		// The obscured animation is only replaced if the image did not change since the client read it
+		expectedVersions, err := tools.ParseIfMatch(c)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		imageDoc, err := tools.GetFirestoreDocument(c, firestoreClient, types.FIREBASE_IMAGES_COLLECTION, imageId)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		if imageDoc == nil {
+			tools.LogError(logger, c, errors.New("Image does not exist"))
+			return
+		}
+
+		err = tools.CheckDocumentVersion(expectedVersions, imageId, imageDoc)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		if animated, _ := imageDoc[types.FIREBASE_IMAGES_FIELDS_ANIMATED].(bool); !animated {
+			tools.LogError(logger, c, errors.New("Image is not animated"))
+			return
+		}
+
+		animationStoragePath, ok := imageDoc[types.FIREBASE_IMAGES_FIELDS_ANIMATION_STORAGE_PATH].(string)
+		if !ok {
+			tools.LogError(logger, c, errors.New("Animation storage path not found"))
+			return
+		}
+
+		tracks, err := tools.ConvertFirestoreFaceTracks(imageDoc[types.FIREBASE_IMAGES_FIELDS_ANIMATION_FACES_TRACKS])
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		// Tracks are stored with their boxes on the sampled frames, those stored before kept a box for every frame
+		if samplesData, ok := imageDoc[types.FIREBASE_IMAGES_FIELDS_ANIMATION_SAMPLED_FRAMES]; ok {
+			samples, err := tools.ConvertFirestoreSampledFrames(samplesData)
+			if err != nil {
+				tools.LogError(logger, c, err)
+				return
+			}
+
+			framesCount, _ := imageDoc[types.FIREBASE_IMAGES_FIELDS_ANIMATION_FRAMES_COUNT].(int64)
+			tracks = tools.InterpolateFaceTracks(samples, tracks, int(framesCount))
+		}
+
+		if len(tracksIdsToObscure) > 0 {
+			selected := map[string]bool{}
+			for _, trackId := range tracksIdsToObscure {
+				selected[trackId] = true
+			}
+
+			var selectedTracks []types.FaceTrack
+			for _, track := range tracks {
+				if selected[track.Id] {
+					selectedTracks = append(selectedTracks, track)
+				}
+			}
+			tracks = selectedTracks
+		}
+
+		// Draw the obscuring rectangles on every frame of the animation
+		g, err := tools.GetGifFromStorage(animationStoragePath, storage, c)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		frames, err := tools.ComposeGifFrames(g)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		obscuredData, err := tools.ObscureFacesInGif(g, frames, tracks)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		// The obscured animation is written as a new version, the image is switched to it by the conditional update
+		obscuredStoragePath, err := newObscuredStoragePath(types.FIREBASE_STORAGE_OBSCURED_FACES_OVERLAY_FOLDER, imageId, ".gif")
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		obscuredUrl, err := tools.GenerateImageUrl(c, firestoreClient, storage, obscuredData, types.FIREBASE_STORAGE_BUCKET, obscuredStoragePath)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			deleteOrphanedObscuredObject(c, logger, storage, obscuredStoragePath)
+			return
+		}
+
+		err = tools.UpdateFirestoreDocumentIfMatch(c, firestoreClient, types.FIREBASE_IMAGES_COLLECTION, imageId, expectedVersions, map[string]interface{}{
+			types.FIREBASE_IMAGES_FIELDS_ANIMATION_OBSCURED_URL:          obscuredUrl,
+			types.FIREBASE_IMAGES_FIELDS_ANIMATION_OBSCURED_STORAGE_PATH: obscuredStoragePath,
+			types.FIREBASE_IMAGES_FIELDS_OBSCURED_VERSIONS_STORAGE_PATHS: firestore.ArrayUnion(obscuredStoragePath),
+		})
+		if err != nil {
+			tools.LogError(logger, c, err)
+			deleteOrphanedObscuredObject(c, logger, storage, obscuredStoragePath)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"obscuredOverlay": types.ObscuredOverlay{
+				Id:          imageId,
+				Url:         obscuredUrl,
+				StoragePath: obscuredStoragePath,
+			},
+		})
+	}
+}
+
+// Returns the storage path of a new version of an obscured object of an image. Versions are never overwritten, so a
+// request losing the race on the image version can not replace the object of the winner, and posts keep the version
+// they show.
+func newObscuredStoragePath(folder string, imageId string, extension string) (string, error) {
+	version, err := tools.GenerateRandomName()
+	if err != nil {
+		return "", err
+	}
+
+	return folder + imageId + "_" + version + extension, nil
+}
+
+// Parses the comma separated ids of the faces or text regions covered by an overlay, they must belong to the image
+func parseObscuredIds(value string, imageIds interface{}) ([]string, error) {
+	ids := removeEmptyStrings(strings.Split(value, ","))
+	known := convertInterfaceToArrayString(imageIds)
+
+	for _, id := range ids {
+		if indexOfString(known, id) < 0 {
+			return nil, errors.New(id + " is not a face or text region of the image")
+		}
+	}
+
+	return ids, nil
+}
+
+// Renders the obscured overlay over the original image and stores the result as a new version of the obscured image
+func renderObscuredImage(c *gin.Context, logger *logging.Logger, firestoreClient *firestore.Client, storage *storage.Client, imageId string, imageStoragePath string, overlayStoragePath string) (string, string, error) {
+	if imageStoragePath == "" {
+		return "", "", errors.New("Image storage path not found")
+	}
+
+	data, err := tools.RenderObscuredImage(c, storage, imageStoragePath, overlayStoragePath)
+	if err != nil {
+		return "", "", err
+	}
+
+	obscuredStoragePath, err := newObscuredStoragePath(types.FIREBASE_STORAGE_OBSCURED_FOLDER, imageId, ".jpg")
+	if err != nil {
+		return "", "", err
+	}
+
+	url, err := tools.GenerateImageUrl(c, firestoreClient, storage, data, types.FIREBASE_STORAGE_BUCKET, obscuredStoragePath)
+	if err != nil {
+		deleteOrphanedObscuredObject(c, logger, storage, obscuredStoragePath)
+		return "", "", err
+	}
+
+	return obscuredStoragePath, url, nil
+}
+
+// Deletes an obscured object the image is not, or no longer, switched to, a failure only leaves an unused object
+func deleteOrphanedObscuredObject(c context.Context, logger *logging.Logger, storage *storage.Client, storagePath string) {
+	err := tools.DeleteObjectFromStorage(c, storagePath, storage)
+	if err != nil {
+		logger.Log(logging.Entry{
+			Severity: logging.Warning,
+			Payload:  "Error deleting unused obscured object " + storagePath + ": " + err.Error(),
+			Labels:   map[string]string{"status": "warning"},
+		})
+	}
+}
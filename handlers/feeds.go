@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"proteggo_api/tools"
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// RSS 2.0 feed, items have a single enclosure
+type rssFeed struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	AtomXmlns string     `xml:"xmlns:atom,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	SelfLink      atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	Guid        rssGuid       `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Description string        `xml:"description"`
+	Categories  []string      `xml:"category"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	Url    string `xml:"url,attr"`
+	Length int    `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// Atom feed, entries link to each of their images as enclosures
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	Id         string         `xml:"id"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Links      []atomLink     `xml:"link"`
+	Content    atomContent    `xml:"content"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// JSON Feed 1.1
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	HomePageUrl string         `json:"home_page_url"`
+	FeedUrl     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	Id            string                   `json:"id"`
+	Url           string                   `json:"url"`
+	Title         string                   `json:"title"`
+	ContentText   string                   `json:"content_text"`
+	DatePublished string                   `json:"date_published"`
+	DateModified  string                   `json:"date_modified"`
+	Tags          []string                 `json:"tags"`
+	Image         string                   `json:"image,omitempty"`
+	Attachments   []jsonFeedItemAttachment `json:"attachments"`
+}
+
+type jsonFeedItemAttachment struct {
+	Url      string `json:"url"`
+	MimeType string `json:"mime_type"`
+}
+
+// Gets a public post without signing in, with the obscured renditions of its images only
+func GetPublicPostHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+
+		post, found, err := getPublicPost(c, db, id)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "post " + id + " does not exist",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"post": convertPostToPublicPost(post),
+		})
+	}
+}
+
+// Serves the latest public posts as an RSS 2.0, Atom or JSON Feed, optionally only the posts with a hash tag.
+// Images are linked as they are served outside the app, through their obscured rendition or their original when they
+// have no faces or text regions. The links use the configured public base url, the feeds are cached publicly.
+func GetPostsFeedHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.Param("format")
+		if format != types.FEED_FORMAT_RSS && format != types.FEED_FORMAT_ATOM && format != types.FEED_FORMAT_JSON {
+			tools.LogError(logger, c, errors.New("format must be one of rss, atom or json"))
+			return
+		}
+
+		// Private, unlisted and authenticated posts are never syndicated
+		query := db.Collection(types.FIREBASE_POSTS_COLLECTION).
+			Where(types.FIREBASE_POSTS_FIELDS_DELETED_AT, "==", nil).
+			Where(types.FIREBASE_POSTS_FIELDS_STATUS, "==", types.POST_STATUS_PUBLISHED).
+			Where(types.FIREBASE_POSTS_FIELDS_VISIBILITY, "==", types.POST_VISIBILITY_PUBLIC)
+
+		title := types.FEEDS_TITLE
+		hashTag := c.Param("hashTag")
+		if hashTag != "" {
+			query = query.Where(types.FIREBASE_POSTS_FIELDS_HASH_TAGS_VALUES, "array-contains", hashTag)
+			title += " #" + hashTag
+		}
+
+		docs, err := query.OrderBy(types.FIREBASE_POSTS_FIELDS_PUBLISHED_AT, firestore.Desc).Limit(types.FEEDS_MAX_ITEMS).Documents(c).GetAll()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		posts := []types.PublicPost{}
+		for _, doc := range docs {
+			posts = append(posts, convertPostToPublicPost(convertDocumentToPost(doc.Data())))
+		}
+
+		baseUrl, err := tools.GetPublicBaseUrl()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+		feedUrl := baseUrl + c.Request.URL.Path
+
+		c.Header("Cache-Control", "public, max-age="+strconv.Itoa(types.FEEDS_CACHE_MAX_AGE_SECONDS))
+
+		switch format {
+		case types.FEED_FORMAT_RSS:
+			writeFeedXml(c, logger, "application/rss+xml; charset=utf-8", buildRssFeed(title, baseUrl, feedUrl, posts))
+		case types.FEED_FORMAT_ATOM:
+			writeFeedXml(c, logger, "application/atom+xml; charset=utf-8", buildAtomFeed(title, baseUrl, feedUrl, posts))
+		default:
+			data, err := json.MarshalIndent(buildJsonFeed(title, baseUrl, feedUrl, posts), "", "  ")
+			if err != nil {
+				tools.LogError(logger, c, err)
+				return
+			}
+
+			c.Data(http.StatusOK, "application/feed+json; charset=utf-8", data)
+		}
+	}
+}
+
+func buildRssFeed(title string, baseUrl string, feedUrl string, posts []types.PublicPost) rssFeed {
+	feed := rssFeed{
+		Version:   "2.0",
+		AtomXmlns: "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         title,
+			Link:          baseUrl,
+			Description:   types.FEEDS_DESCRIPTION,
+			SelfLink:      atomLink{Href: feedUrl, Rel: "self", Type: "application/rss+xml"},
+			LastBuildDate: time.Now().Format(time.RFC1123Z),
+			Items:         []rssItem{},
+		},
+	}
+
+	for _, post := range posts {
+		item := rssItem{
+			Title:       getFeedItemTitle(post),
+			Link:        getPublicPostUrl(baseUrl, post.Id),
+			Guid:        rssGuid{IsPermaLink: false, Value: post.Id},
+			PubDate:     formatFeedDate(post.PublishedAt, time.RFC1123Z),
+			Description: post.Body,
+			Categories:  post.HashTagsValues,
+		}
+
+		if len(post.ImagesUrls) > 0 {
+			item.Enclosure = &rssEnclosure{Url: post.ImagesUrls[0], Length: 0, Type: post.ImagesTypes[0]}
+		}
+
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	return feed
+}
+
+func buildAtomFeed(title string, baseUrl string, feedUrl string, posts []types.PublicPost) atomFeed {
+	feed := atomFeed{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		Title:   title,
+		Id:      feedUrl,
+		Updated: time.Now().Format(time.RFC3339),
+		Author:  atomAuthor{Name: types.FEEDS_TITLE},
+		Links: []atomLink{
+			{Href: feedUrl, Rel: "self", Type: "application/atom+xml"},
+			{Href: baseUrl, Rel: "alternate"},
+		},
+		Entries: []atomEntry{},
+	}
+
+	// The feed was last updated by its most recently changed post
+	if len(posts) > 0 {
+		feed.Updated = posts[0].UpdatedAt
+		for _, post := range posts {
+			if post.UpdatedAt > feed.Updated {
+				feed.Updated = post.UpdatedAt
+			}
+		}
+	}
+
+	for _, post := range posts {
+		postUrl := getPublicPostUrl(baseUrl, post.Id)
+		entry := atomEntry{
+			Title:      getFeedItemTitle(post),
+			Id:         postUrl,
+			Published:  post.PublishedAt,
+			Updated:    post.UpdatedAt,
+			Links:      []atomLink{{Href: postUrl, Rel: "alternate"}},
+			Content:    atomContent{Type: "text", Value: post.Body},
+			Categories: []atomCategory{},
+		}
+
+		for i, imageUrl := range post.ImagesUrls {
+			entry.Links = append(entry.Links, atomLink{Href: imageUrl, Rel: "enclosure", Type: post.ImagesTypes[i]})
+		}
+
+		for _, hashTag := range post.HashTagsValues {
+			entry.Categories = append(entry.Categories, atomCategory{Term: hashTag})
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	return feed
+}
+
+func buildJsonFeed(title string, baseUrl string, feedUrl string, posts []types.PublicPost) jsonFeed {
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       title,
+		Description: types.FEEDS_DESCRIPTION,
+		HomePageUrl: baseUrl,
+		FeedUrl:     feedUrl,
+		Items:       []jsonFeedItem{},
+	}
+
+	for _, post := range posts {
+		item := jsonFeedItem{
+			Id:            post.Id,
+			Url:           getPublicPostUrl(baseUrl, post.Id),
+			Title:         getFeedItemTitle(post),
+			ContentText:   post.Body,
+			DatePublished: post.PublishedAt,
+			DateModified:  post.UpdatedAt,
+			Tags:          post.HashTagsValues,
+			Attachments:   []jsonFeedItemAttachment{},
+		}
+
+		for i, imageUrl := range post.ImagesUrls {
+			item.Attachments = append(item.Attachments, jsonFeedItemAttachment{Url: imageUrl, MimeType: post.ImagesTypes[i]})
+		}
+
+		if len(post.ImagesUrls) > 0 {
+			item.Image = post.ImagesUrls[0]
+		}
+
+		feed.Items = append(feed.Items, item)
+	}
+
+	return feed
+}
+
+func writeFeedXml(c *gin.Context, logger *logging.Logger, contentType string, feed interface{}) {
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		tools.LogError(logger, c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, contentType, append([]byte(xml.Header), data...))
+}
+
+// Gets a post which is public, found is false when it does not exist or is not public
+func getPublicPost(c context.Context, db *firestore.Client, id string) (types.Post, bool, error) {
+	data, err := tools.GetFirestoreDocument(c, db, types.FIREBASE_POSTS_COLLECTION, id)
+	if err != nil || data == nil {
+		return types.Post{}, false, err
+	}
+
+	post := convertDocumentToPost(data)
+	return post, isPublicPost(post), nil
+}
+
+// Returns the url of the page of a public post outside the app
+func getPublicPostUrl(baseUrl string, id string) string {
+	return baseUrl + "/api/public/posts/" + url.PathEscape(id) + "/page"
+}
+
+// Posts have no title, the first line of their body is used
+func getFeedItemTitle(post types.PublicPost) string {
+	title := strings.TrimSpace(strings.SplitN(strings.TrimSpace(post.Body), "\n", 2)[0])
+	if title == "" {
+		return "Post " + post.Id
+	}
+
+	if utf8.RuneCountInString(title) > types.FEEDS_ITEM_TITLE_MAX_LENGTH {
+		title = string([]rune(title)[:types.FEEDS_ITEM_TITLE_MAX_LENGTH-1]) + "…"
+	}
+
+	return title
+}
+
+// Formats an RFC 3339 creation time in the layout of the feed
+func formatFeedDate(value string, layout string) string {
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return value
+	}
+
+	return date.Format(layout)
+}
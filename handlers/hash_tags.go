@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"proteggo_api/tools"
 	"proteggo_api/types"
 	"strconv"
+	"strings"
 
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/logging"
@@ -25,33 +27,11 @@ func DeleteHashTagsHandler(logger *logging.Logger, firestoreClient *firestore.Cl
 		hashTagsIds := form.Value["hashTagsIds"]
 
 		for _, hashTagId := range hashTagsIds {
-			hashTag, err := tools.GetFirestoreDocument(c, firestoreClient, types.FIREBASE_POSTS_HASHTAGS_COLLECTION, hashTagId)
+			err := decrementHashTagScore(c, firestoreClient, hashTagId)
 			if err != nil {
 				tools.LogError(logger, c, err)
 				return
 			}
-
-			score := int(hashTag[types.FIREBASE_POSTS_HASHTAGS_FIELDS_SCORE].(int64))
-
-			// If the score is 1, delete the hash tag from Firestore
-			if score <= 1 {
-				err := tools.DeleteFirestoreDocument(c, firestoreClient, types.FIREBASE_POSTS_HASHTAGS_COLLECTION, hashTagId)
-				if err != nil {
-					tools.LogError(logger, c, err)
-					return
-				}
-			} else {
-				// If the score is greater than 1, decrement the score by 1
-				err := tools.SetFirestoreDocument(c, firestoreClient, types.FIREBASE_POSTS_HASHTAGS_COLLECTION, hashTagId, map[string]interface{}{
-					types.FIREBASE_POSTS_HASHTAGS_FIELDS_ID:    hashTagId,
-					types.FIREBASE_POSTS_HASHTAGS_FIELDS_VALUE: hashTag[types.FIREBASE_POSTS_HASHTAGS_FIELDS_VALUE],
-					types.FIREBASE_POSTS_HASHTAGS_FIELDS_SCORE: score - 1,
-				})
-				if err != nil {
-					tools.LogError(logger, c, err)
-					return
-				}
-			}
 		}
 
 		c.JSON(http.StatusOK, gin.H{
@@ -75,25 +55,7 @@ func SetHashTagsHandler(logger *logging.Logger, db *firestore.Client) gin.Handle
 
 		// Add each hash tag to the hashTags document
 		for i, hashTagId := range hashTagsIds {
-			doc, err := tools.GetFirestoreDocument(c, db, types.FIREBASE_POSTS_HASHTAGS_COLLECTION, hashTagId)
-
-			if err != nil {
-				tools.LogError(logger, c, err)
-				return
-			}
-
-			score := 1
-
-			if doc != nil {
-				score = int(doc[types.FIREBASE_POSTS_HASHTAGS_FIELDS_SCORE].(int64)) + 1
-			}
-
-			err = tools.SetFirestoreDocument(c, db, types.FIREBASE_POSTS_HASHTAGS_COLLECTION, hashTagId, map[string]interface{}{
-				types.FIREBASE_POSTS_HASHTAGS_FIELDS_ID:    hashTagId,
-				types.FIREBASE_POSTS_HASHTAGS_FIELDS_VALUE: hashTagsValues[i],
-				types.FIREBASE_POSTS_HASHTAGS_FIELDS_SCORE: score,
-			})
-
+			err := incrementHashTagScore(c, db, hashTagId, hashTagsValues[i])
 			if err != nil {
 				tools.LogError(logger, c, err)
 				return
@@ -185,3 +147,120 @@ func GetTopScoredHashTagsHandler(logger *logging.Logger, db *firestore.Client) g
 		})
 	}
 }
+
+func GetHashTagsSuggestionsHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		imagesIdsStr, imagesIdsProvided := c.GetQuery("imagesIds")
+		if !imagesIdsProvided {
+			tools.LogError(logger, c, errors.New("imagesIds query parameter is required"))
+			return
+		}
+
+		// Collect the labels of all requested images
+		var labels []types.Label
+		for _, imageId := range strings.Split(imagesIdsStr, ",") {
+			image, err := tools.GetFirestoreDocument(c, db, types.FIREBASE_IMAGES_COLLECTION, imageId)
+			if err != nil {
+				tools.LogError(logger, c, err)
+				return
+			}
+
+			// Images hidden from the listings are only read by admins
+			if image == nil || (!tools.IsAdminUser(c) && (!tools.IsImageModerationApproved(image) || tools.IsDocumentInTrash(image))) {
+				tools.LogError(logger, c, errors.New("image "+imageId+" does not exist"))
+				return
+			}
+
+			labelsData, _ := image[types.FIREBASE_IMAGES_FIELDS_LABELS].([]interface{})
+			for _, labelData := range labelsData {
+				labelMap, ok := labelData.(map[string]interface{})
+				if !ok {
+					continue
+				}
+
+				description, _ := labelMap[types.FIREBASE_IMAGES_FIELDS_LABELS_DESCRIPTION].(string)
+				score, _ := labelMap[types.FIREBASE_IMAGES_FIELDS_LABELS_SCORE].(float64) // Firestore uses float64 for numbers
+
+				labels = append(labels, types.Label{
+					Description: description,
+					Score:       float32(score),
+				})
+			}
+		}
+
+		// Get all the existing hash tags to match the labels against
+		hashTagsDocs, err := tools.GetFirestoreDocuments(c, db, types.FIREBASE_POSTS_HASHTAGS_COLLECTION)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		var hashTags []types.HashTag
+		for _, doc := range hashTagsDocs {
+			hashTags = append(hashTags, types.HashTag{
+				Id:    doc[types.FIREBASE_POSTS_HASHTAGS_FIELDS_ID].(string),
+				Value: doc[types.FIREBASE_POSTS_HASHTAGS_FIELDS_VALUE].(string),
+				Score: int(doc[types.FIREBASE_POSTS_HASHTAGS_FIELDS_SCORE].(int64)),
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"suggestions": tools.SuggestHashTags(labels, hashTags),
+		})
+	}
+}
+
+// Increments the score of the hash tag, creating it if it does not exist yet
+func incrementHashTagScore(c context.Context, db *firestore.Client, hashTagId string, hashTagValue string) error {
+	return db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+		hashTag, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_POSTS_HASHTAGS_COLLECTION, hashTagId)
+		if err != nil {
+			return err
+		}
+
+		return changeHashTagScore(tx, db, hashTagId, hashTagValue, hashTag, 1)
+	})
+}
+
+// Decrements the score of the hash tag, deleting it when it is no longer used
+func decrementHashTagScore(c context.Context, db *firestore.Client, hashTagId string) error {
+	return db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+		hashTag, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_POSTS_HASHTAGS_COLLECTION, hashTagId)
+		if err != nil {
+			return err
+		}
+
+		return changeHashTagScore(tx, db, hashTagId, "", hashTag, -1)
+	})
+}
+
+// Writes the hash tag score changed by delta within the transaction, the hash tag must be read before in the same transaction.
+// Hash tags with a score dropping below 1 are deleted, an empty value keeps the stored one. Decrementing a missing hash tag
+// leaves it missing.
+func changeHashTagScore(tx *firestore.Transaction, db *firestore.Client, hashTagId string, hashTagValue string, hashTag map[string]interface{}, delta int) error {
+	docRef := db.Collection(types.FIREBASE_POSTS_HASHTAGS_COLLECTION).Doc(hashTagId)
+
+	score := 0
+	if hashTag != nil {
+		score = int(hashTag[types.FIREBASE_POSTS_HASHTAGS_FIELDS_SCORE].(int64))
+		if hashTagValue == "" {
+			hashTagValue, _ = hashTag[types.FIREBASE_POSTS_HASHTAGS_FIELDS_VALUE].(string)
+		}
+	} else if delta < 0 {
+		// Posts created before their hash tags were counted on creation may use hash tags which were never stored
+		return nil
+	}
+
+	score += delta
+
+	// If the score drops to 0, delete the hash tag from Firestore
+	if score < 1 {
+		return tx.Delete(docRef)
+	}
+
+	return tx.Set(docRef, map[string]interface{}{
+		types.FIREBASE_POSTS_HASHTAGS_FIELDS_ID:    hashTagId,
+		types.FIREBASE_POSTS_HASHTAGS_FIELDS_VALUE: hashTagValue,
+		types.FIREBASE_POSTS_HASHTAGS_FIELDS_SCORE: score,
+	})
+}
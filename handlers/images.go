@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"proteggo_api/middlewares"
 	"proteggo_api/tasks"
@@ -118,8 +122,10 @@ func DeleteUnusedImagesHandler(logger *logging.Logger, firestoreClient *firestor
 
 		// Get all the images and check if they are used in any post
 		for _, doc := range docs {
-			var postId = doc.Data()[types.FIREBASE_IMAGES_FIELDS_POST_ID]
-			if postId == nil {
+			// Images in the trash are deleted by the trash purge once the retention period is over, images in albums are kept
+			postsIds := tools.GetImagePostsIds(doc.Data())
+			albumsCount, _ := doc.Data()[types.FIREBASE_IMAGES_FIELDS_ALBUMS_COUNT].(int64)
+			if len(postsIds) == 0 && albumsCount <= 0 && !tools.IsDocumentInTrash(doc.Data()) {
 				id, idOk := doc.Data()[types.FIREBASE_IMAGES_FIELDS_ID].(string)
 				if !idOk {
 					tools.LogError(logger, c, errors.New("Error casting id to string"))
@@ -134,6 +140,7 @@ func DeleteUnusedImagesHandler(logger *logging.Logger, firestoreClient *firestor
 
 				facesIdsInterface, facesIdsOk := doc.Data()[types.FIREBASE_IMAGES_FIELDS_FACES_IDS]
 				facesOverlayStoragePathInterface, facesOverlayStoragePathOk := doc.Data()[types.FIREBASE_IMAGES_FIELDS_FACES_OVERLAY_STORAGE_PATH]
+				regionsIds := convertInterfaceToArrayString(doc.Data()[types.FIREBASE_IMAGES_FIELDS_REGIONS_IDS])
 
 				err := tools.DeleteFirestoreDocument(c, firestoreClient, types.FIREBASE_IMAGES_COLLECTION, id)
 				if err != nil {
@@ -179,6 +186,28 @@ func DeleteUnusedImagesHandler(logger *logging.Logger, firestoreClient *firestor
 					}
 				}
 
+				// Delete the text regions of the image
+				for _, regionId := range regionsIds {
+					err = tools.DeleteFirestoreDocument(c, firestoreClient, types.FIREBASE_REGIONS_COLLECTION, regionId)
+					if err != nil {
+						tools.LogError(logger, c, err)
+						return
+					}
+				}
+
+				// Delete the animation of the image
+				err = deleteImageAnimation(c, doc.Data(), storage)
+				if err != nil {
+					tools.LogError(logger, c, err)
+					return
+				}
+
+				err = deleteImageObscuredVersions(c, doc.Data(), storage)
+				if err != nil {
+					tools.LogError(logger, c, err)
+					return
+				}
+
 				// Check if image has faces overlay
 				if facesOverlayStoragePathOk && facesOverlayStoragePathInterface != nil {
 					facesOverlayStoragePath, ok := facesOverlayStoragePathInterface.(string)
@@ -205,9 +234,10 @@ func DeleteUnusedImagesHandler(logger *logging.Logger, firestoreClient *firestor
 	}
 }
 
-func DeleteImagesHandler(logger *logging.Logger, firestoreClient *firestore.Client, storage *storage.Client) gin.HandlerFunc {
+// Moves images to the trash, images used by a post are refused and are purged with the last post using them instead
+func DeleteImagesHandler(logger *logging.Logger, firestoreClient *firestore.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get the images
+		// Get the images, the storage paths are read from the image documents when they are purged
 		var images map[string]string
 		imagesJson := c.PostForm("imagesToDelete")
 		err := json.Unmarshal([]byte(imagesJson), &images)
@@ -216,22 +246,48 @@ func DeleteImagesHandler(logger *logging.Logger, firestoreClient *firestore.Clie
 			return
 		}
 
+		// Each image is only deleted if it did not change since the client read it
+		expectedVersions, err := tools.ParseIfMatch(c)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
 		// collect ids for return
 		var deletedIds []string
 		var failedIds []string
 
-		// Iterate over the images and delete each one
-		for id, storagePath := range images {
-			// Delete Firestore document
-			err := tools.DeleteFirestoreDocument(c, firestoreClient, types.FIREBASE_IMAGES_COLLECTION, id)
-			if err != nil {
-				tools.LogError(logger, c, err)
-				failedIds = append(failedIds, id)
-				continue
-			}
+		// Iterate over the images and move each one to the trash
+		for id := range images {
+			err := firestoreClient.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+				image, err := tools.GetFirestoreDocumentInTransaction(tx, firestoreClient, types.FIREBASE_IMAGES_COLLECTION, id)
+				if err != nil {
+					return err
+				}
+
+				if image == nil {
+					return errors.New("image " + id + " does not exist")
+				}
+
+				if postsIds := tools.GetImagePostsIds(image); len(postsIds) > 0 {
+					return errors.New("image " + id + " is used by posts " + strings.Join(postsIds, ", "))
+				}
+
+				if albumsCount, _ := image[types.FIREBASE_IMAGES_FIELDS_ALBUMS_COUNT].(int64); albumsCount > 0 {
+					return errors.New("image " + id + " is in " + strconv.FormatInt(albumsCount, 10) + " albums")
+				}
+
+				err = tools.CheckDocumentVersion(expectedVersions, id, image)
+				if err != nil {
+					return err
+				}
+
+				return tx.Update(firestoreClient.Collection(types.FIREBASE_IMAGES_COLLECTION).Doc(id), []firestore.Update{
+					{Path: types.FIREBASE_IMAGES_FIELDS_DELETED_AT, Value: firestore.ServerTimestamp},
+					{Path: types.FIREBASE_IMAGES_FIELDS_VERSION, Value: firestore.Increment(1)},
+				})
+			})
 
-			// Delete image from storage
-			err = tools.DeleteObjectFromStorage(c, storagePath, storage)
 			if err != nil {
 				tools.LogError(logger, c, err)
 				failedIds = append(failedIds, id)
@@ -248,6 +304,94 @@ func DeleteImagesHandler(logger *logging.Logger, firestoreClient *firestore.Clie
 	}
 }
 
+// Gets a single image with its version as the ETag, non admins get the obscured rendition and no text of images with
+// faces or text regions
+func GetImageHandler(logger *logging.Logger, client *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(types.FIREBASE_IMAGES_FIELDS_ID)
+
+		data, err := tools.GetFirestoreDocument(c, client, types.FIREBASE_IMAGES_COLLECTION, id)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		// Images hidden from the listings are only read by admins
+		if data == nil || (!tools.IsAdminUser(c) && (!tools.IsImageModerationApproved(data) || tools.IsDocumentInTrash(data))) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "image " + id + " does not exist",
+			})
+			return
+		}
+
+		image := convertDocumentToImage(id, data)
+		redactSensitiveImage(c, &image, data)
+
+		tools.SetETagHeader(c, id, data)
+		c.JSON(http.StatusOK, gin.H{
+			"image": image,
+		})
+	}
+}
+
+// Gets the posts using an image which the user can read, newest first
+func GetImagePostsHandler(logger *logging.Logger, client *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(types.FIREBASE_IMAGES_FIELDS_ID)
+
+		data, err := tools.GetFirestoreDocument(c, client, types.FIREBASE_IMAGES_COLLECTION, id)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		if data == nil || !canReadImage(c, data) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "image " + id + " does not exist",
+			})
+			return
+		}
+
+		postsIds := tools.GetImagePostsIds(data)
+		refs := make([]*firestore.DocumentRef, len(postsIds))
+		for i, postId := range postsIds {
+			refs[i] = client.Collection(types.FIREBASE_POSTS_COLLECTION).Doc(postId)
+		}
+
+		docs, err := client.GetAll(c, refs)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		posts := []types.Post{}
+		for _, doc := range docs {
+			if !doc.Exists() {
+				continue
+			}
+
+			post := convertDocumentToPost(doc.Data())
+			if canReadPost(c, post) {
+				posts = append(posts, post)
+			}
+		}
+
+		sort.Slice(posts, func(i, j int) bool {
+			return posts[i].PublishedAt > posts[j].PublishedAt
+		})
+
+		err = addPostsReactions(c, client, posts)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"posts": posts,
+		})
+	}
+}
+
 func GetImagesHandler(logger *logging.Logger, client *firestore.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the page size and page token from the query parameters
@@ -273,6 +417,10 @@ func GetImagesHandler(logger *logging.Logger, client *firestore.Client) gin.Hand
 		var paths []string
 		var nextPageToken string
 
+		// Posts read so far by id, nil when missing or not listed to the user
+		listedPosts := map[string]*types.Post{}
+		admin := tools.IsAdminUser(c)
+
 		for {
 			doc, err := iter.Next()
 			if err == iterator.Done {
@@ -283,18 +431,56 @@ func GetImagesHandler(logger *logging.Logger, client *firestore.Client) gin.Hand
 				return
 			}
 
+			// Set the next page token to the name of the current document
+			nextPageToken = doc.Ref.ID
+
+			// Skip images that are quarantined or rejected by moderation, or in the trash
+			if !tools.IsImageModerationApproved(doc.Data()) || tools.IsDocumentInTrash(doc.Data()) {
+				continue
+			}
+
+			// Images which are not in a post listed to the user are only listed to admins
+			if !admin {
+				listed := false
+				for _, postId := range tools.GetImagePostsIds(doc.Data()) {
+					post, read := listedPosts[postId]
+					if !read {
+						post, err = getListedPost(c, client, postId)
+						if err != nil {
+							tools.LogError(logger, c, err)
+							return
+						}
+
+						listedPosts[postId] = post
+					}
+
+					if post != nil {
+						listed = true
+						break
+					}
+				}
+
+				if !listed {
+					continue
+				}
+			}
+
 			// Get the URL from the document
-			url, ok := doc.Data()["url"].(string)
-			if !ok {
+			if _, ok := doc.Data()["url"].(string); !ok {
 				tools.LogError(logger, c, errors.New("Error getting URL from document"))
 				return
 			}
 
-			// Add the URL to the paths
-			paths = append(paths, url)
+			// Images with faces or text regions are listed through their obscured rendition to non admins, and left out
+			// until they are obscured
+			image := convertDocumentToImage(doc.Ref.ID, doc.Data())
+			redactSensitiveImage(c, &image, doc.Data())
+			if image.Url == "" {
+				continue
+			}
 
-			// Set the next page token to the name of the current document
-			nextPageToken = doc.Ref.ID
+			// Add the URL to the paths
+			paths = append(paths, image.Url)
 		}
 
 		c.JSON(http.StatusOK, gin.H{
@@ -303,3 +489,217 @@ func GetImagesHandler(logger *logging.Logger, client *firestore.Client) gin.Hand
 		})
 	}
 }
+
+// Searches images by the text extracted from them, also returns the posts those images are used in
+func SearchImagesByTextHandler(logger *logging.Logger, client *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		images := []types.Image{}
+		posts := []types.Post{}
+
+		text, textProvided := c.GetQuery("text")
+		if !textProvided {
+			tools.LogError(logger, c, errors.New("text query parameter is required"))
+			return
+		}
+
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		if limit < 1 {
+			tools.LogError(logger, c, errors.New("limit must be greater than 0"))
+			return
+		}
+
+		// Larger limits are cut down like the posts pages, each image found can read the posts using it
+		limit = min(limit, types.POSTS_PAGE_MAX_SIZE)
+
+		tokens := tools.TokenizeText(text)
+		if len(tokens) == 0 {
+			tools.LogError(logger, c, errors.New("text must contain at least one word"))
+			return
+		}
+
+		// Query by the longest token as it is usually the most selective one, the rest of the query is checked below
+		longestToken := tokens[0]
+		for _, token := range tokens {
+			if len(token) > len(longestToken) {
+				longestToken = token
+			}
+		}
+
+		// The images scanned are bounded like the posts scanned for a page, rarely matching queries return fewer images
+		query := client.Collection(types.FIREBASE_IMAGES_COLLECTION).
+			Where(types.FIREBASE_IMAGES_FIELDS_TEXT_TOKENS, "array-contains", longestToken).
+			Limit(types.POSTS_SCAN_BATCH_SIZE * types.POSTS_SCAN_MAX_BATCHES)
+
+		iter := query.Documents(c)
+		defer iter.Stop()
+
+		// Posts read so far by id, nil when missing or not listed to the user
+		listedPosts := map[string]*types.Post{}
+		admin := tools.IsAdminUser(c)
+
+		for len(images) < limit {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				tools.LogError(logger, c, err)
+				return
+			}
+
+			imageText, _ := doc.Data()[types.FIREBASE_IMAGES_FIELDS_TEXT].(string)
+			if !tools.TextMatchesQuery(imageText, text) || !tools.IsImageModerationApproved(doc.Data()) || tools.IsDocumentInTrash(doc.Data()) {
+				continue
+			}
+
+			// Only the posts listed to the user are returned with the image
+			postsIdsOfImage := []string{}
+			for _, postId := range tools.GetImagePostsIds(doc.Data()) {
+				post, read := listedPosts[postId]
+				if !read {
+					post, err = getListedPost(c, client, postId)
+					if err != nil {
+						tools.LogError(logger, c, err)
+						return
+					}
+
+					listedPosts[postId] = post
+					if post != nil {
+						posts = append(posts, *post)
+					}
+				}
+
+				if post != nil {
+					postsIdsOfImage = append(postsIdsOfImage, postId)
+				}
+			}
+
+			// Images which are not in a post listed to the user are only found by admins
+			if !admin && len(postsIdsOfImage) == 0 {
+				continue
+			}
+
+			createdAt := ""
+			if t, ok := doc.Data()[types.FIREBASE_IMAGES_FIELDS_CREATED_AT].(time.Time); ok {
+				createdAt = t.Format(time.RFC3339)
+			}
+
+			image := types.Image{
+				Id:        doc.Ref.ID,
+				CreatedAt: createdAt,
+				PostsIds:  postsIdsOfImage,
+				FacesIds:  convertInterfaceToArrayString(doc.Data()[types.FIREBASE_IMAGES_FIELDS_FACES_IDS]),
+				Text:      imageText,
+				Version:   tools.GetDocumentVersion(doc.Data()),
+			}
+			image.Url, _ = doc.Data()[types.FIREBASE_IMAGES_FIELDS_URL].(string)
+			image.StoragePath, _ = doc.Data()[types.FIREBASE_IMAGES_FIELDS_STORAGE_PATH].(string)
+			redactSensitiveImage(c, &image, doc.Data())
+
+			images = append(images, image)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"images": images,
+			"posts":  posts,
+		})
+	}
+}
+
+// Deletes the original and the obscured animation of an animated image from storage
+func deleteImageAnimation(c context.Context, image map[string]interface{}, storage *storage.Client) error {
+	for _, path := range getImageAnimationStoragePaths(image) {
+		err := tools.DeleteObjectFromStorage(c, path, storage)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Gets a post if it is listed to the user, drafts, scheduled, private and unlisted posts are only listed to admins
+func getListedPost(c *gin.Context, client *firestore.Client, postId string) (*types.Post, error) {
+	data, err := tools.GetFirestoreDocument(c, client, types.FIREBASE_POSTS_COLLECTION, postId)
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	post := convertDocumentToPost(data)
+	if !canListPost(c, post) {
+		return nil, nil
+	}
+
+	return &post, nil
+}
+
+// Deletes every version of the obscured overlay and animation of an image from storage
+func deleteImageObscuredVersions(c context.Context, image map[string]interface{}, storageClient *storage.Client) error {
+	for _, path := range convertInterfaceToArrayString(image[types.FIREBASE_IMAGES_FIELDS_OBSCURED_VERSIONS_STORAGE_PATHS]) {
+		err := tools.DeleteObjectFromStorage(c, path, storageClient)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Gets the storage paths of the original and the obscured animation of an animated image
+func getImageAnimationStoragePaths(image map[string]interface{}) []string {
+	paths := []string{}
+	for _, field := range []string{types.FIREBASE_IMAGES_FIELDS_ANIMATION_STORAGE_PATH, types.FIREBASE_IMAGES_FIELDS_ANIMATION_OBSCURED_STORAGE_PATH} {
+		if path, ok := image[field].(string); ok && path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// Converts an image document data to an Image
+func convertDocumentToImage(id string, data map[string]interface{}) types.Image {
+	image := types.Image{
+		Id:       id,
+		FacesIds: convertInterfaceToArrayString(data[types.FIREBASE_IMAGES_FIELDS_FACES_IDS]),
+		Version:  tools.GetDocumentVersion(data),
+	}
+
+	image.Url, _ = data[types.FIREBASE_IMAGES_FIELDS_URL].(string)
+	image.StoragePath, _ = data[types.FIREBASE_IMAGES_FIELDS_STORAGE_PATH].(string)
+	image.Text, _ = data[types.FIREBASE_IMAGES_FIELDS_TEXT].(string)
+	image.AlbumsCount, _ = data[types.FIREBASE_IMAGES_FIELDS_ALBUMS_COUNT].(int64)
+	image.PostsIds = tools.GetImagePostsIds(data)
+	if createdAt, ok := data[types.FIREBASE_IMAGES_FIELDS_CREATED_AT].(time.Time); ok {
+		image.CreatedAt = createdAt.Format(time.RFC3339)
+	}
+	if deletedAt, ok := data[types.FIREBASE_IMAGES_FIELDS_DELETED_AT].(time.Time); ok {
+		image.DeletedAt = deletedAt.Format(time.RFC3339)
+	}
+
+	return image
+}
+
+// Replaces the original and the text of an image with faces or text regions for non admins, who get the obscured
+// rendition, if any, and no text. The rendition of an animated image is its obscured animation.
+func redactSensitiveImage(c *gin.Context, image *types.Image, data map[string]interface{}) {
+	regionsIds := convertInterfaceToArrayString(data[types.FIREBASE_IMAGES_FIELDS_REGIONS_IDS])
+	if tools.IsAdminUser(c) || (len(image.FacesIds) == 0 && len(regionsIds) == 0) {
+		return
+	}
+
+	image.Url, _ = data[types.FIREBASE_IMAGES_FIELDS_OBSCURED_URL].(string)
+	image.StoragePath, _ = data[types.FIREBASE_IMAGES_FIELDS_OBSCURED_STORAGE_PATH].(string)
+	image.Text = ""
+
+	// Animated images are shown through their obscured animation once it is rendered
+	if animationUrl, _ := data[types.FIREBASE_IMAGES_FIELDS_ANIMATION_OBSCURED_URL].(string); animationUrl != "" {
+		image.Url = animationUrl
+		image.StoragePath, _ = data[types.FIREBASE_IMAGES_FIELDS_ANIMATION_OBSCURED_STORAGE_PATH].(string)
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"proteggo_api/tools"
+	"proteggo_api/types"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/api/iterator"
+)
+
+func GetModerationPolicyHandler(logger *logging.Logger, firestoreClient *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policy, err := tools.GetModerationPolicy(c, firestoreClient)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"policy": policy,
+		})
+	}
+}
+
+func SetModerationPolicyHandler(logger *logging.Logger, firestoreClient *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Start from the current policy, so only provided categories are changed
+		policy, err := tools.GetModerationPolicy(c, firestoreClient)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		policy.Adult = c.DefaultPostForm(types.FIREBASE_MODERATION_POLICY_FIELDS_ADULT, policy.Adult)
+		policy.Violence = c.DefaultPostForm(types.FIREBASE_MODERATION_POLICY_FIELDS_VIOLENCE, policy.Violence)
+		policy.Racy = c.DefaultPostForm(types.FIREBASE_MODERATION_POLICY_FIELDS_RACY, policy.Racy)
+
+		for _, likelihood := range []string{policy.Adult, policy.Violence, policy.Racy} {
+			if err := tools.ValidateLikelihood(likelihood); err != nil {
+				tools.LogError(logger, c, err)
+				return
+			}
+		}
+
+		err = tools.SetFirestoreDocument(c, firestoreClient, types.FIREBASE_SETTINGS_COLLECTION, types.FIREBASE_SETTINGS_MODERATION_POLICY_DOCUMENT, map[string]interface{}{
+			types.FIREBASE_MODERATION_POLICY_FIELDS_ADULT:    policy.Adult,
+			types.FIREBASE_MODERATION_POLICY_FIELDS_VIOLENCE: policy.Violence,
+			types.FIREBASE_MODERATION_POLICY_FIELDS_RACY:     policy.Racy,
+		})
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"policy": policy,
+		})
+	}
+}
+
+func GetQuarantinedImagesHandler(logger *logging.Logger, firestoreClient *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var images []gin.H
+
+		query := firestoreClient.Collection(types.FIREBASE_IMAGES_COLLECTION).Where(types.FIREBASE_IMAGES_FIELDS_MODERATION_STATUS, "==", types.MODERATION_STATUS_QUARANTINED)
+
+		iter := query.Documents(c)
+		defer iter.Stop()
+
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+
+			if err != nil {
+				tools.LogError(logger, c, err)
+				return
+			}
+
+			images = append(images, gin.H{
+				types.FIREBASE_IMAGES_FIELDS_ID:          doc.Ref.ID,
+				types.FIREBASE_IMAGES_FIELDS_URL:         doc.Data()[types.FIREBASE_IMAGES_FIELDS_URL],
+				types.FIREBASE_IMAGES_FIELDS_SAFE_SEARCH: doc.Data()[types.FIREBASE_IMAGES_FIELDS_SAFE_SEARCH],
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"images": images,
+		})
+	}
+}
+
+// Approves or rejects quarantined images, rejected images stay hidden and can not be used in posts. The scheduled posts
+// held back by approved images are published if they are due.
+func ReviewImagesHandler(logger *logging.Logger, firestoreClient *firestore.Client, tasksClient *cloudtasks.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		form, err := c.MultipartForm()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		imagesIds := form.Value["imagesIds"]
+		decision := c.PostForm("decision")
+
+		if decision != types.MODERATION_STATUS_APPROVED && decision != types.MODERATION_STATUS_REJECTED {
+			tools.LogError(logger, c, errors.New("decision must be either approved or rejected"))
+			return
+		}
+
+		// Get the reviewer from the verified token
+		reviewedBy := ""
+		if token := tools.GetAuthToken(c); token != nil {
+			reviewedBy = token.UID
+		}
+
+		// Each image is only reviewed if it did not change since the admin read it
+		expectedVersions, err := tools.ParseIfMatch(c)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		var reviewedIds []string
+		var failedIds []string
+
+		for _, imageId := range imagesIds {
+			err := tools.UpdateFirestoreDocumentIfMatch(c, firestoreClient, types.FIREBASE_IMAGES_COLLECTION, imageId, expectedVersions, map[string]interface{}{
+				types.FIREBASE_IMAGES_FIELDS_MODERATION_STATUS:      decision,
+				types.FIREBASE_IMAGES_FIELDS_MODERATION_REVIEWED_BY: reviewedBy,
+				types.FIREBASE_IMAGES_FIELDS_MODERATION_REVIEWED_AT: firestore.ServerTimestamp,
+			})
+			if err != nil {
+				logger.Log(logging.Entry{
+					Severity: logging.Error,
+					Payload:  "Error reviewing image " + imageId,
+					Labels:   map[string]string{"error": err.Error()},
+				})
+				failedIds = append(failedIds, imageId)
+				continue
+			}
+
+			reviewedIds = append(reviewedIds, imageId)
+		}
+
+		if decision == types.MODERATION_STATUS_APPROVED && len(reviewedIds) > 0 {
+			rescheduleImagesPostsPublishing(c, logger, firestoreClient, tasksClient, reviewedIds)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"reviewedIds": reviewedIds,
+			"failedIds":   failedIds,
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"proteggo_api/search"
+	"proteggo_api/tools"
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// Lists the revisions of a post, the latest first
+func GetPostRevisionsHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+
+		err := checkPostEditor(c, db, id)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		docs, err := db.Collection(types.FIREBASE_POST_REVISIONS_COLLECTION).
+			Where(types.FIREBASE_POST_REVISIONS_FIELDS_POST_ID, "==", id).
+			OrderBy(types.FIREBASE_POST_REVISIONS_FIELDS_REVISION, firestore.Desc).
+			Documents(c).GetAll()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		revisions := []types.PostRevision{}
+		for _, doc := range docs {
+			revisions = append(revisions, convertDocumentToPostRevision(doc.Data()))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"revisions": revisions,
+		})
+	}
+}
+
+// Compares two revisions of a post given by the from and to query parameters
+func GetPostRevisionsDiffHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+
+		err := checkPostEditor(c, db, id)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		from, err := getPostRevision(c, db, id, c.Query("from"))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		to, err := getPostRevision(c, db, id, c.Query("to"))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"diff": diffPostRevisions(from, to),
+		})
+	}
+}
+
+// Restores the body, hash tags, images and obscured overlays of a revision, the rollback is recorded as a new revision.
+// The images are shown through the versions of the obscured overlays the revision recorded, revisions recorded before
+// the versions were kept use the current obscured overlays of the images.
+func RollbackPostHandler(logger *logging.Logger, db *firestore.Client, postsIndex *search.PostsIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+
+		revision, err := getPostRevision(c, db, id, c.Param(types.FIREBASE_POST_REVISIONS_FIELDS_REVISION))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		update := postUpdate{
+			body:                     &revision.Body,
+			hashTagsIds:              revision.HashTagsIds,
+			hashTagsValues:           revision.HashTagsValues,
+			hashTagsProvided:         true,
+			imagesIds:                revision.ImagesIds,
+			imagesProvided:           true,
+			obscuredOverlaysIds:      revision.ObscuredOverlaysIds,
+			obscuredOverlaysProvided: true,
+			obscuredOverlays:         revision.ObscuredOverlays,
+		}
+
+		expectedVersions, err := tools.ParseIfMatch(c)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		var version int64
+		err = db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+			version, err = updatePost(tx, db, id, update, expectedVersions, getPostEditor(c))
+			return err
+		})
+
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.Header("ETag", tools.FormatETag(id, version))
+		syncPostsIndex(c, logger, db, postsIndex, id)
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "ok",
+		})
+	}
+}
+
+// Records the content of a post as a revision within the transaction, failing if the revision already exists
+func createPostRevision(tx *firestore.Transaction, db *firestore.Client, post types.Post, createdBy string) error {
+	return tx.Create(db.Collection(types.FIREBASE_POST_REVISIONS_COLLECTION).Doc(tools.GetPostRevisionId(post.Id, post.Revision)), map[string]interface{}{
+		types.FIREBASE_POST_REVISIONS_FIELDS_POST_ID:               post.Id,
+		types.FIREBASE_POST_REVISIONS_FIELDS_REVISION:              post.Revision,
+		types.FIREBASE_POST_REVISIONS_FIELDS_BODY:                  post.Body,
+		types.FIREBASE_POST_REVISIONS_FIELDS_STATUS:                post.Status,
+		types.FIREBASE_POST_REVISIONS_FIELDS_VISIBILITY:            post.Visibility,
+		types.FIREBASE_POST_REVISIONS_FIELDS_HASH_TAGS_IDS:         post.HashTagsIds,
+		types.FIREBASE_POST_REVISIONS_FIELDS_HASH_TAGS_VALUES:      post.HashTagsValues,
+		types.FIREBASE_POST_REVISIONS_FIELDS_IMAGES_IDS:            post.ImagesIds,
+		types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAYS_IDS: post.ObscuredOverlaysIds,
+		types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAYS:     buildPostRevisionObscuredOverlays(post),
+		types.FIREBASE_POST_REVISIONS_FIELDS_CREATED_AT:            firestore.ServerTimestamp,
+		types.FIREBASE_POST_REVISIONS_FIELDS_CREATED_BY:            createdBy,
+	})
+}
+
+// Records the version of the obscured overlay of each image the post shows obscured, the versioned objects are kept
+// until the image is deleted so a rollback can show them again
+func buildPostRevisionObscuredOverlays(post types.Post) map[string]interface{} {
+	obscuredOverlays := map[string]interface{}{}
+	for _, imageId := range post.ObscuredOverlaysIds {
+		media := getPostImageMedia(post, imageId)
+		obscuredOverlays[imageId] = map[string]interface{}{
+			types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_URL:                    media.obscuredOverlayUrl,
+			types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_STORAGE_PATH:           media.obscuredOverlayStoragePath,
+			types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_IMAGE_URL:              media.obscuredUrl,
+			types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_IMAGE_STORAGE_PATH:     media.obscuredStoragePath,
+			types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_ANIMATION_URL:          media.obscuredAnimationUrl,
+			types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_ANIMATION_STORAGE_PATH: media.obscuredAnimationStoragePath,
+			types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_FACES_IDS:              removeEmptyStrings(media.obscuredFacesIds),
+			types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_REGIONS_IDS:            removeEmptyStrings(media.obscuredRegionsIds),
+		}
+	}
+
+	return obscuredOverlays
+}
+
+// Reads the obscured overlays recorded by a revision
+func convertInterfaceToPostRevisionObscuredOverlays(value interface{}) map[string]types.PostRevisionObscuredOverlay {
+	obscuredOverlays := map[string]types.PostRevisionObscuredOverlay{}
+
+	valueMap, ok := value.(map[string]interface{})
+	if !ok {
+		// Revisions recorded before the versions were kept have no obscured overlays
+		return obscuredOverlays
+	}
+
+	for imageId, data := range valueMap {
+		dataMap, ok := data.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		obscuredOverlay := types.PostRevisionObscuredOverlay{
+			FacesIds:   convertInterfaceToArrayString(dataMap[types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_FACES_IDS]),
+			RegionsIds: convertInterfaceToArrayString(dataMap[types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_REGIONS_IDS]),
+		}
+		obscuredOverlay.Url, _ = dataMap[types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_URL].(string)
+		obscuredOverlay.StoragePath, _ = dataMap[types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_STORAGE_PATH].(string)
+		obscuredOverlay.ImageUrl, _ = dataMap[types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_IMAGE_URL].(string)
+		obscuredOverlay.ImageStoragePath, _ = dataMap[types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_IMAGE_STORAGE_PATH].(string)
+		obscuredOverlay.AnimationUrl, _ = dataMap[types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_ANIMATION_URL].(string)
+		obscuredOverlay.AnimationStoragePath, _ = dataMap[types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_ANIMATION_STORAGE_PATH].(string)
+
+		obscuredOverlays[imageId] = obscuredOverlay
+	}
+
+	return obscuredOverlays
+}
+
+// Checks the user can change the post, its revisions are only shown to those who can roll them back
+func checkPostEditor(c *gin.Context, db *firestore.Client, id string) error {
+	data, err := tools.GetFirestoreDocument(c, db, types.FIREBASE_POSTS_COLLECTION, id)
+	if err != nil {
+		return err
+	}
+
+	if data == nil {
+		return errors.New("post " + id + " does not exist")
+	}
+
+	if !getPostEditor(c).canEdit(convertDocumentToPost(data)) {
+		return fmt.Errorf("%w: %v", tools.ErrForbidden, id)
+	}
+
+	return nil
+}
+
+// Gets a revision of a post from its number
+func getPostRevision(c context.Context, db *firestore.Client, postId string, revisionValue string) (types.PostRevision, error) {
+	revision, err := strconv.ParseInt(revisionValue, 10, 64)
+	if err != nil {
+		return types.PostRevision{}, errors.New("revision must be a number")
+	}
+
+	data, err := tools.GetFirestoreDocument(c, db, types.FIREBASE_POST_REVISIONS_COLLECTION, tools.GetPostRevisionId(postId, revision))
+	if err != nil {
+		return types.PostRevision{}, err
+	}
+
+	if data == nil {
+		return types.PostRevision{}, fmt.Errorf("revision %d of post %s does not exist", revision, postId)
+	}
+
+	return convertDocumentToPostRevision(data), nil
+}
+
+func convertDocumentToPostRevision(data map[string]interface{}) types.PostRevision {
+	revision := types.PostRevision{
+		HashTagsIds:         convertInterfaceToArrayString(data[types.FIREBASE_POST_REVISIONS_FIELDS_HASH_TAGS_IDS]),
+		HashTagsValues:      convertInterfaceToArrayString(data[types.FIREBASE_POST_REVISIONS_FIELDS_HASH_TAGS_VALUES]),
+		ImagesIds:           convertInterfaceToArrayString(data[types.FIREBASE_POST_REVISIONS_FIELDS_IMAGES_IDS]),
+		ObscuredOverlaysIds: convertInterfaceToArrayString(data[types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAYS_IDS]),
+		ObscuredOverlays:    convertInterfaceToPostRevisionObscuredOverlays(data[types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAYS]),
+	}
+
+	revision.PostId, _ = data[types.FIREBASE_POST_REVISIONS_FIELDS_POST_ID].(string)
+	revision.Revision, _ = data[types.FIREBASE_POST_REVISIONS_FIELDS_REVISION].(int64)
+	revision.Body, _ = data[types.FIREBASE_POST_REVISIONS_FIELDS_BODY].(string)
+	revision.Status, _ = data[types.FIREBASE_POST_REVISIONS_FIELDS_STATUS].(string)
+	revision.Visibility, _ = data[types.FIREBASE_POST_REVISIONS_FIELDS_VISIBILITY].(string)
+	revision.CreatedBy, _ = data[types.FIREBASE_POST_REVISIONS_FIELDS_CREATED_BY].(string)
+	if createdAt, ok := data[types.FIREBASE_POST_REVISIONS_FIELDS_CREATED_AT].(time.Time); ok {
+		revision.CreatedAt = createdAt.Format(time.RFC3339)
+	}
+
+	return revision
+}
+
+func diffPostRevisions(from types.PostRevision, to types.PostRevision) types.PostRevisionDiff {
+	diff := types.PostRevisionDiff{
+		PostId: from.PostId,
+		From:   from.Revision,
+		To:     to.Revision,
+	}
+
+	if from.Body != to.Body {
+		diff.FromBody = from.Body
+		diff.ToBody = to.Body
+	}
+
+	if from.Status != to.Status {
+		diff.FromStatus = from.Status
+		diff.ToStatus = to.Status
+	}
+
+	if from.Visibility != to.Visibility {
+		diff.FromVisibility = from.Visibility
+		diff.ToVisibility = to.Visibility
+	}
+
+	diff.AddedHashTagsValues, diff.RemovedHashTagsValues = diffStrings(from.HashTagsValues, to.HashTagsValues)
+	diff.AddedImagesIds, diff.RemovedImagesIds = diffStrings(from.ImagesIds, to.ImagesIds)
+	diff.AddedObscuredOverlaysIds, diff.RemovedObscuredOverlaysIds = diffStrings(from.ObscuredOverlaysIds, to.ObscuredOverlaysIds)
+
+	// Images obscured in both revisions can show another version of their obscured overlay
+	diff.ChangedObscuredOverlaysIds = []string{}
+	for _, imageId := range to.ObscuredOverlaysIds {
+		fromOverlay, fromOk := from.ObscuredOverlays[imageId]
+		toOverlay, toOk := to.ObscuredOverlays[imageId]
+		if fromOk && toOk && fromOverlay.StoragePath != toOverlay.StoragePath {
+			diff.ChangedObscuredOverlaysIds = append(diff.ChangedObscuredOverlaysIds, imageId)
+		}
+	}
+
+	// Images kept in both revisions can still have been moved
+	kept := []string{}
+	for _, imageId := range to.ImagesIds {
+		if indexOfString(from.ImagesIds, imageId) >= 0 {
+			kept = append(kept, imageId)
+		}
+	}
+	keptBefore := []string{}
+	for _, imageId := range from.ImagesIds {
+		if indexOfString(to.ImagesIds, imageId) >= 0 {
+			keptBefore = append(keptBefore, imageId)
+		}
+	}
+	diff.ImagesReordered = !slices.Equal(kept, keptBefore)
+
+	return diff
+}
@@ -1,23 +1,28 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
+	"fmt"
+	"mime"
 	"net/http"
+	"path"
+	"proteggo_api/search"
+	"proteggo_api/tasks"
 	"proteggo_api/tools"
 	"proteggo_api/types"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/logging"
-	"cloud.google.com/go/storage"
 	"github.com/gin-gonic/gin"
-	"google.golang.org/api/iterator"
 )
 
-func SubmitPostHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+func SubmitPostHandler(logger *logging.Logger, db *firestore.Client, tasksClient *cloudtasks.Client, postsIndex *search.PostsIndex) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the post from the multipart form
 		form, err := c.MultipartForm()
@@ -27,98 +32,181 @@ func SubmitPostHandler(logger *logging.Logger, db *firestore.Client) gin.Handler
 		}
 
 		id := form.Value[types.FIREBASE_POSTS_FIELDS_ID]
-		hashTagsValues := form.Value[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_VALUES]
-		hashTagsIds := form.Value[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_IDS]
+		hashTagsValues := removeEmptyStrings(form.Value[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_VALUES])
+		hashTagsIds := removeEmptyStrings(form.Value[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_IDS])
 		body := form.Value[types.FIREBASE_POSTS_FIELDS_BODY]
 		imagesIds := form.Value[types.FIREBASE_POSTS_FIELDS_IMAGES_IDS]
-		imagesUrls := form.Value[types.FIREBASE_POSTS_FIELDS_IMAGES_URLS]
-		imagesStoragePaths := form.Value[types.FIREBASE_POSTS_FIELDS_IMAGES_STORAGE_PATHS]
-		// Unmarshal the facesIds from the form
-		facesIdsJson := form.Value[types.FIREBASE_POSTS_FIELDS_FACES_IDS][0]
-		var facesIds map[string][]string
-		err = json.Unmarshal([]byte(facesIdsJson), &facesIds)
-		if err != nil {
-			tools.LogError(logger, c, err)
+
+		if len(id) == 0 || id[0] == "" || len(body) == 0 {
+			tools.LogError(logger, c, errors.New("post id and body are required"))
 			return
 		}
-		// Unmarshal the facesUrls from the form
-		facesUrlsJson := form.Value[types.FIREBASE_POSTS_FIELDS_FACES_URLS][0]
-		var facesUrls map[string][]string
-		err = json.Unmarshal([]byte(facesUrlsJson), &facesUrls)
-		if err != nil {
-			tools.LogError(logger, c, err)
+
+		if len(hashTagsIds) != len(hashTagsValues) {
+			tools.LogError(logger, c, errors.New("hashTagsIds and hashTagsValues must have the same length"))
 			return
 		}
-		// Unmarshal the facesStoragePaths from the form
-		facesStoragePathsJson := form.Value[types.FIREBASE_POSTS_FIELDS_FACES_STORAGE_PATHS][0]
-		var facesStoragePaths map[string][]string
-		err = json.Unmarshal([]byte(facesStoragePathsJson), &facesStoragePaths)
+
+		// The obscuring choice is the list of images shown through their obscured overlay, all images having one when omitted
+		obscuredOverlaysIds, obscuredOverlaysProvided := form.Value[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_IDS]
+		obscuredOverlaysIds = removeEmptyStrings(obscuredOverlaysIds)
+
+		for _, imageId := range obscuredOverlaysIds {
+			if indexOfString(imagesIds, imageId) < 0 {
+				tools.LogError(logger, c, errors.New("obscured overlay "+imageId+" is not an image of the post"))
+				return
+			}
+		}
+
+		// Posts are published right away unless they are saved as drafts or scheduled
+		status, publishAt, err := parsePostStatus(c.DefaultPostForm(types.FIREBASE_POSTS_FIELDS_STATUS, types.POST_STATUS_PUBLISHED), c.PostForm(types.FIREBASE_POSTS_FIELDS_PUBLISH_AT))
 		if err != nil {
 			tools.LogError(logger, c, err)
 			return
 		}
-		overlaysIds := form.Value[types.FIREBASE_POSTS_FIELDS_OVERLAYS_IDS]
-		overlaysUrls := form.Value[types.FIREBASE_POSTS_FIELDS_OVERLAYS_URLS]
-		overlaysStoragePaths := form.Value[types.FIREBASE_POSTS_FIELDS_OVERLAYS_STORAGE_PATHS]
-		obscuredOverlaysIds := form.Value[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_IDS]
-		obscuredOverlaysUrls := form.Value[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_URLS]
-		obscuredOverlaysStoragePaths := form.Value[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_STORAGE_PATHS]
-
-		// Add the post to the Firestore database
-		err = tools.SetFirestoreDocument(c, db, types.FIREBASE_POSTS_COLLECTION, id[0], map[string]interface{}{
-			types.FIREBASE_POSTS_FIELDS_ID:                              id[0],
-			types.FIREBASE_POSTS_FIELDS_HASH_TAGS_VALUES:                hashTagsValues,
-			types.FIREBASE_POSTS_FIELDS_HASH_TAGS_IDS:                   hashTagsIds,
-			types.FIREBASE_POSTS_FIELDS_BODY:                            body[0],
-			types.FIREBASE_POSTS_FIELDS_IMAGES_IDS:                      imagesIds,
-			types.FIREBASE_POSTS_FIELDS_IMAGES_URLS:                     imagesUrls,
-			types.FIREBASE_POSTS_FIELDS_IMAGES_STORAGE_PATHS:            imagesStoragePaths,
-			types.FIREBASE_POSTS_FIELDS_CREATED_AT:                      firestore.ServerTimestamp,
-			types.FIREBASE_POSTS_FIELDS_FACES_IDS:                       facesIds,
-			types.FIREBASE_POSTS_FIELDS_FACES_URLS:                      facesUrls,
-			types.FIREBASE_POSTS_FIELDS_FACES_STORAGE_PATHS:             facesStoragePaths,
-			types.FIREBASE_POSTS_FIELDS_OVERLAYS_IDS:                    overlaysIds,
-			types.FIREBASE_POSTS_FIELDS_OVERLAYS_URLS:                   overlaysUrls,
-			types.FIREBASE_POSTS_FIELDS_OVERLAYS_STORAGE_PATHS:          overlaysStoragePaths,
-			types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_IDS:           obscuredOverlaysIds,
-			types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_URLS:          obscuredOverlaysUrls,
-			types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_STORAGE_PATHS: obscuredOverlaysStoragePaths,
-			// TODO: are overlays here missing?
-		})
 
+		// Posts are visible to the users of the app unless another visibility is chosen
+		visibility, err := parsePostVisibility(c.DefaultPostForm(types.FIREBASE_POSTS_FIELDS_VISIBILITY, types.POST_VISIBILITY_AUTHENTICATED))
 		if err != nil {
 			tools.LogError(logger, c, err)
 			return
 		}
 
-		// If an image is used in the post, update the image document in Firestore
-		for _, imageId := range imagesIds {
+		editor := getPostEditor(c)
+
+		postData := map[string]interface{}{
+			types.FIREBASE_POSTS_FIELDS_ID:               id[0],
+			types.FIREBASE_POSTS_FIELDS_HASH_TAGS_VALUES: hashTagsValues,
+			types.FIREBASE_POSTS_FIELDS_HASH_TAGS_IDS:    hashTagsIds,
+			types.FIREBASE_POSTS_FIELDS_BODY:             body[0],
+			types.FIREBASE_POSTS_FIELDS_CREATED_AT:       firestore.ServerTimestamp,
+			types.FIREBASE_POSTS_FIELDS_PUBLISHED_AT:     firestore.ServerTimestamp,
+			types.FIREBASE_POSTS_FIELDS_STATUS:           status,
+			types.FIREBASE_POSTS_FIELDS_PUBLISH_AT:       publishAt,
+			types.FIREBASE_POSTS_FIELDS_VISIBILITY:       visibility,
+			types.FIREBASE_POSTS_FIELDS_AUTHOR_UID:       editor.uid,
+			types.FIREBASE_POSTS_FIELDS_DELETED_AT:       nil,
+			types.FIREBASE_POSTS_FIELDS_UPDATED_AT:       firestore.ServerTimestamp,
+			types.FIREBASE_POSTS_FIELDS_REVISION:         1,
+			types.FIREBASE_POSTS_FIELDS_VERSION:          1,
+			types.FIREBASE_POSTS_FIELDS_COMMENTS_COUNT:   0,
+			types.FIREBASE_POSTS_FIELDS_PINNED:           false,
+		}
+
+		// Write the post together with the post id of its images, faces and text regions, so either all or none are saved
+		err = db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+			// The id is chosen by the client, an existing post is never overwritten
+			existing, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_POSTS_COLLECTION, id[0])
 			if err != nil {
-				tools.LogError(logger, c, err)
-				return
+				return err
 			}
 
-			err = tools.UpdateFirestoreDocument(c, db, types.FIREBASE_IMAGES_COLLECTION, imageId, map[string]interface{}{
-				types.FIREBASE_IMAGES_FIELDS_POST_ID: id[0],
-				// TODO: here should also save overlays and obscured overlays, because otherwise those are not being saved to the images table
-			})
+			if existing != nil {
+				return fmt.Errorf("%w: %v", tools.ErrConflict, id[0])
+			}
+
+			// Read the hash tags, all reads have to be done before the writes
+			hashTags := map[string]map[string]interface{}{}
+			for _, hashTagId := range hashTagsIds {
+				hashTags[hashTagId], err = tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_POSTS_HASHTAGS_COLLECTION, hashTagId)
+				if err != nil {
+					return err
+				}
+			}
 
-			// If an image has faces, update the face documents in Firestore
-			for _, faceIds := range facesIds[imageId] {
+			// The urls and storage paths of the images, faces and overlays are read from the images, never from the client
+			media := map[string]postImageMedia{}
+			images := map[string]map[string]interface{}{}
+			for _, imageId := range imagesIds {
+				image, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_IMAGES_COLLECTION, imageId)
 				if err != nil {
-					tools.LogError(logger, c, err)
-					return
+					return err
+				}
+
+				if image == nil {
+					return errors.New("image " + imageId + " does not exist")
+				}
+
+				// Images that did not pass moderation can not be used in posts
+				if !tools.IsImageModerationApproved(image) {
+					return errors.New("image " + imageId + " did not pass moderation")
+				}
+
+				if tools.IsDocumentInTrash(image) {
+					return errors.New("image " + imageId + " is in the trash")
+				}
+
+				imageMedia := convertImageDocumentToPostMedia(image)
+				if obscuredOverlaysProvided && indexOfString(obscuredOverlaysIds, imageId) < 0 {
+					imageMedia.obscuredOverlayUrl = ""
+					imageMedia.obscuredOverlayStoragePath = ""
+					imageMedia.obscuredUrl = ""
+					imageMedia.obscuredStoragePath = ""
+					imageMedia.obscuredAnimationUrl = ""
+					imageMedia.obscuredAnimationStoragePath = ""
+					imageMedia.obscuredFacesIds = nil
+					imageMedia.obscuredRegionsIds = nil
+				} else if obscuredOverlaysProvided && imageMedia.obscuredOverlayUrl == "" {
+					return errors.New("image " + imageId + " has no obscured overlay")
 				}
 
-				err = tools.UpdateFirestoreDocument(c, db, types.FIREBASE_FACES_COLLECTION, faceIds, map[string]interface{}{
-					types.FIREBASE_FACES_FIELDS_POST_ID: id[0],
-				})
+				media[imageId] = imageMedia
+				images[imageId] = image
+			}
+
+			mediaFields := buildPostMediaFields(imagesIds, media)
+			for field, value := range mediaFields {
+				postData[field] = value
+			}
+
+			// Add the post to the Firestore database, creating fails if a post with the id was written since it was read
+			err = tx.Create(db.Collection(types.FIREBASE_POSTS_COLLECTION).Doc(id[0]), postData)
+			if err != nil {
+				return err
+			}
+
+			// The created post is the first revision
+			err = createPostRevision(tx, db, setPostMediaFields(types.Post{
+				Id:             id[0],
+				Body:           body[0],
+				Status:         status,
+				Visibility:     visibility,
+				HashTagsIds:    hashTagsIds,
+				HashTagsValues: hashTagsValues,
+				Revision:       1,
+			}, mediaFields), editor.uid)
+			if err != nil {
+				return err
+			}
+
+			// Add the post to the posts using its images, faces and regions, images can be used by several posts
+			for _, imageId := range imagesIds {
+				err = setImagePostsIds(tx, db, imageId, media[imageId], changeImagePostsIds(images[imageId], id[0], true))
+				if err != nil {
+					return err
+				}
+			}
 
+			// The post counts in the score of its hash tags, the hash tags used for the first time are created
+			for i, hashTagId := range hashTagsIds {
+				err = changeHashTagScore(tx, db, hashTagId, hashTagsValues[i], hashTags[hashTagId], 1)
 				if err != nil {
-					tools.LogError(logger, c, err)
-					return
+					return err
 				}
 			}
+
+			return nil
+		})
+
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		syncPostsIndex(c, logger, db, postsIndex, id[0])
+
+		if status == types.POST_STATUS_SCHEDULED {
+			schedulePostPublishing(c, logger, tasksClient, *publishAt)
 		}
 
 		c.JSON(http.StatusOK, gin.H{
@@ -129,164 +217,327 @@ func SubmitPostHandler(logger *logging.Logger, db *firestore.Client) gin.Handler
 
 func GetPostsHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var posts []types.Post
+		// Get the cursor and page size from the URL
+		cursor := c.Query("cursor")
+		pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		// Start a query for the posts collection, limited to the posts the user can read
+		query, err := filterReadablePosts(c, db.Collection(types.FIREBASE_POSTS_COLLECTION).Query)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		query, err = filterPostsByDates(c, query)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		pinnedPosts, err := getPinnedPosts(c, query, nil)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		posts, nextCursor, prevCursor, err := getPostsPage(c, query, cursor, pageSize, excludePinnedPosts(pinnedPosts, nil))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
 
-		// Get the page number and page size from the URL
-		pageNumber, err := strconv.Atoi(c.DefaultQuery("pageNumber", "1"))
+		// Pinned posts are listed above the newest page only, also when it is reached by paging back
+		if !isNewestPostsPage(cursor, prevCursor) {
+			pinnedPosts = []types.Post{}
+		}
+
+		listedPosts := append(pinnedPosts, posts...)
+		err = addPostsReactions(c, db, listedPosts)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"pinnedPosts": listedPosts[:len(pinnedPosts)],
+			"posts":       listedPosts[len(pinnedPosts):],
+			"nextCursor":  nextCursor,
+			"prevCursor":  prevCursor,
+		})
+	}
+}
+
+// Gets the posts matching a combination of hash tags, newest first after the pinned ones. Posts need every hash tag of all, at least one
+// of any and none of none, the hash tags of the path are added to any. Firestore filters on a single hash tag
+// condition, the others are checked on the server.
+func GetPostsByHashTagsHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get the cursor and page size from the URL
+		cursor := c.Query("cursor")
 		pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
-		startDateStr, startDateProvided := c.GetQuery("startDate")
-		endDateStr, endDateProvided := c.GetQuery("endDate")
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		// Get the hash tags of each condition, split on commas
+		all := splitHashTags(c.Query("all"))
+		any := append(splitHashTags(c.Param("hashTags")), splitHashTags(c.Query("any"))...)
+		none := splitHashTags(c.Query("none"))
+
+		if len(all) == 0 && len(any) == 0 && len(none) == 0 {
+			tools.LogError(logger, c, errors.New("at least one of all, any or none is required"))
+			return
+		}
 
+		// Start a query for the posts collection, limited to the posts the user can read
+		query, err := filterReadablePosts(c, db.Collection(types.FIREBASE_POSTS_COLLECTION).Query)
 		if err != nil {
 			tools.LogError(logger, c, err)
 			return
 		}
 
-		// Calculate the number of documents to skip
-		skip := (pageNumber - 1) * pageSize
+		query, err = filterPostsByDates(c, query)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
 
-		// Start a query for the posts collection
-		query := db.Collection(types.FIREBASE_POSTS_COLLECTION).OrderBy(types.FIREBASE_POSTS_FIELDS_CREATED_AT, firestore.Desc)
+		pinnedQuery := query
 
-		// If a start date is provided, add a filter for it
-		if startDateProvided {
-			startDate, err := time.Parse(time.RFC3339, startDateStr)
+		// Firestore allows a single array condition per query, a required hash tag narrows the posts the most
+		if len(all) > 0 {
+			query = query.Where(types.FIREBASE_POSTS_FIELDS_HASH_TAGS_VALUES, "array-contains", all[0])
+		} else if len(any) > 0 && len(any) <= types.FIRESTORE_ARRAY_CONTAINS_ANY_MAX_VALUES {
+			query = query.Where(types.FIREBASE_POSTS_FIELDS_HASH_TAGS_VALUES, "array-contains-any", any)
+		}
 
-			if err != nil {
-				tools.LogError(logger, c, err)
-				return
+		// A single any condition pushed to Firestore needs no check on the server
+		var match func(post types.Post) bool
+		if len(all) > 1 || len(none) > 0 || (len(all) > 0 && len(any) > 0) || len(any) > types.FIRESTORE_ARRAY_CONTAINS_ANY_MAX_VALUES {
+			match = func(post types.Post) bool {
+				return matchPostHashTags(post, all, any, none)
 			}
+		}
 
-			query = query.Where(types.FIREBASE_POSTS_FIELDS_CREATED_AT, ">=", startDate)
+		// Pins are few, their hash tags are all checked on the server
+		pinnedPosts, err := getPinnedPosts(c, pinnedQuery, func(post types.Post) bool {
+			return matchPostHashTags(post, all, any, none)
+		})
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
 		}
 
-		// If an end date is provided, add a filter for it
-		if endDateProvided {
-			endDate, err := time.Parse(time.RFC3339, endDateStr)
+		posts, nextCursor, prevCursor, err := getPostsPage(c, query, cursor, pageSize, excludePinnedPosts(pinnedPosts, match))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
 
-			if err != nil {
-				tools.LogError(logger, c, err)
-				return
-			}
+		// Pinned posts are listed above the newest page only, also when it is reached by paging back
+		if !isNewestPostsPage(cursor, prevCursor) {
+			pinnedPosts = []types.Post{}
+		}
 
-			query = query.Where(types.FIREBASE_POSTS_FIELDS_CREATED_AT, "<=", endDate)
+		listedPosts := append(pinnedPosts, posts...)
+		err = addPostsReactions(c, db, listedPosts)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
 		}
 
-		// If this is not the first page, start after the last document of the previous page
-		if pageNumber > 1 {
-			docs, err := query.Documents(c).GetAll()
+		c.JSON(http.StatusOK, gin.H{
+			"pinnedPosts": listedPosts[:len(pinnedPosts)],
+			"posts":       listedPosts[len(pinnedPosts):],
+			"nextCursor":  nextCursor,
+			"prevCursor":  prevCursor,
+		})
+	}
+}
+
+// Gets a single post with its version as the ETag, unlisted posts can only be read this way
+func GetPostHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
 
-			if err != nil {
-				tools.LogError(logger, c, err)
-				return
-			}
+		data, err := tools.GetFirestoreDocument(c, db, types.FIREBASE_POSTS_COLLECTION, id)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		if data == nil || !canReadPost(c, convertDocumentToPost(data)) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "post " + id + " does not exist",
+			})
+			return
+		}
 
-			lastDocSnapshot := docs[skip-1]
-			query = query.StartAfter(lastDocSnapshot)
+		post := convertDocumentToPost(data)
+		reactions, err := getPostReactions(c, db, post.Id)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
 		}
 
-		// Limit the query to the page size
-		query = query.Limit(pageSize)
+		// A single post gets the sum of its shards, the counts rolled up on it can lag behind
+		post.ReactionsCounts = reactions.Counts
+		post.Reactions = reactions.Reactions
+
+		tools.SetETagHeader(c, id, data)
+		c.JSON(http.StatusOK, gin.H{
+			"post": post,
+		})
+	}
+}
 
-		// Get all posts that match the query
-		iter := query.Documents(c)
-		defer iter.Stop()
+// Gets the posts written by a user, newest first. Authors and admins get all the posts and can filter them by status,
+// other users only get the published posts listed to every user.
+func GetUserPostsHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.Param("uid")
 
-		for {
-			doc, err := iter.Next()
-			if err == iterator.Done {
-				break
+		// Get the cursor and page size from the URL
+		cursor := c.Query("cursor")
+		pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		query := db.Collection(types.FIREBASE_POSTS_COLLECTION).
+			Where(types.FIREBASE_POSTS_FIELDS_AUTHOR_UID, "==", uid).
+			Where(types.FIREBASE_POSTS_FIELDS_DELETED_AT, "==", nil)
+
+		editor := getPostEditor(c)
+		if editor.admin || editor.uid == uid {
+			status, statusProvided := c.GetQuery(types.FIREBASE_POSTS_FIELDS_STATUS)
+			if statusProvided && !isPostStatus(status) {
+				tools.LogError(logger, c, errors.New("status must be one of draft, scheduled, published or archived"))
+				return
 			}
 
+			if statusProvided {
+				query = query.Where(types.FIREBASE_POSTS_FIELDS_STATUS, "==", status)
+			}
+		} else {
+			query, err = filterReadablePosts(c, query)
 			if err != nil {
 				tools.LogError(logger, c, err)
 				return
 			}
+		}
 
-			posts = append(posts, types.Post{
-				Id:                           doc.Data()[types.FIREBASE_POSTS_FIELDS_ID].(string),
-				Body:                         doc.Data()[types.FIREBASE_POSTS_FIELDS_BODY].(string),
-				CreatedAt:                    doc.Data()[types.FIREBASE_POSTS_FIELDS_CREATED_AT].(time.Time).Format(time.RFC3339),
-				HashTagsValues:               convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_VALUES]),
-				HashTagsIds:                  convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_IDS]),
-				ImagesIds:                    convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_IMAGES_IDS]),
-				ImagesUrls:                   convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_IMAGES_URLS]),
-				ImagesStoragePaths:           convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_IMAGES_STORAGE_PATHS]),
-				FacesIds:                     convertInterfaceToMapStringArray(doc.Data()[types.FIREBASE_POSTS_FIELDS_FACES_IDS]),
-				FacesUrls:                    convertInterfaceToMapStringArray(doc.Data()[types.FIREBASE_POSTS_FIELDS_FACES_URLS]),
-				FacesStoragePaths:            convertInterfaceToMapStringArray(doc.Data()[types.FIREBASE_POSTS_FIELDS_FACES_STORAGE_PATHS]),
-				OverlaysIds:                  convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_OVERLAYS_IDS]),
-				OverlaysUrls:                 convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_OVERLAYS_URLS]),
-				OverlaysStoragePaths:         convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_OVERLAYS_STORAGE_PATHS]),
-				ObscuredOverlaysIds:          convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_IDS]),
-				ObscuredOverlaysUrls:         convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_URLS]),
-				ObscuredOverlaysStoragePaths: convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_STORAGE_PATHS]),
-			})
+		query, err = filterPostsByDates(c, query)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		posts, nextCursor, prevCursor, err := getPostsPage(c, query, cursor, pageSize, nil)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		err = addPostsReactions(c, db, posts)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
 		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"posts": posts,
+			"posts":      posts,
+			"nextCursor": nextCursor,
+			"prevCursor": prevCursor,
 		})
 	}
 }
 
-func GetPostsByHashTagsHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+// Edits the body, hash tags, images, obscured overlays, status and visibility of a post, fields which are not provided are left untouched
+func UpdatePostHandler(logger *logging.Logger, db *firestore.Client, tasksClient *cloudtasks.Client, postsIndex *search.PostsIndex) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var posts []types.Post
+		id := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+
+		form, err := c.MultipartForm()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
 
-		// Get the hashTags parameter from the URL and split it into individual hashTags
-		hashTags := strings.Split(c.Param("hashTags"), ",")
+		update := postUpdate{}
 
-		// Start a query for the posts collection
-		query := db.Collection(types.FIREBASE_POSTS_COLLECTION).Query
+		if body, ok := form.Value[types.FIREBASE_POSTS_FIELDS_BODY]; ok {
+			update.body = &body[0]
+		}
 
-		// Add a where clause for the hashTags
-		query = query.Where(types.FIREBASE_POSTS_FIELDS_HASH_TAGS_VALUES, "array-contains-any", hashTags)
+		// A single empty value clears the hash tags, images or obscured overlays, as multipart forms can not send empty lists
+		update.hashTagsIds, update.hashTagsProvided = form.Value[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_IDS]
+		update.hashTagsIds = removeEmptyStrings(update.hashTagsIds)
+		update.hashTagsValues = removeEmptyStrings(form.Value[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_VALUES])
 
-		// Get all posts with the hash tags
-		iter := query.Documents(c)
-		defer iter.Stop()
+		update.imagesIds, update.imagesProvided = form.Value[types.FIREBASE_POSTS_FIELDS_IMAGES_IDS]
+		update.imagesIds = removeEmptyStrings(update.imagesIds)
 
-		for {
-			doc, err := iter.Next()
-			if err == iterator.Done {
-				break
+		update.obscuredOverlaysIds, update.obscuredOverlaysProvided = form.Value[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_IDS]
+		update.obscuredOverlaysIds = removeEmptyStrings(update.obscuredOverlaysIds)
+
+		if visibility, ok := c.GetPostForm(types.FIREBASE_POSTS_FIELDS_VISIBILITY); ok {
+			update.visibility, err = parsePostVisibility(visibility)
+			if err != nil {
+				tools.LogError(logger, c, err)
+				return
 			}
+		}
 
+		statusValue, statusProvided := c.GetPostForm(types.FIREBASE_POSTS_FIELDS_STATUS)
+		if statusProvided {
+			update.status, update.publishAt, err = parsePostStatus(statusValue, c.PostForm(types.FIREBASE_POSTS_FIELDS_PUBLISH_AT))
 			if err != nil {
 				tools.LogError(logger, c, err)
 				return
 			}
+		}
 
-			posts = append(posts, types.Post{
-				Id:                           doc.Data()[types.FIREBASE_POSTS_FIELDS_ID].(string),
-				Body:                         doc.Data()[types.FIREBASE_POSTS_FIELDS_BODY].(string),
-				CreatedAt:                    doc.Data()[types.FIREBASE_POSTS_FIELDS_CREATED_AT].(time.Time).Format(time.RFC3339),
-				HashTagsValues:               convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_VALUES]),
-				HashTagsIds:                  convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_IDS]),
-				ImagesIds:                    convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_IMAGES_IDS]),
-				ImagesUrls:                   convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_IMAGES_URLS]),
-				ImagesStoragePaths:           convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_IMAGES_STORAGE_PATHS]),
-				FacesIds:                     convertInterfaceToMapStringArray(doc.Data()[types.FIREBASE_POSTS_FIELDS_FACES_IDS]),
-				FacesUrls:                    convertInterfaceToMapStringArray(doc.Data()[types.FIREBASE_POSTS_FIELDS_FACES_URLS]),
-				FacesStoragePaths:            convertInterfaceToMapStringArray(doc.Data()[types.FIREBASE_POSTS_FIELDS_FACES_STORAGE_PATHS]),
-				OverlaysIds:                  convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_OVERLAYS_IDS]),
-				OverlaysUrls:                 convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_OVERLAYS_URLS]),
-				OverlaysStoragePaths:         convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_OVERLAYS_STORAGE_PATHS]),
-				ObscuredOverlaysIds:          convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_IDS]),
-				ObscuredOverlaysUrls:         convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_URLS]),
-				ObscuredOverlaysStoragePaths: convertInterfaceToArrayString(doc.Data()[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_STORAGE_PATHS]),
-			})
+		// The post is only edited if it did not change since the client read it
+		expectedVersions, err := tools.ParseIfMatch(c)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		var version int64
+		err = db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+			version, err = updatePost(tx, db, id, update, expectedVersions, getPostEditor(c))
+			return err
+		})
+
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.Header("ETag", tools.FormatETag(id, version))
+		syncPostsIndex(c, logger, db, postsIndex, id)
+
+		if update.status == types.POST_STATUS_SCHEDULED {
+			schedulePostPublishing(c, logger, tasksClient, *update.publishAt)
 		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"posts": posts,
+			"status": "ok",
 		})
 	}
 }
 
-// TODO: Test delete with corelation to delete overlays and obscured overlays
-func DeletePostHandler(logger *logging.Logger, firestoreClient *firestore.Client, storage *storage.Client) gin.HandlerFunc {
+// Moves a post to the trash, it is hidden from the listings until it is restored or purged. Its comments, reactions,
+// revisions and share links are left untouched so a restore brings them back, they are deleted when the post is purged.
+func DeletePostHandler(logger *logging.Logger, firestoreClient *firestore.Client, postsIndex *search.PostsIndex) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the post id
 		id, idProvided := c.GetQuery(types.FIREBASE_POSTS_FIELDS_ID)
@@ -295,140 +546,914 @@ func DeletePostHandler(logger *logging.Logger, firestoreClient *firestore.Client
 			return
 		}
 
-		// Find the post in Firestore
-		post, err := tools.GetFirestoreDocument(c, firestoreClient, types.FIREBASE_POSTS_COLLECTION, id)
+		expectedVersions, err := tools.ParseIfMatch(c)
 		if err != nil {
 			tools.LogError(logger, c, err)
 			return
 		}
 
-		// Get the hashTagsIds, imagesIds, imagesStoragePaths, faces and obscuredOverlays storage path from the post
-		hashsTagsIds := convertInterfaceToArrayString(post[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_IDS])
-		imagesIds := convertInterfaceToArrayString(post[types.FIREBASE_POSTS_FIELDS_IMAGES_IDS])
-		imagesStoragePaths := convertInterfaceToArrayString(post[types.FIREBASE_POSTS_FIELDS_IMAGES_STORAGE_PATHS])
-		facesIds := convertInterfaceToMapStringArray(post[types.FIREBASE_POSTS_FIELDS_FACES_IDS])
-		facesStoragePaths := convertInterfaceToMapStringArray(post[types.FIREBASE_POSTS_FIELDS_FACES_STORAGE_PATHS])
-		overlaysStoragePaths := convertInterfaceToArrayString(post[types.FIREBASE_POSTS_FIELDS_OVERLAYS_STORAGE_PATHS])
-		obscuredOverlaysStoragePaths := convertInterfaceToArrayString(post[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_STORAGE_PATHS])
-
-		// Find the hash tags in Firestore and check its score
-		for _, hashTagId := range hashsTagsIds {
-			hashTag, err := tools.GetFirestoreDocument(c, firestoreClient, types.FIREBASE_POSTS_HASHTAGS_COLLECTION, hashTagId)
+		err = firestoreClient.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+			// Find the post in Firestore
+			post, err := tools.GetFirestoreDocumentInTransaction(tx, firestoreClient, types.FIREBASE_POSTS_COLLECTION, id)
 			if err != nil {
-				tools.LogError(logger, c, err)
-				return
+				return err
 			}
 
-			score := int(hashTag[types.FIREBASE_POSTS_HASHTAGS_FIELDS_SCORE].(int64))
+			if post == nil {
+				return errors.New("post " + id + " does not exist")
+			}
 
-			// If the score is 1, delete the hash tag from Firestore
-			if score <= 1 {
-				err := tools.DeleteFirestoreDocument(c, firestoreClient, types.FIREBASE_POSTS_HASHTAGS_COLLECTION, hashTagId)
-				if err != nil {
-					tools.LogError(logger, c, err)
-					return
-				}
-			} else {
-				// If the score is greater than 1, decrement the score by 1
-				err := tools.SetFirestoreDocument(c, firestoreClient, types.FIREBASE_POSTS_HASHTAGS_COLLECTION, hashTagId, map[string]interface{}{
-					types.FIREBASE_POSTS_HASHTAGS_FIELDS_ID:    hashTagId,
-					types.FIREBASE_POSTS_HASHTAGS_FIELDS_VALUE: hashTag[types.FIREBASE_POSTS_HASHTAGS_FIELDS_VALUE],
-					types.FIREBASE_POSTS_HASHTAGS_FIELDS_SCORE: score - 1,
-				})
-				if err != nil {
-					tools.LogError(logger, c, err)
-					return
-				}
+			if tools.IsDocumentInTrash(post) {
+				return errors.New("post " + id + " is already in the trash")
 			}
-		}
 
-		// Delete each image from the images collection
-		for _, imageId := range imagesIds {
-			err := tools.DeleteFirestoreDocument(c, firestoreClient, types.FIREBASE_IMAGES_COLLECTION, imageId)
-			if err != nil {
-				tools.LogError(logger, c, err)
-				return
+			if !getPostEditor(c).canEdit(convertDocumentToPost(post)) {
+				return fmt.Errorf("%w: %v", tools.ErrForbidden, id)
 			}
-		}
 
-		// Delete each image from storage
-		for _, path := range imagesStoragePaths {
-			err := tools.DeleteObjectFromStorage(c, path, storage)
+			err = tools.CheckDocumentVersion(expectedVersions, id, post)
 			if err != nil {
-				tools.LogError(logger, c, err)
-				return
+				return err
 			}
-		}
 
-		// Delete each face from the faces collection
-		for _, faceIds := range facesIds {
-			for _, faceId := range faceIds {
-				err := tools.DeleteFirestoreDocument(c, firestoreClient, types.FIREBASE_FACES_COLLECTION, faceId)
+			// Read the hash tags, all reads have to be done before the writes
+			hashsTagsIds := convertInterfaceToArrayString(post[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_IDS])
+			hashTags := map[string]map[string]interface{}{}
+			for _, hashTagId := range hashsTagsIds {
+				hashTags[hashTagId], err = tools.GetFirestoreDocumentInTransaction(tx, firestoreClient, types.FIREBASE_POSTS_HASHTAGS_COLLECTION, hashTagId)
 				if err != nil {
-					tools.LogError(logger, c, err)
-					return
+					return err
 				}
 			}
-		}
 
-		// Delete each face from storage
-		for _, paths := range facesStoragePaths {
-			for _, path := range paths {
-				err := tools.DeleteObjectFromStorage(c, path, storage)
+			// Posts in the trash do not count in the score of their hash tags
+			for _, hashTagId := range hashsTagsIds {
+				err = changeHashTagScore(tx, firestoreClient, hashTagId, "", hashTags[hashTagId], -1)
 				if err != nil {
-					tools.LogError(logger, c, err)
-					return
+					return err
 				}
 			}
-		}
-
-		// Delete each obscured overlay from storage
-		for _, path := range obscuredOverlaysStoragePaths {
-			err := tools.DeleteObjectFromStorage(c, path, storage)
-			if err != nil {
-				tools.LogError(logger, c, err)
-				return
-			}
-		}
 
-		// Delete each overlay from storage
-		for _, path := range overlaysStoragePaths {
-			err := tools.DeleteObjectFromStorage(c, path, storage)
-			if err != nil {
-				tools.LogError(logger, c, err)
-				return
-			}
-		}
+			return tx.Update(firestoreClient.Collection(types.FIREBASE_POSTS_COLLECTION).Doc(id), []firestore.Update{
+				{Path: types.FIREBASE_POSTS_FIELDS_DELETED_AT, Value: firestore.ServerTimestamp},
+				{Path: types.FIREBASE_POSTS_FIELDS_UPDATED_AT, Value: firestore.ServerTimestamp},
+				{Path: types.FIREBASE_POSTS_FIELDS_VERSION, Value: firestore.Increment(1)},
+			})
+		})
 
-		// Delete the post from Firestore
-		err = tools.DeleteFirestoreDocument(c, firestoreClient, types.FIREBASE_POSTS_COLLECTION, id)
 		if err != nil {
 			tools.LogError(logger, c, err)
 			return
 		}
 
+		syncPostsIndex(c, logger, firestoreClient, postsIndex, id)
+
 		c.JSON(http.StatusOK, gin.H{
 			"status": "ok",
 		})
 	}
 }
 
-func convertInterfaceToArrayString(data interface{}) []string {
-	if data == nil {
-		return []string{}
+// Changes to a post, the lists are only applied when they are provided and the status and visibility when they are not empty
+type postUpdate struct {
+	body                     *string
+	hashTagsIds              []string
+	hashTagsValues           []string
+	hashTagsProvided         bool
+	imagesIds                []string
+	imagesProvided           bool
+	obscuredOverlaysIds      []string
+	obscuredOverlaysProvided bool
+	obscuredOverlays         map[string]types.PostRevisionObscuredOverlay
+	status                   string
+	publishAt                *time.Time
+	visibility               string
+}
+
+// Applies the changes to a post within the transaction, adjusting the hash tag scores and the post id of the images
+// joining or leaving the post, and records the resulting post as a new revision
+func updatePost(tx *firestore.Transaction, db *firestore.Client, id string, update postUpdate, expectedVersions map[string]int64, editor postEditor) (int64, error) {
+	if update.hashTagsProvided && len(update.hashTagsIds) != len(update.hashTagsValues) {
+		return 0, errors.New("hashTagsIds and hashTagsValues must have the same length")
 	}
 
-	dataSlice, ok := data.([]interface{})
-	if !ok {
-		// data is not a slice of interfaces, return an empty slice
-		return []string{}
+	postData, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_POSTS_COLLECTION, id)
+	if err != nil {
+		return 0, err
 	}
 
-	result := make([]string, len(dataSlice))
-	for i, v := range dataSlice {
-		str, ok := v.(string)
-		if ok {
-			result[i] = str
+	if postData == nil {
+		return 0, errors.New("post " + id + " does not exist")
+	}
+
+	if tools.IsDocumentInTrash(postData) {
+		return 0, errors.New("post " + id + " is in the trash")
+	}
+
+	err = tools.CheckDocumentVersion(expectedVersions, id, postData)
+	if err != nil {
+		return 0, err
+	}
+
+	post := convertDocumentToPost(postData)
+	if !editor.canEdit(post) {
+		return 0, fmt.Errorf("%w: %v", tools.ErrForbidden, id)
+	}
+
+	updates := map[string]interface{}{}
+
+	// The revision records the post as it is after the update
+	revision := post
+
+	if update.body != nil {
+		updates[types.FIREBASE_POSTS_FIELDS_BODY] = *update.body
+		revision.Body = *update.body
+	}
+
+	if update.status != "" {
+		updates[types.FIREBASE_POSTS_FIELDS_STATUS] = update.status
+		updates[types.FIREBASE_POSTS_FIELDS_PUBLISH_AT] = update.publishAt
+		revision.Status = update.status
+
+		// The post enters the feed at the time it is published, its creation time is kept
+		if update.status == types.POST_STATUS_PUBLISHED && post.Status != types.POST_STATUS_PUBLISHED {
+			updates[types.FIREBASE_POSTS_FIELDS_PUBLISHED_AT] = firestore.ServerTimestamp
+		}
+	}
+
+	if update.visibility != "" {
+		updates[types.FIREBASE_POSTS_FIELDS_VISIBILITY] = update.visibility
+		revision.Visibility = update.visibility
+	}
+
+	var addedHashTagsIds, removedHashTagsIds []string
+	hashTags := map[string]map[string]interface{}{}
+	if update.hashTagsProvided {
+		addedHashTagsIds, removedHashTagsIds = diffStrings(post.HashTagsIds, update.hashTagsIds)
+
+		for _, hashTagId := range append(addedHashTagsIds, removedHashTagsIds...) {
+			hashTags[hashTagId], err = tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_POSTS_HASHTAGS_COLLECTION, hashTagId)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		updates[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_IDS] = update.hashTagsIds
+		updates[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_VALUES] = update.hashTagsValues
+		revision.HashTagsIds = update.hashTagsIds
+		revision.HashTagsValues = update.hashTagsValues
+	}
+
+	var addedImagesIds, removedImagesIds []string
+	newMedia := map[string]postImageMedia{}
+	images := map[string]map[string]interface{}{}
+	if update.imagesProvided || update.obscuredOverlaysProvided {
+		imagesIds := post.ImagesIds
+		if update.imagesProvided {
+			imagesIds = update.imagesIds
+			addedImagesIds, removedImagesIds = diffStrings(post.ImagesIds, imagesIds)
+		}
+
+		// Images joining the post must exist and pass moderation, all images are read again when the obscured overlays
+		// are chosen, as overlays left out of the post are not stored in it
+		for _, imageId := range imagesIds {
+			if indexOfString(addedImagesIds, imageId) < 0 && !update.obscuredOverlaysProvided {
+				continue
+			}
+
+			image, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_IMAGES_COLLECTION, imageId)
+			if err != nil {
+				return 0, err
+			}
+
+			if image == nil {
+				return 0, errors.New("image " + imageId + " does not exist")
+			}
+
+			if !tools.IsImageModerationApproved(image) {
+				return 0, errors.New("image " + imageId + " did not pass moderation")
+			}
+
+			if tools.IsDocumentInTrash(image) {
+				return 0, errors.New("image " + imageId + " is in the trash")
+			}
+
+			newMedia[imageId] = convertImageDocumentToPostMedia(image)
+			images[imageId] = image
+		}
+
+		// Images leaving the post are read to keep the other posts using them, images already purged are skipped
+		for _, imageId := range removedImagesIds {
+			image, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_IMAGES_COLLECTION, imageId)
+			if err != nil {
+				return 0, err
+			}
+
+			if image != nil {
+				images[imageId] = image
+			}
+		}
+
+		// Images staying in the post keep their media, including the chosen obscured overlays
+		for _, imageId := range imagesIds {
+			if _, ok := newMedia[imageId]; !ok {
+				newMedia[imageId] = getPostImageMedia(post, imageId)
+			}
+		}
+
+		// A rollback shows the versions of the obscured overlays recorded by the revision instead of the current ones
+		for imageId, obscuredOverlay := range update.obscuredOverlays {
+			imageMedia, ok := newMedia[imageId]
+			if !ok || obscuredOverlay.Url == "" {
+				continue
+			}
+
+			imageMedia.obscuredOverlayUrl = obscuredOverlay.Url
+			imageMedia.obscuredOverlayStoragePath = obscuredOverlay.StoragePath
+			imageMedia.obscuredUrl = obscuredOverlay.ImageUrl
+			imageMedia.obscuredStoragePath = obscuredOverlay.ImageStoragePath
+			imageMedia.obscuredAnimationUrl = obscuredOverlay.AnimationUrl
+			imageMedia.obscuredAnimationStoragePath = obscuredOverlay.AnimationStoragePath
+			imageMedia.obscuredFacesIds = obscuredOverlay.FacesIds
+			imageMedia.obscuredRegionsIds = obscuredOverlay.RegionsIds
+			newMedia[imageId] = imageMedia
+		}
+
+		if update.obscuredOverlaysProvided {
+			for _, imageId := range update.obscuredOverlaysIds {
+				if indexOfString(imagesIds, imageId) < 0 {
+					return 0, errors.New("obscured overlay " + imageId + " is not an image of the post")
+				}
+
+				if newMedia[imageId].obscuredOverlayUrl == "" {
+					return 0, errors.New("image " + imageId + " has no obscured overlay")
+				}
+			}
+
+			for _, imageId := range imagesIds {
+				if indexOfString(update.obscuredOverlaysIds, imageId) < 0 {
+					imageMedia := newMedia[imageId]
+					imageMedia.obscuredOverlayUrl = ""
+					imageMedia.obscuredOverlayStoragePath = ""
+					imageMedia.obscuredUrl = ""
+					imageMedia.obscuredStoragePath = ""
+					imageMedia.obscuredAnimationUrl = ""
+					imageMedia.obscuredAnimationStoragePath = ""
+					imageMedia.obscuredFacesIds = nil
+					imageMedia.obscuredRegionsIds = nil
+					newMedia[imageId] = imageMedia
+				}
+			}
+		}
+
+		mediaFields := buildPostMediaFields(imagesIds, newMedia)
+		for field, value := range mediaFields {
+			updates[field] = value
+		}
+
+		revision = setPostMediaFields(revision, mediaFields)
+	}
+
+	if len(updates) == 0 {
+		return post.Version, nil
+	}
+
+	revision.Revision = post.Revision + 1
+	updates[types.FIREBASE_POSTS_FIELDS_REVISION] = revision.Revision
+	updates[types.FIREBASE_POSTS_FIELDS_VERSION] = post.Version + 1
+	updates[types.FIREBASE_POSTS_FIELDS_UPDATED_AT] = firestore.ServerTimestamp
+
+	err = tx.Update(db.Collection(types.FIREBASE_POSTS_COLLECTION).Doc(id), tools.ConvertToFirestoreUpdates(updates))
+	if err != nil {
+		return 0, err
+	}
+
+	err = createPostRevision(tx, db, revision, editor.uid)
+	if err != nil {
+		return 0, err
+	}
+
+	// Adjust the scores of the added and removed hash tags
+	for _, hashTagId := range addedHashTagsIds {
+		err = changeHashTagScore(tx, db, hashTagId, update.hashTagsValues[indexOfString(update.hashTagsIds, hashTagId)], hashTags[hashTagId], 1)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for _, hashTagId := range removedHashTagsIds {
+		err = changeHashTagScore(tx, db, hashTagId, "", hashTags[hashTagId], -1)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Detach the images leaving the post and attach the ones joining it
+	for _, imageId := range removedImagesIds {
+		image, ok := images[imageId]
+		if !ok {
+			continue
+		}
+
+		err = setImagePostsIds(tx, db, imageId, getPostImageMedia(post, imageId), changeImagePostsIds(image, id, false))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for _, imageId := range addedImagesIds {
+		err = setImagePostsIds(tx, db, imageId, newMedia[imageId], changeImagePostsIds(images[imageId], id, true))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return post.Version + 1, nil
+}
+
+// User changing a post, recorded as the author of the changes
+type postEditor struct {
+	uid   string
+	admin bool
+}
+
+func getPostEditor(c *gin.Context) postEditor {
+	editor := postEditor{
+		admin: tools.IsAdminUser(c),
+	}
+
+	if token := tools.GetAuthToken(c); token != nil {
+		editor.uid = token.UID
+	}
+
+	return editor
+}
+
+// Contributors can only change the posts they wrote, admins can change all posts
+func (editor postEditor) canEdit(post types.Post) bool {
+	return editor.admin || (editor.uid != "" && editor.uid == post.AuthorUid)
+}
+
+// Checks if a page is the newest one, which is the page without cursor or a page reached going back with nothing before it
+func isNewestPostsPage(cursorToken string, prevCursor string) bool {
+	if cursorToken == "" {
+		return true
+	}
+
+	cursor, err := tools.DecodePostsCursor(cursorToken)
+	if err != nil {
+		return false
+	}
+
+	return cursor.Direction == types.POSTS_CURSOR_DIRECTION_PREV && prevCursor == ""
+}
+
+// Gets a page of posts ordered from the newest, starting after the cursor. Posts are ordered by publishedAt and id,
+// so posts published at the same time are neither skipped nor repeated. Returns the cursors of the next and previous pages,
+// empty when there are no more posts in that direction. When a match function is given, the posts Firestore can not
+// filter are checked on the server and the query is read in batches until the page is full.
+func getPostsPage(c context.Context, query firestore.Query, cursorToken string, pageSize int, match func(post types.Post) bool) ([]types.Post, string, string, error) {
+	var matchData func(data map[string]interface{}) bool
+	if match != nil {
+		matchData = func(data map[string]interface{}) bool {
+			return match(convertDocumentToPost(data))
+		}
+	}
+
+	docs, nextCursor, prevCursor, err := getDocumentsPageOrderedBy(c, query, types.FIREBASE_POSTS_FIELDS_PUBLISHED_AT, cursorToken, pageSize, firestore.Desc, matchData)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	posts := []types.Post{}
+	for _, doc := range docs {
+		posts = append(posts, convertDocumentToPost(doc.Data()))
+	}
+
+	return posts, nextCursor, prevCursor, nil
+}
+
+// Gets a page of documents ordered by createdAt and id in the given order, starting after the cursor. Used for the
+// comments and albums, which share the createdAt field and the cursor format of the posts.
+func getDocumentsPage(c context.Context, query firestore.Query, cursorToken string, pageSize int, order firestore.Direction, match func(data map[string]interface{}) bool) ([]*firestore.DocumentSnapshot, string, string, error) {
+	return getDocumentsPageOrderedBy(c, query, types.FIREBASE_POSTS_FIELDS_CREATED_AT, cursorToken, pageSize, order, match)
+}
+
+// Gets a page of documents ordered by a time field and id, the cursors hold the time of that field
+func getDocumentsPageOrderedBy(c context.Context, query firestore.Query, orderField string, cursorToken string, pageSize int, order firestore.Direction, match func(data map[string]interface{}) bool) ([]*firestore.DocumentSnapshot, string, string, error) {
+	if pageSize < 1 {
+		return nil, "", "", errors.New("pageSize must be greater than 0")
+	}
+	pageSize = min(pageSize, types.POSTS_PAGE_MAX_SIZE)
+
+	direction := types.POSTS_CURSOR_DIRECTION_NEXT
+
+	var cursor types.PostsCursor
+	var err error
+	if cursorToken != "" {
+		cursor, err = tools.DecodePostsCursor(cursorToken)
+		if err != nil {
+			return nil, "", "", err
+		}
+
+		// Previous pages are read in the reverse order and flipped back below
+		direction = cursor.Direction
+		if direction == types.POSTS_CURSOR_DIRECTION_PREV {
+			order = reverseDirection(order)
+		}
+	}
+
+	query = query.OrderBy(orderField, order).OrderBy(firestore.DocumentID, order)
+
+	batchQuery := query
+	if cursorToken != "" {
+		batchQuery = query.StartAfter(cursor.CreatedAt, cursor.Id)
+	}
+
+	// Read one more document to know if there is another page
+	batchSize := pageSize + 1
+	if match != nil && batchSize < types.POSTS_SCAN_BATCH_SIZE {
+		batchSize = types.POSTS_SCAN_BATCH_SIZE
+	}
+
+	var docs []*firestore.DocumentSnapshot
+
+	// The scan stops after a number of batches, the cursor to continue is then made from the last document scanned
+	var lastScanned *firestore.DocumentSnapshot
+	scanLimited := false
+
+	for batches := 0; len(docs) <= pageSize; batches++ {
+		if batches == types.POSTS_SCAN_MAX_BATCHES {
+			scanLimited = true
+			break
+		}
+
+		batch, err := batchQuery.Limit(batchSize).Documents(c).GetAll()
+		if err != nil {
+			return nil, "", "", err
+		}
+
+		for _, doc := range batch {
+			lastScanned = doc
+			if match == nil || match(doc.Data()) {
+				docs = append(docs, doc)
+			}
+
+			if len(docs) > pageSize {
+				break
+			}
+		}
+
+		if len(batch) < batchSize {
+			break
+		}
+
+		last := batch[len(batch)-1]
+		batchQuery = query.StartAfter(last.Data()[orderField], last.Ref.ID)
+	}
+
+	hasMore := len(docs) > pageSize
+	if hasMore {
+		docs = docs[:pageSize]
+	}
+
+	if len(docs) == 0 && !scanLimited {
+		return docs, "", "", nil
+	}
+
+	// Documents at both ends of the page in the order they were scanned, a limited scan continues from the last one scanned
+	scanFirst, scanLast := lastScanned, lastScanned
+	if len(docs) > 0 {
+		scanFirst = docs[0]
+		if !scanLimited {
+			scanLast = docs[len(docs)-1]
+		}
+	}
+
+	// There are always documents behind the cursor which was given
+	hasAhead := hasMore || scanLimited
+	hasBehind := cursorToken != ""
+
+	// Going back the documents were scanned from the newest, so the ends are swapped
+	first, last := scanFirst, scanLast
+	hasNext, hasPrev := hasAhead, hasBehind
+	if direction == types.POSTS_CURSOR_DIRECTION_PREV {
+		for i, j := 0, len(docs)-1; i < j; i, j = i+1, j-1 {
+			docs[i], docs[j] = docs[j], docs[i]
+		}
+
+		first, last = scanLast, scanFirst
+		hasNext, hasPrev = hasBehind, hasAhead
+	}
+
+	nextCursor := ""
+	if hasNext {
+		nextCursor, err = tools.EncodePostsCursor(last.Data()[orderField].(time.Time), last.Ref.ID, types.POSTS_CURSOR_DIRECTION_NEXT)
+		if err != nil {
+			return nil, "", "", err
+		}
+	}
+
+	prevCursor := ""
+	if hasPrev {
+		prevCursor, err = tools.EncodePostsCursor(first.Data()[orderField].(time.Time), first.Ref.ID, types.POSTS_CURSOR_DIRECTION_PREV)
+		if err != nil {
+			return nil, "", "", err
+		}
+	}
+
+	return docs, nextCursor, prevCursor, nil
+}
+
+func reverseDirection(order firestore.Direction) firestore.Direction {
+	if order == firestore.Asc {
+		return firestore.Desc
+	}
+
+	return firestore.Asc
+}
+
+// Validates the status of a post, scheduled posts need a publish time in the future
+func parsePostStatus(status string, publishAtValue string) (string, *time.Time, error) {
+	switch status {
+	case types.POST_STATUS_DRAFT, types.POST_STATUS_PUBLISHED, types.POST_STATUS_ARCHIVED:
+		return status, nil, nil
+	case types.POST_STATUS_SCHEDULED:
+		publishAt, err := time.Parse(time.RFC3339, publishAtValue)
+		if err != nil {
+			return "", nil, errors.New("publishAt must be an RFC3339 time for scheduled posts")
+		}
+
+		if !publishAt.After(time.Now()) {
+			return "", nil, errors.New("publishAt must be in the future")
+		}
+
+		return status, &publishAt, nil
+	default:
+		return "", nil, errors.New("status must be one of draft, scheduled, published or archived")
+	}
+}
+
+func isPostStatus(status string) bool {
+	switch status {
+	case types.POST_STATUS_DRAFT, types.POST_STATUS_SCHEDULED, types.POST_STATUS_PUBLISHED, types.POST_STATUS_ARCHIVED:
+		return true
+	}
+
+	return false
+}
+
+// Creates the task publishing a scheduled post, the post stays scheduled when it fails and is published by the periodic
+// scheduled posts run
+func schedulePostPublishing(c *gin.Context, logger *logging.Logger, tasksClient *cloudtasks.Client, publishAt time.Time) {
+	_, err := tasks.CreateScheduledPostsTask(c, tasksClient, logger, publishAt)
+	if err != nil {
+		logger.Log(logging.Entry{
+			Severity: logging.Warning,
+			Payload:  "Scheduled post is waiting for the next scheduled posts run: " + err.Error(),
+			Labels:   map[string]string{"status": "warning"},
+		})
+	}
+}
+
+// Creates the task publishing a scheduled post whose publish time passed while it could not be published, the task
+// created when it was scheduled already ran. Posts which are not scheduled or not due yet need no new task.
+func rescheduleDuePostPublishing(c *gin.Context, logger *logging.Logger, tasksClient *cloudtasks.Client, post map[string]interface{}) bool {
+	publishAt, ok := post[types.FIREBASE_POSTS_FIELDS_PUBLISH_AT].(time.Time)
+	if post[types.FIREBASE_POSTS_FIELDS_STATUS] != types.POST_STATUS_SCHEDULED || !ok || publishAt.After(time.Now()) {
+		return false
+	}
+
+	schedulePostPublishing(c, logger, tasksClient, time.Now())
+	return true
+}
+
+// Creates the task publishing the scheduled posts which are due and were held back by the given images, once they were
+// approved or restored. A single task publishes all of them.
+func rescheduleImagesPostsPublishing(c *gin.Context, logger *logging.Logger, firestoreClient *firestore.Client, tasksClient *cloudtasks.Client, imagesIds []string) {
+	for _, imageId := range imagesIds {
+		docs, err := firestoreClient.Collection(types.FIREBASE_POSTS_COLLECTION).
+			Where(types.FIREBASE_POSTS_FIELDS_IMAGES_IDS, "array-contains", imageId).
+			Where(types.FIREBASE_POSTS_FIELDS_STATUS, "==", types.POST_STATUS_SCHEDULED).
+			Documents(c).GetAll()
+		if err != nil {
+			logger.Log(logging.Entry{
+				Severity: logging.Warning,
+				Payload:  "Scheduled posts of image " + imageId + " are waiting for the next scheduled posts run: " + err.Error(),
+				Labels:   map[string]string{"status": "warning"},
+			})
+			continue
+		}
+
+		for _, doc := range docs {
+			// Posts in the trash are rescheduled when they are restored
+			if tools.IsDocumentInTrash(doc.Data()) {
+				continue
+			}
+
+			if rescheduleDuePostPublishing(c, logger, tasksClient, doc.Data()) {
+				return
+			}
+		}
+	}
+}
+
+// Limits a posts query to the posts the user can list, admins can filter by status and other users only see published posts
+// which are public or visible to authenticated users. Posts in the trash are only listed by the trash endpoints.
+func filterReadablePosts(c *gin.Context, query firestore.Query) (firestore.Query, error) {
+	query = query.Where(types.FIREBASE_POSTS_FIELDS_DELETED_AT, "==", nil)
+
+	if !tools.IsAdminUser(c) {
+		return query.
+			Where(types.FIREBASE_POSTS_FIELDS_STATUS, "==", types.POST_STATUS_PUBLISHED).
+			Where(types.FIREBASE_POSTS_FIELDS_VISIBILITY, "in", getListedPostVisibilities()), nil
+	}
+
+	status, statusProvided := c.GetQuery(types.FIREBASE_POSTS_FIELDS_STATUS)
+	if !statusProvided {
+		return query, nil
+	}
+
+	if !isPostStatus(status) {
+		return query, errors.New("status must be one of draft, scheduled, published or archived")
+	}
+
+	return query.Where(types.FIREBASE_POSTS_FIELDS_STATUS, "==", status), nil
+}
+
+// Limits a posts query to the posts published between the startDate and endDate query parameters, when they are provided
+func filterPostsByDates(c *gin.Context, query firestore.Query) (firestore.Query, error) {
+	// If a start date is provided, add a filter for it
+	if startDateStr, startDateProvided := c.GetQuery("startDate"); startDateProvided {
+		startDate, err := time.Parse(time.RFC3339, startDateStr)
+		if err != nil {
+			return query, err
+		}
+
+		query = query.Where(types.FIREBASE_POSTS_FIELDS_PUBLISHED_AT, ">=", startDate)
+	}
+
+	// If an end date is provided, add a filter for it
+	if endDateStr, endDateProvided := c.GetQuery("endDate"); endDateProvided {
+		endDate, err := time.Parse(time.RFC3339, endDateStr)
+		if err != nil {
+			return query, err
+		}
+
+		query = query.Where(types.FIREBASE_POSTS_FIELDS_PUBLISHED_AT, "<=", endDate)
+	}
+
+	return query, nil
+}
+
+// Returns whether a post has every hash tag of all, at least one of any when it is not empty, and none of none
+func matchPostHashTags(post types.Post, all []string, any []string, none []string) bool {
+	for _, hashTag := range all {
+		if indexOfString(post.HashTagsValues, hashTag) < 0 {
+			return false
+		}
+	}
+
+	for _, hashTag := range none {
+		if indexOfString(post.HashTagsValues, hashTag) >= 0 {
+			return false
+		}
+	}
+
+	if len(any) == 0 {
+		return true
+	}
+
+	for _, hashTag := range any {
+		if indexOfString(post.HashTagsValues, hashTag) >= 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Splits a comma separated list of hash tags, ignoring empty values
+func splitHashTags(value string) []string {
+	if value == "" {
+		return nil
+	}
+
+	return removeEmptyStrings(strings.Split(value, ","))
+}
+
+// Returns whether the user can read the post from its link. Authors and admins read all posts, other users read
+// published posts which are not private.
+func canReadPost(c *gin.Context, post types.Post) bool {
+	if post.DeletedAt != "" {
+		return false
+	}
+
+	if getPostEditor(c).canEdit(post) {
+		return true
+	}
+
+	return post.Status == types.POST_STATUS_PUBLISHED && post.Visibility != types.POST_VISIBILITY_PRIVATE
+}
+
+// Returns whether the post can be shared through a link, private posts stay with their author
+func canSharePost(post types.Post) bool {
+	return post.DeletedAt == "" && post.Status == types.POST_STATUS_PUBLISHED && post.Visibility != types.POST_VISIBILITY_PRIVATE
+}
+
+// Returns whether the post is public, public posts are shown outside the app without a link
+func isPublicPost(post types.Post) bool {
+	return post.DeletedAt == "" && post.Status == types.POST_STATUS_PUBLISHED && post.Visibility == types.POST_VISIBILITY_PUBLIC
+}
+
+// Returns whether the post can be listed to the user, like filterReadablePosts does for queries
+func canListPost(c *gin.Context, post types.Post) bool {
+	if post.DeletedAt != "" {
+		return false
+	}
+
+	if tools.IsAdminUser(c) {
+		return true
+	}
+
+	return post.Status == types.POST_STATUS_PUBLISHED && slices.Contains(getListedPostVisibilities(), post.Visibility)
+}
+
+// Visibilities of the posts listed to every authenticated user
+func getListedPostVisibilities() []string {
+	return []string{types.POST_VISIBILITY_PUBLIC, types.POST_VISIBILITY_AUTHENTICATED}
+}
+
+// Validates the visibility of a post
+func parsePostVisibility(visibility string) (string, error) {
+	switch visibility {
+	case types.POST_VISIBILITY_PUBLIC, types.POST_VISIBILITY_AUTHENTICATED, types.POST_VISIBILITY_PRIVATE, types.POST_VISIBILITY_UNLISTED:
+		return visibility, nil
+	default:
+		return "", errors.New("visibility must be one of public, authenticated, private or unlisted")
+	}
+}
+
+// Converts a post document data to a Post
+func convertDocumentToPost(data map[string]interface{}) types.Post {
+	status, _ := data[types.FIREBASE_POSTS_FIELDS_STATUS].(string)
+	visibility, _ := data[types.FIREBASE_POSTS_FIELDS_VISIBILITY].(string)
+	authorUid, _ := data[types.FIREBASE_POSTS_FIELDS_AUTHOR_UID].(string)
+
+	publishAt := ""
+	if value, ok := data[types.FIREBASE_POSTS_FIELDS_PUBLISH_AT].(time.Time); ok {
+		publishAt = value.Format(time.RFC3339)
+	}
+
+	createdAt := data[types.FIREBASE_POSTS_FIELDS_CREATED_AT].(time.Time).Format(time.RFC3339)
+
+	// Posts not backfilled yet were published when they were created
+	publishedAt := createdAt
+	if value, ok := data[types.FIREBASE_POSTS_FIELDS_PUBLISHED_AT].(time.Time); ok {
+		publishedAt = value.Format(time.RFC3339)
+	}
+
+	updatedAt := publishedAt
+	if value, ok := data[types.FIREBASE_POSTS_FIELDS_UPDATED_AT].(time.Time); ok {
+		updatedAt = value.Format(time.RFC3339)
+	}
+
+	revision, _ := data[types.FIREBASE_POSTS_FIELDS_REVISION].(int64)
+	commentsCount, _ := data[types.FIREBASE_POSTS_FIELDS_COMMENTS_COUNT].(int64)
+
+	deletedAt := ""
+	if value, ok := data[types.FIREBASE_POSTS_FIELDS_DELETED_AT].(time.Time); ok {
+		deletedAt = value.Format(time.RFC3339)
+	}
+
+	// Pins which expired are kept in the document but the post is no longer pinned
+	pinned, _ := data[types.FIREBASE_POSTS_FIELDS_PINNED].(bool)
+	pinWeight, _ := data[types.FIREBASE_POSTS_FIELDS_PIN_WEIGHT].(int64)
+
+	pinnedAt := ""
+	if value, ok := data[types.FIREBASE_POSTS_FIELDS_PINNED_AT].(time.Time); ok && pinned {
+		pinnedAt = value.Format(time.RFC3339)
+	}
+
+	pinnedUntil := ""
+	if value, ok := data[types.FIREBASE_POSTS_FIELDS_PINNED_UNTIL].(time.Time); ok && pinned {
+		pinnedUntil = value.Format(time.RFC3339)
+		pinned = value.After(time.Now())
+	}
+
+	return types.Post{
+		Id:                             data[types.FIREBASE_POSTS_FIELDS_ID].(string),
+		Body:                           data[types.FIREBASE_POSTS_FIELDS_BODY].(string),
+		CreatedAt:                      createdAt,
+		PublishedAt:                    publishedAt,
+		UpdatedAt:                      updatedAt,
+		Status:                         status,
+		Visibility:                     visibility,
+		AuthorUid:                      authorUid,
+		PublishAt:                      publishAt,
+		DeletedAt:                      deletedAt,
+		Revision:                       revision,
+		Version:                        tools.GetDocumentVersion(data),
+		CommentsCount:                  commentsCount,
+		Pinned:                         pinned,
+		PinnedAt:                       pinnedAt,
+		PinnedUntil:                    pinnedUntil,
+		PinWeight:                      pinWeight,
+		ReactionsCounts:                convertInterfaceToMapInt64(data[types.FIREBASE_POSTS_FIELDS_REACTIONS_COUNTS]),
+		Reactions:                      []string{},
+		HashTagsValues:                 convertInterfaceToArrayString(data[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_VALUES]),
+		HashTagsIds:                    convertInterfaceToArrayString(data[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_IDS]),
+		ImagesIds:                      convertInterfaceToArrayString(data[types.FIREBASE_POSTS_FIELDS_IMAGES_IDS]),
+		ImagesUrls:                     convertInterfaceToArrayString(data[types.FIREBASE_POSTS_FIELDS_IMAGES_URLS]),
+		ImagesStoragePaths:             convertInterfaceToArrayString(data[types.FIREBASE_POSTS_FIELDS_IMAGES_STORAGE_PATHS]),
+		FacesIds:                       convertInterfaceToMapStringArray(data[types.FIREBASE_POSTS_FIELDS_FACES_IDS]),
+		FacesUrls:                      convertInterfaceToMapStringArray(data[types.FIREBASE_POSTS_FIELDS_FACES_URLS]),
+		FacesStoragePaths:              convertInterfaceToMapStringArray(data[types.FIREBASE_POSTS_FIELDS_FACES_STORAGE_PATHS]),
+		RegionsIds:                     convertInterfaceToMapStringArray(data[types.FIREBASE_POSTS_FIELDS_REGIONS_IDS]),
+		OverlaysIds:                    convertInterfaceToArrayString(data[types.FIREBASE_POSTS_FIELDS_OVERLAYS_IDS]),
+		OverlaysUrls:                   convertInterfaceToArrayString(data[types.FIREBASE_POSTS_FIELDS_OVERLAYS_URLS]),
+		OverlaysStoragePaths:           convertInterfaceToArrayString(data[types.FIREBASE_POSTS_FIELDS_OVERLAYS_STORAGE_PATHS]),
+		ObscuredOverlaysIds:            convertInterfaceToArrayString(data[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_IDS]),
+		ObscuredOverlaysUrls:           convertInterfaceToArrayString(data[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_URLS]),
+		ObscuredOverlaysStoragePaths:   convertInterfaceToArrayString(data[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_STORAGE_PATHS]),
+		ObscuredImagesUrls:             convertInterfaceToArrayString(data[types.FIREBASE_POSTS_FIELDS_OBSCURED_IMAGES_URLS]),
+		ObscuredImagesStoragePaths:     convertInterfaceToArrayString(data[types.FIREBASE_POSTS_FIELDS_OBSCURED_IMAGES_STORAGE_PATHS]),
+		ObscuredAnimationsUrls:         convertInterfaceToArrayString(data[types.FIREBASE_POSTS_FIELDS_OBSCURED_ANIMATIONS_URLS]),
+		ObscuredAnimationsStoragePaths: convertInterfaceToArrayString(data[types.FIREBASE_POSTS_FIELDS_OBSCURED_ANIMATIONS_STORAGE_PATHS]),
+		ObscuredFacesIds:               convertInterfaceToMapStringArray(data[types.FIREBASE_POSTS_FIELDS_OBSCURED_FACES_IDS]),
+		ObscuredRegionsIds:             convertInterfaceToMapStringArray(data[types.FIREBASE_POSTS_FIELDS_OBSCURED_REGIONS_IDS]),
+	}
+}
+
+// Converts a post to what is shown outside the app. Images shown obscured are served through the rendition of their
+// obscured overlay drawn over the original, images without faces or text regions through the original. Any other image
+// is left out, it has nothing hiding what the app would obscure.
+func convertPostToPublicPost(post types.Post) types.PublicPost {
+	publicPost := types.PublicPost{
+		Id:             post.Id,
+		Body:           post.Body,
+		CreatedAt:      post.CreatedAt,
+		PublishedAt:    post.PublishedAt,
+		UpdatedAt:      post.UpdatedAt,
+		HashTagsValues: post.HashTagsValues,
+		ImagesIds:      []string{},
+		ImagesUrls:     []string{},
+		ImagesTypes:    []string{},
+	}
+
+	for _, imageId := range post.ImagesIds {
+		url, storagePath := getPostImagePublicFile(getPostImageMedia(post, imageId))
+		if url == "" {
+			continue
+		}
+
+		imageType := mime.TypeByExtension(path.Ext(storagePath))
+		if imageType == "" {
+			imageType = "application/octet-stream"
+		}
+
+		publicPost.ImagesIds = append(publicPost.ImagesIds, imageId)
+		publicPost.ImagesUrls = append(publicPost.ImagesUrls, url)
+		publicPost.ImagesTypes = append(publicPost.ImagesTypes, imageType)
+	}
+
+	return publicPost
+}
+
+// Returns the url and storage path of the file an image of a post is shown through outside the app, empty if it is not
+// shown there. Animated images shown obscured are served through their obscured animation once it is rendered.
+func getPostImagePublicFile(media postImageMedia) (string, string) {
+	if media.obscuredOverlayUrl != "" && media.obscuredAnimationUrl != "" {
+		return media.obscuredAnimationUrl, media.obscuredAnimationStoragePath
+	}
+
+	if media.obscuredOverlayUrl != "" {
+		return media.obscuredUrl, media.obscuredStoragePath
+	}
+
+	if len(media.facesIds) == 0 && len(media.regionsIds) == 0 {
+		return media.url, media.storagePath
+	}
+
+	return "", ""
+}
+
+func convertInterfaceToArrayString(data interface{}) []string {
+	if data == nil {
+		return []string{}
+	}
+
+	dataSlice, ok := data.([]interface{})
+	if !ok {
+		// data is not a slice of interfaces, return an empty slice
+		return []string{}
+	}
+
+	result := make([]string, len(dataSlice))
+	for i, v := range dataSlice {
+		str, ok := v.(string)
+		if ok {
+			result[i] = str
 		}
 	}
 
@@ -437,8 +1462,328 @@ func convertInterfaceToArrayString(data interface{}) []string {
 
 func convertInterfaceToMapStringArray(value interface{}) map[string][]string {
 	result := make(map[string][]string)
-	for key, val := range value.(map[string]interface{}) {
+
+	valueMap, ok := value.(map[string]interface{})
+	if !ok {
+		// value is not a map, e.g. the field is missing on older posts
+		return result
+	}
+
+	for key, val := range valueMap {
 		result[key] = convertInterfaceToArrayString(val)
 	}
 	return result
 }
+
+func convertInterfaceToMapInt64(value interface{}) map[string]int64 {
+	result := make(map[string]int64)
+
+	valueMap, ok := value.(map[string]interface{})
+	if !ok {
+		// value is not a map, e.g. the counts were never rolled up on the post
+		return result
+	}
+
+	for key, val := range valueMap {
+		if count, ok := val.(int64); ok {
+			result[key] = count
+		}
+	}
+	return result
+}
+
+// Media of a single image in a post
+type postImageMedia struct {
+	url                          string
+	storagePath                  string
+	facesIds                     []string
+	facesUrls                    []string
+	facesStoragePaths            []string
+	regionsIds                   []string
+	overlayUrl                   string
+	overlayStoragePath           string
+	obscuredOverlayUrl           string
+	obscuredOverlayStoragePath   string
+	obscuredUrl                  string
+	obscuredStoragePath          string
+	obscuredAnimationUrl         string
+	obscuredAnimationStoragePath string
+	obscuredFacesIds             []string
+	obscuredRegionsIds           []string
+}
+
+// Reads the media of an image from its document in the images collection
+func convertImageDocumentToPostMedia(image map[string]interface{}) postImageMedia {
+	media := postImageMedia{
+		facesIds:          convertInterfaceToArrayString(image[types.FIREBASE_IMAGES_FIELDS_FACES_IDS]),
+		facesUrls:         convertInterfaceToArrayString(image[types.FIREBASE_IMAGES_FIELDS_FACES_URLS]),
+		facesStoragePaths: convertInterfaceToArrayString(image[types.FIREBASE_IMAGES_FIELDS_FACES_STORAGE_PATHS]),
+		regionsIds:        convertInterfaceToArrayString(image[types.FIREBASE_IMAGES_FIELDS_REGIONS_IDS]),
+	}
+
+	media.url, _ = image[types.FIREBASE_IMAGES_FIELDS_URL].(string)
+	media.storagePath, _ = image[types.FIREBASE_IMAGES_FIELDS_STORAGE_PATH].(string)
+	media.overlayUrl, _ = image[types.FIREBASE_IMAGES_FIELDS_FACES_OVERLAY_URL].(string)
+	media.overlayStoragePath, _ = image[types.FIREBASE_IMAGES_FIELDS_FACES_OVERLAY_STORAGE_PATH].(string)
+	media.obscuredOverlayUrl, _ = image[types.FIREBASE_IMAGES_FIELDS_FACES_OBSCURED_OVERLAY_URL].(string)
+	media.obscuredOverlayStoragePath, _ = image[types.FIREBASE_IMAGES_FIELDS_FACES_OBSCURED_OVERLAY_STORAGE_PATH].(string)
+	media.obscuredUrl, _ = image[types.FIREBASE_IMAGES_FIELDS_OBSCURED_URL].(string)
+	media.obscuredStoragePath, _ = image[types.FIREBASE_IMAGES_FIELDS_OBSCURED_STORAGE_PATH].(string)
+	media.obscuredAnimationUrl, _ = image[types.FIREBASE_IMAGES_FIELDS_ANIMATION_OBSCURED_URL].(string)
+	media.obscuredAnimationStoragePath, _ = image[types.FIREBASE_IMAGES_FIELDS_ANIMATION_OBSCURED_STORAGE_PATH].(string)
+	media.obscuredFacesIds = convertInterfaceToArrayString(image[types.FIREBASE_IMAGES_FIELDS_OBSCURED_FACES_IDS])
+	media.obscuredRegionsIds = convertInterfaceToArrayString(image[types.FIREBASE_IMAGES_FIELDS_OBSCURED_REGIONS_IDS])
+
+	return media
+}
+
+// Reads the media of an image from the post, overlays are identified by the image id
+func getPostImageMedia(post types.Post, imageId string) postImageMedia {
+	media := postImageMedia{
+		facesIds:          post.FacesIds[imageId],
+		facesUrls:         post.FacesUrls[imageId],
+		facesStoragePaths: post.FacesStoragePaths[imageId],
+		regionsIds:        post.RegionsIds[imageId],
+	}
+
+	if i := indexOfString(post.ImagesIds, imageId); i >= 0 {
+		media.url = valueAtIndex(post.ImagesUrls, i)
+		media.storagePath = valueAtIndex(post.ImagesStoragePaths, i)
+	}
+
+	if i := indexOfString(post.OverlaysIds, imageId); i >= 0 {
+		media.overlayUrl = valueAtIndex(post.OverlaysUrls, i)
+		media.overlayStoragePath = valueAtIndex(post.OverlaysStoragePaths, i)
+	}
+
+	if i := indexOfString(post.ObscuredOverlaysIds, imageId); i >= 0 {
+		media.obscuredOverlayUrl = valueAtIndex(post.ObscuredOverlaysUrls, i)
+		media.obscuredOverlayStoragePath = valueAtIndex(post.ObscuredOverlaysStoragePaths, i)
+		media.obscuredUrl = valueAtIndex(post.ObscuredImagesUrls, i)
+		media.obscuredStoragePath = valueAtIndex(post.ObscuredImagesStoragePaths, i)
+		media.obscuredAnimationUrl = valueAtIndex(post.ObscuredAnimationsUrls, i)
+		media.obscuredAnimationStoragePath = valueAtIndex(post.ObscuredAnimationsStoragePaths, i)
+		media.obscuredFacesIds = post.ObscuredFacesIds[imageId]
+		media.obscuredRegionsIds = post.ObscuredRegionsIds[imageId]
+	}
+
+	return media
+}
+
+// Returns the storage paths of the image, its faces and its overlays
+func getPostImageMediaStoragePaths(media postImageMedia) []string {
+	paths := removeEmptyStrings([]string{media.storagePath, media.overlayStoragePath, media.obscuredOverlayStoragePath, media.obscuredStoragePath, media.obscuredAnimationStoragePath})
+	paths = append(paths, removeEmptyStrings(media.facesStoragePaths)...)
+
+	return paths
+}
+
+// Builds the post media fields for the images in the given order
+func buildPostMediaFields(imagesIds []string, media map[string]postImageMedia) map[string]interface{} {
+	imagesUrls := []string{}
+	imagesStoragePaths := []string{}
+	facesIds := map[string][]string{}
+	facesUrls := map[string][]string{}
+	facesStoragePaths := map[string][]string{}
+	regionsIds := map[string][]string{}
+	overlaysIds := []string{}
+	overlaysUrls := []string{}
+	overlaysStoragePaths := []string{}
+	obscuredOverlaysIds := []string{}
+	obscuredOverlaysUrls := []string{}
+	obscuredOverlaysStoragePaths := []string{}
+	obscuredImagesUrls := []string{}
+	obscuredImagesStoragePaths := []string{}
+	obscuredAnimationsUrls := []string{}
+	obscuredAnimationsStoragePaths := []string{}
+	obscuredFacesIds := map[string][]string{}
+	obscuredRegionsIds := map[string][]string{}
+
+	for _, imageId := range imagesIds {
+		imageMedia := media[imageId]
+
+		imagesUrls = append(imagesUrls, imageMedia.url)
+		imagesStoragePaths = append(imagesStoragePaths, imageMedia.storagePath)
+
+		if len(imageMedia.facesIds) > 0 {
+			facesIds[imageId] = imageMedia.facesIds
+			facesUrls[imageId] = imageMedia.facesUrls
+			facesStoragePaths[imageId] = imageMedia.facesStoragePaths
+		}
+
+		if len(imageMedia.regionsIds) > 0 {
+			regionsIds[imageId] = imageMedia.regionsIds
+		}
+
+		if imageMedia.overlayUrl != "" {
+			overlaysIds = append(overlaysIds, imageId)
+			overlaysUrls = append(overlaysUrls, imageMedia.overlayUrl)
+			overlaysStoragePaths = append(overlaysStoragePaths, imageMedia.overlayStoragePath)
+		}
+
+		if imageMedia.obscuredOverlayUrl != "" {
+			obscuredOverlaysIds = append(obscuredOverlaysIds, imageId)
+			obscuredOverlaysUrls = append(obscuredOverlaysUrls, imageMedia.obscuredOverlayUrl)
+			obscuredOverlaysStoragePaths = append(obscuredOverlaysStoragePaths, imageMedia.obscuredOverlayStoragePath)
+			obscuredImagesUrls = append(obscuredImagesUrls, imageMedia.obscuredUrl)
+			obscuredImagesStoragePaths = append(obscuredImagesStoragePaths, imageMedia.obscuredStoragePath)
+			obscuredAnimationsUrls = append(obscuredAnimationsUrls, imageMedia.obscuredAnimationUrl)
+			obscuredAnimationsStoragePaths = append(obscuredAnimationsStoragePaths, imageMedia.obscuredAnimationStoragePath)
+
+			if len(imageMedia.obscuredFacesIds) > 0 {
+				obscuredFacesIds[imageId] = imageMedia.obscuredFacesIds
+			}
+
+			if len(imageMedia.obscuredRegionsIds) > 0 {
+				obscuredRegionsIds[imageId] = imageMedia.obscuredRegionsIds
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		types.FIREBASE_POSTS_FIELDS_IMAGES_IDS:                        imagesIds,
+		types.FIREBASE_POSTS_FIELDS_IMAGES_URLS:                       imagesUrls,
+		types.FIREBASE_POSTS_FIELDS_IMAGES_STORAGE_PATHS:              imagesStoragePaths,
+		types.FIREBASE_POSTS_FIELDS_FACES_IDS:                         facesIds,
+		types.FIREBASE_POSTS_FIELDS_FACES_URLS:                        facesUrls,
+		types.FIREBASE_POSTS_FIELDS_FACES_STORAGE_PATHS:               facesStoragePaths,
+		types.FIREBASE_POSTS_FIELDS_REGIONS_IDS:                       regionsIds,
+		types.FIREBASE_POSTS_FIELDS_OVERLAYS_IDS:                      overlaysIds,
+		types.FIREBASE_POSTS_FIELDS_OVERLAYS_URLS:                     overlaysUrls,
+		types.FIREBASE_POSTS_FIELDS_OVERLAYS_STORAGE_PATHS:            overlaysStoragePaths,
+		types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_IDS:             obscuredOverlaysIds,
+		types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_URLS:            obscuredOverlaysUrls,
+		types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_STORAGE_PATHS:   obscuredOverlaysStoragePaths,
+		types.FIREBASE_POSTS_FIELDS_OBSCURED_IMAGES_URLS:              obscuredImagesUrls,
+		types.FIREBASE_POSTS_FIELDS_OBSCURED_IMAGES_STORAGE_PATHS:     obscuredImagesStoragePaths,
+		types.FIREBASE_POSTS_FIELDS_OBSCURED_ANIMATIONS_URLS:          obscuredAnimationsUrls,
+		types.FIREBASE_POSTS_FIELDS_OBSCURED_ANIMATIONS_STORAGE_PATHS: obscuredAnimationsStoragePaths,
+		types.FIREBASE_POSTS_FIELDS_OBSCURED_FACES_IDS:                obscuredFacesIds,
+		types.FIREBASE_POSTS_FIELDS_OBSCURED_REGIONS_IDS:              obscuredRegionsIds,
+	}
+}
+
+// Sets the media fields built by buildPostMediaFields on a post, so a revision records the media written to the post
+func setPostMediaFields(post types.Post, mediaFields map[string]interface{}) types.Post {
+	post.ImagesIds = mediaFields[types.FIREBASE_POSTS_FIELDS_IMAGES_IDS].([]string)
+	post.ImagesUrls = mediaFields[types.FIREBASE_POSTS_FIELDS_IMAGES_URLS].([]string)
+	post.ImagesStoragePaths = mediaFields[types.FIREBASE_POSTS_FIELDS_IMAGES_STORAGE_PATHS].([]string)
+	post.FacesIds = mediaFields[types.FIREBASE_POSTS_FIELDS_FACES_IDS].(map[string][]string)
+	post.FacesUrls = mediaFields[types.FIREBASE_POSTS_FIELDS_FACES_URLS].(map[string][]string)
+	post.FacesStoragePaths = mediaFields[types.FIREBASE_POSTS_FIELDS_FACES_STORAGE_PATHS].(map[string][]string)
+	post.RegionsIds = mediaFields[types.FIREBASE_POSTS_FIELDS_REGIONS_IDS].(map[string][]string)
+	post.OverlaysIds = mediaFields[types.FIREBASE_POSTS_FIELDS_OVERLAYS_IDS].([]string)
+	post.OverlaysUrls = mediaFields[types.FIREBASE_POSTS_FIELDS_OVERLAYS_URLS].([]string)
+	post.OverlaysStoragePaths = mediaFields[types.FIREBASE_POSTS_FIELDS_OVERLAYS_STORAGE_PATHS].([]string)
+	post.ObscuredOverlaysIds = mediaFields[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_IDS].([]string)
+	post.ObscuredOverlaysUrls = mediaFields[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_URLS].([]string)
+	post.ObscuredOverlaysStoragePaths = mediaFields[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_STORAGE_PATHS].([]string)
+	post.ObscuredImagesUrls = mediaFields[types.FIREBASE_POSTS_FIELDS_OBSCURED_IMAGES_URLS].([]string)
+	post.ObscuredImagesStoragePaths = mediaFields[types.FIREBASE_POSTS_FIELDS_OBSCURED_IMAGES_STORAGE_PATHS].([]string)
+	post.ObscuredAnimationsUrls = mediaFields[types.FIREBASE_POSTS_FIELDS_OBSCURED_ANIMATIONS_URLS].([]string)
+	post.ObscuredAnimationsStoragePaths = mediaFields[types.FIREBASE_POSTS_FIELDS_OBSCURED_ANIMATIONS_STORAGE_PATHS].([]string)
+	post.ObscuredFacesIds = mediaFields[types.FIREBASE_POSTS_FIELDS_OBSCURED_FACES_IDS].(map[string][]string)
+	post.ObscuredRegionsIds = mediaFields[types.FIREBASE_POSTS_FIELDS_OBSCURED_REGIONS_IDS].(map[string][]string)
+
+	return post
+}
+
+// Returns the posts using an image once a post joins or leaves it
+func changeImagePostsIds(image map[string]interface{}, postId string, joins bool) []string {
+	postsIds := []string{}
+	for _, id := range tools.GetImagePostsIds(image) {
+		if id != postId {
+			postsIds = append(postsIds, id)
+		}
+	}
+
+	if joins {
+		postsIds = append(postsIds, postId)
+	}
+
+	return postsIds
+}
+
+// Sets the posts using the image and its faces and text regions within the transaction. The post id written before
+// images could be used by several posts is removed, the posts ids include it.
+func setImagePostsIds(tx *firestore.Transaction, db *firestore.Client, imageId string, media postImageMedia, postsIds []string) error {
+	err := tx.Set(db.Collection(types.FIREBASE_IMAGES_COLLECTION).Doc(imageId), map[string]interface{}{
+		types.FIREBASE_IMAGES_FIELDS_POSTS_IDS: postsIds,
+		types.FIREBASE_IMAGES_FIELDS_POST_ID:   firestore.Delete,
+		types.FIREBASE_IMAGES_FIELDS_VERSION:   firestore.Increment(1),
+	}, firestore.MergeAll)
+	if err != nil {
+		return err
+	}
+
+	for _, faceId := range media.facesIds {
+		err = tx.Set(db.Collection(types.FIREBASE_FACES_COLLECTION).Doc(faceId), map[string]interface{}{
+			types.FIREBASE_FACES_FIELDS_POSTS_IDS: postsIds,
+			types.FIREBASE_FACES_FIELDS_POST_ID:   firestore.Delete,
+			types.FIREBASE_FACES_FIELDS_VERSION:   firestore.Increment(1),
+		}, firestore.MergeAll)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, regionId := range media.regionsIds {
+		err = tx.Set(db.Collection(types.FIREBASE_REGIONS_COLLECTION).Doc(regionId), map[string]interface{}{
+			types.FIREBASE_REGIONS_FIELDS_POSTS_IDS: postsIds,
+			types.FIREBASE_REGIONS_FIELDS_POST_ID:   firestore.Delete,
+		}, firestore.MergeAll)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the values added to and removed from the old list
+func diffStrings(oldValues []string, newValues []string) ([]string, []string) {
+	var added, removed []string
+
+	for _, value := range newValues {
+		if indexOfString(oldValues, value) < 0 {
+			added = append(added, value)
+		}
+	}
+
+	for _, value := range oldValues {
+		if indexOfString(newValues, value) < 0 {
+			removed = append(removed, value)
+		}
+	}
+
+	return added, removed
+}
+
+func indexOfString(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func valueAtIndex(values []string, i int) string {
+	if i < len(values) {
+		return values[i]
+	}
+
+	return ""
+}
+
+func removeEmptyStrings(values []string) []string {
+	result := []string{}
+	for _, value := range values {
+		if value != "" {
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
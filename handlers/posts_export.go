@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"proteggo_api/tools"
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// File of an export copied from storage into the archive
+type postsExportFile struct {
+	name        string
+	storagePath string
+}
+
+// Streams a ZIP archive of a post with the obscured renditions of its images and a manifest, admins can add the
+// original images with originals=true
+func ExportPostHandler(logger *logging.Logger, db *firestore.Client, storage *storage.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+
+		exportPosts(c, logger, db, storage, []string{id}, "post-"+id+".zip")
+	}
+}
+
+// Streams a ZIP archive of several posts given as comma separated ids, each post has its own folder
+func ExportPostsHandler(logger *logging.Logger, db *firestore.Client, storage *storage.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Posts asked twice are exported once
+		ids := []string{}
+		for _, id := range removeEmptyStrings(strings.Split(c.Query("ids"), ",")) {
+			if indexOfString(ids, id) < 0 {
+				ids = append(ids, id)
+			}
+		}
+
+		if len(ids) == 0 {
+			tools.LogError(logger, c, errors.New("ids query parameter is required"))
+			return
+		}
+
+		if len(ids) > types.POSTS_EXPORT_MAX_POSTS {
+			tools.LogError(logger, c, errors.New("at most "+strconv.Itoa(types.POSTS_EXPORT_MAX_POSTS)+" posts can be exported at once"))
+			return
+		}
+
+		exportPosts(c, logger, db, storage, ids, "posts-"+time.Now().UTC().Format("20060102-150405")+".zip")
+	}
+}
+
+// Checks the user can read every post, then streams the archive. Errors after the first byte was sent can only be
+// logged, the client gets a truncated archive.
+func exportPosts(c *gin.Context, logger *logging.Logger, db *firestore.Client, storage *storage.Client, ids []string, filename string) {
+	originals, err := strconv.ParseBool(c.DefaultQuery("originals", "false"))
+	if err != nil {
+		tools.LogError(logger, c, err)
+		return
+	}
+
+	if originals && !tools.IsAdminUser(c) {
+		tools.LogError(logger, c, errors.New("only admins can export the original images"))
+		return
+	}
+
+	manifest := types.PostsExportManifest{
+		ExportedAt: time.Now().Format(time.RFC3339),
+		ExportedBy: getPostEditor(c).uid,
+		Originals:  originals,
+		Posts:      []types.PostExport{},
+	}
+	files := []postsExportFile{}
+
+	for _, id := range ids {
+		data, err := tools.GetFirestoreDocument(c, db, types.FIREBASE_POSTS_COLLECTION, id)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		if data == nil || !canReadPost(c, convertDocumentToPost(data)) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "post " + id + " does not exist",
+			})
+			return
+		}
+
+		postExport, postFiles, err := buildPostExport(c, db, convertDocumentToPost(data), originals)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		manifest.Posts = append(manifest.Posts, postExport)
+		files = append(files, postFiles...)
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		tools.LogError(logger, c, err)
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+
+	archive := zip.NewWriter(c.Writer)
+
+	err = writePostsExport(c, archive, storage, manifestData, files)
+	if err == nil {
+		err = archive.Close()
+	}
+
+	if err != nil {
+		logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload:  "Error streaming the export of posts " + strings.Join(ids, ", ") + ": " + err.Error(),
+			Labels:   map[string]string{"status": "error"},
+		})
+		c.Abort()
+	}
+}
+
+// Describes a post in the manifest and lists the files of its images. Each image has the file it is shown through
+// outside the app, the obscured rendition or the original of an image without faces or text regions, and the originals
+// are only listed when asked
+func buildPostExport(c *gin.Context, db *firestore.Client, post types.Post, originals bool) (types.PostExport, []postsExportFile, error) {
+	postExport := types.PostExport{
+		Id:          post.Id,
+		Body:        post.Body,
+		CreatedAt:   post.CreatedAt,
+		PublishedAt: post.PublishedAt,
+		Status:      post.Status,
+		Visibility:  post.Visibility,
+		HashTags:    post.HashTagsValues,
+		Images:      []types.PostExportImage{},
+	}
+	files := []postsExportFile{}
+
+	for _, imageId := range post.ImagesIds {
+		media := getPostImageMedia(post, imageId)
+
+		image, err := tools.GetFirestoreDocument(c, db, types.FIREBASE_IMAGES_COLLECTION, imageId)
+		if err != nil {
+			return postExport, nil, err
+		}
+
+		imageExport := types.PostExportImage{
+			Id:                 imageId,
+			Obscured:           media.obscuredOverlayUrl != "",
+			ObscuredFacesIds:   append([]string{}, media.obscuredFacesIds...),
+			ObscuredRegionsIds: append([]string{}, media.obscuredRegionsIds...),
+			Faces:              []types.FaceVertices{},
+			Regions:            []types.PostExportRegion{},
+		}
+		imageExport.Width, _ = image[types.FIREBASE_IMAGES_FIELDS_WIDTH].(int64)
+		imageExport.Height, _ = image[types.FIREBASE_IMAGES_FIELDS_HEIGHT].(int64)
+
+		if _, storagePath := getPostImagePublicFile(media); storagePath != "" {
+			imageExport.File = post.Id + "/images/" + imageId + path.Ext(storagePath)
+			files = append(files, postsExportFile{name: imageExport.File, storagePath: storagePath})
+		}
+
+		if originals && media.storagePath != "" {
+			imageExport.OriginalFile = post.Id + "/originals/" + imageId + path.Ext(media.storagePath)
+			files = append(files, postsExportFile{name: imageExport.OriginalFile, storagePath: media.storagePath})
+		}
+
+		faces, err := getExportDocuments(c, db, types.FIREBASE_FACES_COLLECTION, media.facesIds)
+		if err != nil {
+			return postExport, nil, err
+		}
+
+		for _, face := range faces {
+			vertices, err := tools.ConvertFirestoreVertices(face.Data()[types.FIREBASE_FACES_FIELDS_VERTICES])
+			if err != nil {
+				return postExport, nil, err
+			}
+
+			imageExport.Faces = append(imageExport.Faces, types.FaceVertices{
+				Id:       face.Ref.ID,
+				ImageId:  imageId,
+				Vertices: vertices,
+			})
+		}
+
+		regions, err := getExportDocuments(c, db, types.FIREBASE_REGIONS_COLLECTION, media.regionsIds)
+		if err != nil {
+			return postExport, nil, err
+		}
+
+		for _, region := range regions {
+			vertices, err := tools.ConvertFirestoreVertices(region.Data()[types.FIREBASE_REGIONS_FIELDS_VERTICES])
+			if err != nil {
+				return postExport, nil, err
+			}
+
+			regionExport := types.PostExportRegion{
+				Id:       region.Ref.ID,
+				Vertices: vertices,
+			}
+			regionExport.Type, _ = region.Data()[types.FIREBASE_REGIONS_FIELDS_TYPE].(string)
+
+			imageExport.Regions = append(imageExport.Regions, regionExport)
+		}
+
+		postExport.Images = append(postExport.Images, imageExport)
+	}
+
+	return postExport, files, nil
+}
+
+// Reads documents from their ids, documents which no longer exist are skipped
+func getExportDocuments(c *gin.Context, db *firestore.Client, collection string, ids []string) ([]*firestore.DocumentSnapshot, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	refs := make([]*firestore.DocumentRef, len(ids))
+	for i, id := range ids {
+		refs[i] = db.Collection(collection).Doc(id)
+	}
+
+	docs, err := db.GetAll(c, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := []*firestore.DocumentSnapshot{}
+	for _, doc := range docs {
+		if doc.Exists() {
+			existing = append(existing, doc)
+		}
+	}
+
+	return existing, nil
+}
+
+// Writes the manifest and copies the files from storage, images are already compressed so they are stored as they are
+func writePostsExport(c *gin.Context, archive *zip.Writer, storage *storage.Client, manifest []byte, files []postsExportFile) error {
+	writer, err := archive.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(manifest)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		writer, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Store,
+			Modified: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+
+		reader, err := storage.Bucket(types.FIREBASE_STORAGE_BUCKET).Object(file.storagePath).NewReader(c)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(writer, reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
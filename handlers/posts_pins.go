@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"proteggo_api/tools"
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// Pins a post above the other posts of the listings, until the optional until time. Posts with a higher weight are
+// listed first, pinning a pinned post again changes its weight and expiry. The creation time of the post is untouched.
+func PinPostHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+
+		var pinnedUntil interface{}
+		if untilValue := c.PostForm("until"); untilValue != "" {
+			until, err := time.Parse(time.RFC3339, untilValue)
+			if err != nil {
+				tools.LogError(logger, c, errors.New("until must be an RFC3339 time"))
+				return
+			}
+
+			if !until.After(time.Now()) {
+				tools.LogError(logger, c, errors.New("until must be in the future"))
+				return
+			}
+
+			pinnedUntil = until
+		}
+
+		weight, err := strconv.ParseInt(c.DefaultPostForm("weight", "0"), 10, 64)
+		if err != nil {
+			tools.LogError(logger, c, errors.New("weight must be an integer"))
+			return
+		}
+
+		err = db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+			data, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_POSTS_COLLECTION, id)
+			if err != nil {
+				return err
+			}
+
+			if data == nil || tools.IsDocumentInTrash(data) {
+				return errors.New("post " + id + " does not exist")
+			}
+
+			// Expired pins and the pins of posts in the trash or not published do not count towards the limit
+			pinnedDocs, err := tx.Documents(db.Collection(types.FIREBASE_POSTS_COLLECTION).Where(types.FIREBASE_POSTS_FIELDS_PINNED, "==", true)).GetAll()
+			if err != nil {
+				return err
+			}
+
+			pinnedCount := 0
+			for _, doc := range pinnedDocs {
+				if doc.Ref.ID == id || tools.IsDocumentInTrash(doc.Data()) {
+					continue
+				}
+
+				post := convertDocumentToPost(doc.Data())
+				if post.Pinned && post.Status == types.POST_STATUS_PUBLISHED {
+					pinnedCount++
+				}
+			}
+
+			if pinnedCount >= types.POSTS_MAX_PINNED {
+				return fmt.Errorf("at most %d posts can be pinned", types.POSTS_MAX_PINNED)
+			}
+
+			return tx.Update(db.Collection(types.FIREBASE_POSTS_COLLECTION).Doc(id), []firestore.Update{
+				{Path: types.FIREBASE_POSTS_FIELDS_PINNED, Value: true},
+				{Path: types.FIREBASE_POSTS_FIELDS_PINNED_AT, Value: firestore.ServerTimestamp},
+				{Path: types.FIREBASE_POSTS_FIELDS_PINNED_UNTIL, Value: pinnedUntil},
+				{Path: types.FIREBASE_POSTS_FIELDS_PIN_WEIGHT, Value: weight},
+			})
+		})
+
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "ok",
+		})
+	}
+}
+
+// Unpins a post, it is listed again at the place of its creation time
+func UnpinPostHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+
+		err := db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+			data, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_POSTS_COLLECTION, id)
+			if err != nil {
+				return err
+			}
+
+			if data == nil {
+				return errors.New("post " + id + " does not exist")
+			}
+
+			return tx.Update(db.Collection(types.FIREBASE_POSTS_COLLECTION).Doc(id), []firestore.Update{
+				{Path: types.FIREBASE_POSTS_FIELDS_PINNED, Value: false},
+				{Path: types.FIREBASE_POSTS_FIELDS_PINNED_AT, Value: nil},
+				{Path: types.FIREBASE_POSTS_FIELDS_PINNED_UNTIL, Value: nil},
+				{Path: types.FIREBASE_POSTS_FIELDS_PIN_WEIGHT, Value: 0},
+			})
+		})
+
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "ok",
+		})
+	}
+}
+
+// Gets the pinned posts of a listing, by weight then the latest pinned first. The query is the one of the listing,
+// the posts Firestore can not filter are checked with the match function. Pins are few, so they are all read at once.
+func getPinnedPosts(c context.Context, query firestore.Query, match func(post types.Post) bool) ([]types.Post, error) {
+	docs, err := query.Where(types.FIREBASE_POSTS_FIELDS_PINNED, "==", true).Documents(c).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	posts := []types.Post{}
+	for _, doc := range docs {
+		post := convertDocumentToPost(doc.Data())
+		if post.Pinned && (match == nil || match(post)) {
+			posts = append(posts, post)
+		}
+	}
+
+	sort.SliceStable(posts, func(i, j int) bool {
+		if posts[i].PinWeight != posts[j].PinWeight {
+			return posts[i].PinWeight > posts[j].PinWeight
+		}
+
+		return posts[i].PinnedAt > posts[j].PinnedAt
+	})
+
+	return posts, nil
+}
+
+// Leaves the pinned posts out of the pages of a listing, as they are listed above it
+func excludePinnedPosts(pinnedPosts []types.Post, match func(post types.Post) bool) func(post types.Post) bool {
+	if len(pinnedPosts) == 0 {
+		return match
+	}
+
+	pinnedIds := map[string]bool{}
+	for _, post := range pinnedPosts {
+		pinnedIds[post.Id] = true
+	}
+
+	return func(post types.Post) bool {
+		return !pinnedIds[post.Id] && (match == nil || match(post))
+	}
+}
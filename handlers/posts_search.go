@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"proteggo_api/search"
+	"proteggo_api/tools"
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// Searches the body and hash tags of the posts, newest first, optionally filtered by hash tags and publication dates
+func SearchPostsHandler(logger *logging.Logger, db *firestore.Client, postsIndex *search.PostsIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cursor := c.Query("cursor")
+		pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		if pageSize < 1 {
+			tools.LogError(logger, c, errors.New("pageSize must be greater than 0"))
+			return
+		}
+
+		// Larger pages are cut down like the other posts pages
+		pageSize = min(pageSize, types.POSTS_PAGE_MAX_SIZE)
+
+		postsSearch := search.PostsSearch{
+			Text: c.Query("q"),
+			Size: pageSize,
+		}
+
+		if hashTags := c.Query("hashTags"); hashTags != "" {
+			postsSearch.HashTags = removeEmptyStrings(strings.Split(hashTags, ","))
+		}
+
+		// Non admins only find the published posts listed to every user, admins can filter by status
+		postsSearch.Status = types.POST_STATUS_PUBLISHED
+		postsSearch.Visibilities = getListedPostVisibilities()
+		if tools.IsAdminUser(c) {
+			postsSearch.Visibilities = nil
+			postsSearch.Status = c.Query(types.FIREBASE_POSTS_FIELDS_STATUS)
+			if postsSearch.Status != "" && !isPostStatus(postsSearch.Status) {
+				tools.LogError(logger, c, errors.New("status must be one of draft, scheduled, published or archived"))
+				return
+			}
+		}
+
+		if startDateStr, ok := c.GetQuery("startDate"); ok {
+			startDate, err := time.Parse(time.RFC3339, startDateStr)
+			if err != nil {
+				tools.LogError(logger, c, err)
+				return
+			}
+			postsSearch.StartDate = &startDate
+		}
+
+		if endDateStr, ok := c.GetQuery("endDate"); ok {
+			endDate, err := time.Parse(time.RFC3339, endDateStr)
+			if err != nil {
+				tools.LogError(logger, c, err)
+				return
+			}
+			postsSearch.EndDate = &endDate
+		}
+
+		direction := types.POSTS_CURSOR_DIRECTION_NEXT
+		if cursor != "" {
+			postsCursor, err := tools.DecodePostsCursor(cursor)
+			if err != nil {
+				tools.LogError(logger, c, err)
+				return
+			}
+
+			direction = postsCursor.Direction
+			postsSearch.Cursor = &postsCursor
+		}
+
+		hits, total, err := postsIndex.Search(postsSearch)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		// The extra hit is the farthest from the cursor, which is the first one when going back
+		hasMore := len(hits) > pageSize
+		if hasMore && direction == types.POSTS_CURSOR_DIRECTION_PREV {
+			hits = hits[1:]
+		} else if hasMore {
+			hits = hits[:pageSize]
+		}
+
+		// The posts are read from Firestore, the index only knows their text
+		posts := []types.Post{}
+		if len(hits) > 0 {
+			refs := make([]*firestore.DocumentRef, len(hits))
+			for i, hit := range hits {
+				refs[i] = db.Collection(types.FIREBASE_POSTS_COLLECTION).Doc(hit.Id)
+			}
+
+			docs, err := db.GetAll(c, refs)
+			if err != nil {
+				tools.LogError(logger, c, err)
+				return
+			}
+
+			// Posts changed since they were indexed are checked again
+			for _, doc := range docs {
+				if !doc.Exists() {
+					continue
+				}
+
+				post := convertDocumentToPost(doc.Data())
+				if canListPost(c, post) {
+					posts = append(posts, post)
+				}
+			}
+		}
+
+		err = addPostsReactions(c, db, posts)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		nextCursor := ""
+		prevCursor := ""
+		if len(hits) > 0 {
+			hasNext := hasMore || direction == types.POSTS_CURSOR_DIRECTION_PREV
+			hasPrev := (hasMore && direction == types.POSTS_CURSOR_DIRECTION_PREV) || (cursor != "" && direction == types.POSTS_CURSOR_DIRECTION_NEXT)
+
+			if hasNext {
+				last := hits[len(hits)-1]
+				nextCursor, err = tools.EncodePostsCursor(last.PublishedAt, last.Id, types.POSTS_CURSOR_DIRECTION_NEXT)
+				if err != nil {
+					tools.LogError(logger, c, err)
+					return
+				}
+			}
+
+			if hasPrev {
+				first := hits[0]
+				prevCursor, err = tools.EncodePostsCursor(first.PublishedAt, first.Id, types.POSTS_CURSOR_DIRECTION_PREV)
+				if err != nil {
+					tools.LogError(logger, c, err)
+					return
+				}
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"posts":      posts,
+			"total":      total,
+			"nextCursor": nextCursor,
+			"prevCursor": prevCursor,
+		})
+	}
+}
+
+// Rebuilds the posts search index of the instance from Firestore
+func ReindexPostsHandler(logger *logging.Logger, db *firestore.Client, postsIndex *search.PostsIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		indexed, err := postsIndex.Rebuild(c, db)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"indexed": indexed,
+		})
+	}
+}
+
+// Updates changed posts in the search index, a failure only leaves the index stale until it is rebuilt
+func syncPostsIndex(c *gin.Context, logger *logging.Logger, db *firestore.Client, postsIndex *search.PostsIndex, ids ...string) {
+	err := postsIndex.Sync(c, db, ids...)
+	if err != nil {
+		logger.Log(logging.Entry{
+			Severity: logging.Warning,
+			Payload:  "Posts search index is stale until it is rebuilt: " + err.Error(),
+			Labels:   map[string]string{"status": "warning"},
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"proteggo_api/tools"
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// Page of a post shown by chat apps and browsers, the metadata only point to obscured images
+var postPageTemplate = template.Must(template.New("post").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<meta name="description" content="{{.Description}}">
+{{- if .NoIndex}}
+<meta name="robots" content="noindex, nofollow">
+{{- end}}
+<meta property="og:site_name" content="{{.SiteName}}">
+<meta property="og:type" content="article">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:url" content="{{.Url}}">
+<meta property="article:published_time" content="{{.Post.PublishedAt}}">
+{{- range .Post.HashTagsValues}}
+<meta property="article:tag" content="{{.}}">
+{{- end}}
+{{- if .Cover}}
+<meta property="og:image" content="{{.Cover.Url}}">
+<meta property="og:image:type" content="{{.Cover.Type}}">
+{{- if .Cover.Width}}
+<meta property="og:image:width" content="{{.Cover.Width}}">
+<meta property="og:image:height" content="{{.Cover.Height}}">
+{{- end}}
+<meta name="twitter:card" content="summary_large_image">
+<meta name="twitter:image" content="{{.Cover.Url}}">
+{{- else}}
+<meta name="twitter:card" content="summary">
+{{- end}}
+<meta name="twitter:title" content="{{.Title}}">
+<meta name="twitter:description" content="{{.Description}}">
+<link rel="alternate" type="application/json+oembed" href="{{.OEmbedUrl}}" title="{{.Title}}">
+</head>
+<body>
+<article>
+<p style="white-space: pre-wrap">{{.Post.Body}}</p>
+{{- range .Post.ImagesUrls}}
+<img src="{{.}}" alt="" style="max-width: 100%">
+{{- end}}
+<p>
+{{- range .Post.HashTagsValues}} #{{.}}{{end}}
+</p>
+</article>
+</body>
+</html>
+`))
+
+// Obscured image shown in the previews of a post
+type postCover struct {
+	Url    string
+	Type   string
+	Width  int64
+	Height int64
+}
+
+type postPage struct {
+	SiteName    string
+	Title       string
+	Description string
+	Url         string
+	OEmbedUrl   string
+	NoIndex     bool
+	Post        types.PublicPost
+	Cover       *postCover
+}
+
+// oEmbed response, posts with an obscured image are photos and the others links
+type oEmbedResponse struct {
+	Version         string `json:"version"`
+	Type            string `json:"type"`
+	Title           string `json:"title"`
+	ProviderName    string `json:"provider_name"`
+	ProviderUrl     string `json:"provider_url"`
+	CacheAge        int    `json:"cache_age,omitempty"`
+	Url             string `json:"url,omitempty"`
+	Width           int64  `json:"width,omitempty"`
+	Height          int64  `json:"height,omitempty"`
+	ThumbnailUrl    string `json:"thumbnail_url,omitempty"`
+	ThumbnailWidth  int64  `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight int64  `json:"thumbnail_height,omitempty"`
+}
+
+// Serves the page of a public post with its Open Graph and Twitter card metadata
+func GetPublicPostPageHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+
+		post, found, err := getPublicPost(c, db, id)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		if !found {
+			c.String(http.StatusNotFound, "post "+id+" does not exist")
+			return
+		}
+
+		baseUrl, err := tools.GetPublicBaseUrl()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.Header("Cache-Control", "public, max-age="+strconv.Itoa(types.FEEDS_CACHE_MAX_AGE_SECONDS))
+		writePostPage(c, logger, db, post, baseUrl, getPublicPostUrl(baseUrl, id), false)
+	}
+}
+
+// Serves the page of the post of a share link with its Open Graph and Twitter card metadata, each view is counted
+// on the link like the other accesses
+func GetSharedPostPageHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Param("token")
+
+		shareLink, post, err := getSharedPost(c, db, token)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		if shareLink == nil {
+			c.String(http.StatusNotFound, "share link does not exist or expired")
+			return
+		}
+
+		baseUrl, err := tools.GetPublicBaseUrl()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		countShareLinkAccess(c, logger, db, shareLink.Id)
+
+		// Shared posts are not meant to be found by search engines
+		c.Header("Cache-Control", "private, no-store")
+		writePostPage(c, logger, db, post, baseUrl, getSharedPostUrl(baseUrl, token), true)
+	}
+}
+
+// Answers oEmbed requests for the page of a public or shared post, only the json format is supported
+func GetOEmbedHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if format := c.DefaultQuery("format", "json"); format != "json" {
+			c.JSON(http.StatusNotImplemented, gin.H{
+				"error": "format must be json",
+			})
+			return
+		}
+
+		maxWidth, maxHeight, err := parseOEmbedMaxSize(c)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		baseUrl, err := tools.GetPublicBaseUrl()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		// Only the pages of this API are described
+		pageUrl, err := url.Parse(c.Query("url"))
+		if err != nil || pageUrl.Scheme+"://"+pageUrl.Host != baseUrl {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "url is not the page of a post",
+			})
+			return
+		}
+
+		var post types.Post
+		found := false
+		cacheAge := 0
+
+		path := strings.TrimSuffix(pageUrl.Path, "/page")
+		if id, ok := strings.CutPrefix(path, "/api/public/posts/"); ok && id != "" && !strings.Contains(id, "/") {
+			post, found, err = getPublicPost(c, db, id)
+			cacheAge = types.FEEDS_CACHE_MAX_AGE_SECONDS
+		} else if token, ok := strings.CutPrefix(path, "/api/shared/"); ok && token != "" && !strings.Contains(token, "/") {
+			var shareLink *types.ShareLink
+			shareLink, post, err = getSharedPost(c, db, token)
+			found = shareLink != nil
+		}
+
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "url is not the page of a post",
+			})
+			return
+		}
+
+		publicPost := convertPostToPublicPost(post)
+		cover, err := getPostCover(c, db, publicPost)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		response := oEmbedResponse{
+			Version:      types.OEMBED_VERSION,
+			Type:         "link",
+			Title:        getFeedItemTitle(publicPost),
+			ProviderName: types.FEEDS_TITLE,
+			ProviderUrl:  baseUrl,
+			CacheAge:     cacheAge,
+		}
+
+		// The image is not resized, its size is scaled down to fit the maximum size asked by the consumer
+		if cover != nil && cover.Width > 0 && cover.Height > 0 {
+			width, height := fitOEmbedSize(cover.Width, cover.Height, maxWidth, maxHeight)
+
+			response.Type = "photo"
+			response.Url = cover.Url
+			response.Width = width
+			response.Height = height
+			response.ThumbnailUrl = cover.Url
+			response.ThumbnailWidth = width
+			response.ThumbnailHeight = height
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+func writePostPage(c *gin.Context, logger *logging.Logger, db *firestore.Client, post types.Post, baseUrl string, pageUrl string, noIndex bool) {
+	publicPost := convertPostToPublicPost(post)
+
+	cover, err := getPostCover(c, db, publicPost)
+	if err != nil {
+		tools.LogError(logger, c, err)
+		return
+	}
+
+	page := postPage{
+		SiteName:    types.FEEDS_TITLE,
+		Title:       getFeedItemTitle(publicPost),
+		Description: getPostPageDescription(publicPost),
+		Url:         pageUrl,
+		OEmbedUrl:   baseUrl + "/api/public/oembed?format=json&url=" + url.QueryEscape(pageUrl),
+		NoIndex:     noIndex,
+		Post:        publicPost,
+		Cover:       cover,
+	}
+
+	var html bytes.Buffer
+	err = postPageTemplate.Execute(&html, page)
+	if err != nil {
+		tools.LogError(logger, c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "text/html; charset=utf-8", html.Bytes())
+}
+
+// Gets the first image of a post as it is served outside the app with its size, nil when the post has no such image.
+// The cover is the obscured rendition of the image, or the original when it has no faces or text regions, never the
+// overlay alone.
+func getPostCover(c context.Context, db *firestore.Client, post types.PublicPost) (*postCover, error) {
+	if len(post.ImagesIds) == 0 {
+		return nil, nil
+	}
+
+	cover := &postCover{
+		Url:  post.ImagesUrls[0],
+		Type: post.ImagesTypes[0],
+	}
+
+	// The obscured rendition is drawn at the size of the original
+	image, err := tools.GetFirestoreDocument(c, db, types.FIREBASE_IMAGES_COLLECTION, post.ImagesIds[0])
+	if err != nil {
+		return nil, err
+	}
+
+	cover.Width, _ = image[types.FIREBASE_IMAGES_FIELDS_WIDTH].(int64)
+	cover.Height, _ = image[types.FIREBASE_IMAGES_FIELDS_HEIGHT].(int64)
+
+	return cover, nil
+}
+
+// Returns the url of the page of a shared post
+func getSharedPostUrl(baseUrl string, token string) string {
+	return baseUrl + "/api/shared/" + url.PathEscape(token) + "/page"
+}
+
+// The description is the body on a single line, shortened for the previews
+func getPostPageDescription(post types.PublicPost) string {
+	description := strings.Join(strings.Fields(post.Body), " ")
+
+	if utf8.RuneCountInString(description) > types.PREVIEWS_DESCRIPTION_MAX_LENGTH {
+		description = string([]rune(description)[:types.PREVIEWS_DESCRIPTION_MAX_LENGTH-1]) + "…"
+	}
+
+	return description
+}
+
+func parseOEmbedMaxSize(c *gin.Context) (int64, int64, error) {
+	sizes := []int64{0, 0}
+
+	for i, name := range []string{"maxwidth", "maxheight"} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size < 1 {
+			return 0, 0, errors.New(name + " must be a positive number")
+		}
+
+		sizes[i] = size
+	}
+
+	return sizes[0], sizes[1], nil
+}
+
+// Scales a size down to fit the maximum width and height keeping its ratio, a zero maximum is not applied
+func fitOEmbedSize(width int64, height int64, maxWidth int64, maxHeight int64) (int64, int64) {
+	if maxWidth > 0 && width > maxWidth {
+		height = height * maxWidth / width
+		width = maxWidth
+	}
+
+	if maxHeight > 0 && height > maxHeight {
+		width = width * maxHeight / height
+		height = maxHeight
+	}
+
+	return max(width, 1), max(height, 1)
+}
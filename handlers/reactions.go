@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"proteggo_api/tools"
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// Gets the reaction counts of a post and the reactions of the user
+func GetPostReactionsHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		postId := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+
+		post, err := tools.GetFirestoreDocument(c, db, types.FIREBASE_POSTS_COLLECTION, postId)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		if post == nil || !canReadPost(c, convertDocumentToPost(post)) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "post " + postId + " does not exist",
+			})
+			return
+		}
+
+		reactions, err := getPostReactions(c, db, postId)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"reactions": reactions,
+		})
+	}
+}
+
+// Reacts to a post the user can read, reacting twice with the same type has no effect
+func AddReactionHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		postId := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+		reactionType := c.Param(types.FIREBASE_REACTIONS_FIELDS_TYPE)
+		uid := getPostEditor(c).uid
+
+		settings, err := tools.GetReactionsSettings(c, db)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		if indexOfString(settings.Types(), reactionType) < 0 {
+			tools.LogError(logger, c, errors.New("type must be one of "+strings.Join(settings.Types(), ", ")))
+			return
+		}
+
+		reactionId := tools.GetReactionId(postId, uid, reactionType)
+
+		err = db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+			post, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_POSTS_COLLECTION, postId)
+			if err != nil {
+				return err
+			}
+
+			if post == nil || !canReadPost(c, convertDocumentToPost(post)) {
+				return errors.New("post " + postId + " does not exist")
+			}
+
+			reaction, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_REACTIONS_COLLECTION, reactionId)
+			if err != nil {
+				return err
+			}
+
+			if reaction != nil {
+				return nil
+			}
+
+			err = tx.Create(db.Collection(types.FIREBASE_REACTIONS_COLLECTION).Doc(reactionId), map[string]interface{}{
+				types.FIREBASE_REACTIONS_FIELDS_ID:         reactionId,
+				types.FIREBASE_REACTIONS_FIELDS_POST_ID:    postId,
+				types.FIREBASE_REACTIONS_FIELDS_UID:        uid,
+				types.FIREBASE_REACTIONS_FIELDS_TYPE:       reactionType,
+				types.FIREBASE_REACTIONS_FIELDS_CREATED_AT: firestore.ServerTimestamp,
+			})
+			if err != nil {
+				return err
+			}
+
+			return changeReactionsCount(tx, db, postId, reactionType, 1)
+		})
+
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		respondWithPostReactions(c, logger, db, postId)
+	}
+}
+
+// Removes a reaction of the user from a post, removing a reaction the user did not add has no effect
+func RemoveReactionHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		postId := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+		reactionType := c.Param(types.FIREBASE_REACTIONS_FIELDS_TYPE)
+		reactionId := tools.GetReactionId(postId, getPostEditor(c).uid, reactionType)
+
+		// Reactions with an emoji removed from the settings can still be removed
+		err := db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+			reaction, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_REACTIONS_COLLECTION, reactionId)
+			if err != nil {
+				return err
+			}
+
+			if reaction == nil {
+				return nil
+			}
+
+			post, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_POSTS_COLLECTION, postId)
+			if err != nil {
+				return err
+			}
+
+			err = tx.Delete(db.Collection(types.FIREBASE_REACTIONS_COLLECTION).Doc(reactionId))
+			if err != nil {
+				return err
+			}
+
+			// The counters of a purged post are gone, writing to a shard would create it again
+			if post == nil {
+				return nil
+			}
+
+			return changeReactionsCount(tx, db, postId, reactionType, -1)
+		})
+
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		respondWithPostReactions(c, logger, db, postId)
+	}
+}
+
+func GetReactionsSettingsHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settings, err := tools.GetReactionsSettings(c, db)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"settings": settings,
+			"types":    settings.Types(),
+		})
+	}
+}
+
+// Sets the emojis users can react with, given as a comma separated list. Counts of removed emojis are kept but no
+// longer returned.
+func SetReactionsSettingsHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		emojis := removeEmptyStrings(strings.Split(c.PostForm(types.FIREBASE_REACTIONS_SETTINGS_FIELDS_EMOJIS), ","))
+
+		if len(emojis) > types.REACTIONS_MAX_EMOJIS {
+			tools.LogError(logger, c, errors.New("too many emojis"))
+			return
+		}
+
+		for i, emoji := range emojis {
+			if !isReactionEmoji(emoji) {
+				tools.LogError(logger, c, errors.New("invalid emoji "+emoji))
+				return
+			}
+
+			if indexOfString(emojis[:i], emoji) >= 0 {
+				tools.LogError(logger, c, errors.New("duplicate emoji "+emoji))
+				return
+			}
+		}
+
+		err := tools.SetFirestoreDocument(c, db, types.FIREBASE_SETTINGS_COLLECTION, types.FIREBASE_SETTINGS_REACTIONS_DOCUMENT, map[string]interface{}{
+			types.FIREBASE_REACTIONS_SETTINGS_FIELDS_EMOJIS: emojis,
+		})
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		settings := types.ReactionsSettings{Emojis: emojis}
+		c.JSON(http.StatusOK, gin.H{
+			"settings": settings,
+			"types":    settings.Types(),
+		})
+	}
+}
+
+func respondWithPostReactions(c *gin.Context, logger *logging.Logger, db *firestore.Client, postId string) {
+	reactions, err := getPostReactions(c, db, postId)
+	if err != nil {
+		tools.LogError(logger, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reactions": reactions,
+	})
+}
+
+// Changes the count of a reaction type in a random shard of the post counters, shards can go below zero but their sum can
+// not. The change time lets the roll up find the shard.
+func changeReactionsCount(tx *firestore.Transaction, db *firestore.Client, postId string, reactionType string, delta int) error {
+	shardId := tools.GetReactionsShardId(postId, rand.Intn(types.REACTIONS_SHARDS_COUNT))
+
+	return tx.Set(db.Collection(types.FIREBASE_REACTIONS_SHARDS_COLLECTION).Doc(shardId), map[string]interface{}{
+		types.FIREBASE_REACTIONS_SHARDS_FIELDS_POST_ID: postId,
+		types.FIREBASE_REACTIONS_SHARDS_FIELDS_COUNTS: map[string]interface{}{
+			reactionType: firestore.Increment(delta),
+		},
+		types.FIREBASE_REACTIONS_SHARDS_FIELDS_UPDATED_AT: firestore.ServerTimestamp,
+	}, firestore.MergeAll)
+}
+
+// Adds the reaction counts rolled up on the posts and the reactions of the user to the posts of a listing, the shards
+// are only summed when a single post is read
+func addPostsReactions(c *gin.Context, db *firestore.Client, posts []types.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	settings, err := tools.GetReactionsSettings(c, db)
+	if err != nil {
+		return err
+	}
+
+	postsIds := make([]string, len(posts))
+	for i, post := range posts {
+		postsIds[i] = post.Id
+	}
+
+	userReactions, err := getUserReactions(c, db, postsIds, settings.Types())
+	if err != nil {
+		return err
+	}
+
+	for i, post := range posts {
+		counts := map[string]int64{}
+		for _, reactionType := range settings.Types() {
+			if count, ok := post.ReactionsCounts[reactionType]; ok {
+				counts[reactionType] = count
+			}
+		}
+
+		posts[i].ReactionsCounts = counts
+		posts[i].Reactions = userReactions[post.Id]
+	}
+
+	return nil
+}
+
+// Sums the counter shards of a post and reads the reactions of the user, only the reaction types of the settings are
+// returned
+func getPostReactions(c *gin.Context, db *firestore.Client, postId string) (types.PostReactions, error) {
+	reactions := types.PostReactions{
+		Counts:    map[string]int64{},
+		Reactions: []string{},
+	}
+
+	settings, err := tools.GetReactionsSettings(c, db)
+	if err != nil {
+		return reactions, err
+	}
+
+	reactionTypes := settings.Types()
+	uid := getPostEditor(c).uid
+
+	// The shards and reactions have known ids, they are all read at once
+	refs := []*firestore.DocumentRef{}
+	for shard := 0; shard < types.REACTIONS_SHARDS_COUNT; shard++ {
+		refs = append(refs, db.Collection(types.FIREBASE_REACTIONS_SHARDS_COLLECTION).Doc(tools.GetReactionsShardId(postId, shard)))
+	}
+
+	if uid != "" {
+		for _, reactionType := range reactionTypes {
+			refs = append(refs, db.Collection(types.FIREBASE_REACTIONS_COLLECTION).Doc(tools.GetReactionId(postId, uid, reactionType)))
+		}
+	}
+
+	docs, err := db.GetAll(c, refs)
+	if err != nil {
+		return reactions, err
+	}
+
+	for _, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+
+		data := doc.Data()
+		if doc.Ref.Parent.ID == types.FIREBASE_REACTIONS_COLLECTION {
+			reactionType, _ := data[types.FIREBASE_REACTIONS_FIELDS_TYPE].(string)
+			reactions.Reactions = append(reactions.Reactions, reactionType)
+			continue
+		}
+
+		counts, _ := data[types.FIREBASE_REACTIONS_SHARDS_FIELDS_COUNTS].(map[string]interface{})
+		for _, reactionType := range reactionTypes {
+			if count, ok := counts[reactionType].(int64); ok {
+				reactions.Counts[reactionType] += count
+			}
+		}
+	}
+
+	return reactions, nil
+}
+
+// Reads the reactions of the user to the posts by post id, with one query for every batch of posts Firestore allows in
+// a single in condition
+func getUserReactions(c *gin.Context, db *firestore.Client, postsIds []string, reactionTypes []string) (map[string][]string, error) {
+	reactions := map[string][]string{}
+	for _, postId := range postsIds {
+		reactions[postId] = []string{}
+	}
+
+	uid := getPostEditor(c).uid
+	if uid == "" {
+		return reactions, nil
+	}
+
+	for start := 0; start < len(postsIds); start += types.FIRESTORE_IN_MAX_VALUES {
+		end := min(start+types.FIRESTORE_IN_MAX_VALUES, len(postsIds))
+
+		docs, err := db.Collection(types.FIREBASE_REACTIONS_COLLECTION).
+			Where(types.FIREBASE_REACTIONS_FIELDS_UID, "==", uid).
+			Where(types.FIREBASE_REACTIONS_FIELDS_POST_ID, "in", postsIds[start:end]).
+			Documents(c).GetAll()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, doc := range docs {
+			postId, _ := doc.Data()[types.FIREBASE_REACTIONS_FIELDS_POST_ID].(string)
+			reactionType, _ := doc.Data()[types.FIREBASE_REACTIONS_FIELDS_TYPE].(string)
+
+			// Reactions with an emoji removed from the settings are not returned
+			if _, ok := reactions[postId]; ok && indexOfString(reactionTypes, reactionType) >= 0 {
+				reactions[postId] = append(reactions[postId], reactionType)
+			}
+		}
+	}
+
+	return reactions, nil
+}
+
+// Emojis are short and can not contain spaces, commas or slashes since they are part of the reactions ids
+func isReactionEmoji(emoji string) bool {
+	if emoji == types.REACTION_TYPE_LIKE || len(emoji) > types.REACTION_EMOJI_MAX_LENGTH || strings.HasPrefix(emoji, "__") {
+		return false
+	}
+
+	for _, r := range emoji {
+		if unicode.IsSpace(r) || r == ',' || r == '/' {
+			return false
+		}
+	}
+
+	return true
+}
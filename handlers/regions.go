@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"proteggo_api/tools"
+	"proteggo_api/types"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/api/iterator"
+)
+
+func GetRegionsHandler(logger *logging.Logger, firestoreClient *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var regions []types.Region
+
+		// Get Image ID
+		imageId, imageIdProvided := c.GetQuery("imageId")
+		if !imageIdProvided {
+			tools.LogError(logger, c, errors.New("Image ID not provided"))
+			return
+		}
+
+		query := firestoreClient.Collection(types.FIREBASE_REGIONS_COLLECTION).Where(types.FIREBASE_REGIONS_FIELDS_IMAGE_ID, "==", imageId)
+
+		iter := query.Documents(c)
+		defer iter.Stop()
+
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+
+			if err != nil {
+				tools.LogError(logger, c, err)
+				return
+			}
+
+			vertices, err := tools.ConvertFirestoreVertices(doc.Data()[types.FIREBASE_REGIONS_FIELDS_VERTICES])
+			if err != nil {
+				tools.LogError(logger, c, err)
+				return
+			}
+
+			createdAt := ""
+			if t, ok := doc.Data()[types.FIREBASE_REGIONS_FIELDS_CREATED_AT].(time.Time); ok {
+				createdAt = t.Format(time.RFC3339)
+			}
+
+			regions = append(regions, types.Region{
+				Id:        doc.Data()[types.FIREBASE_REGIONS_FIELDS_ID].(string),
+				Type:      doc.Data()[types.FIREBASE_REGIONS_FIELDS_TYPE].(string),
+				Text:      doc.Data()[types.FIREBASE_REGIONS_FIELDS_TEXT].(string),
+				Vertices:  vertices,
+				ImageId:   imageId,
+				CreatedAt: createdAt,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"regions": regions,
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"proteggo_api/tools"
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// Creates a link to a published post which can be opened without signing in until it expires, expiresIn is in hours
+func CreateShareLinkHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		postId := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+
+		expiresIn, err := strconv.Atoi(c.DefaultPostForm("expiresIn", strconv.Itoa(types.SHARE_LINKS_DEFAULT_EXPIRY_HOURS)))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		if expiresIn < 1 || expiresIn > types.SHARE_LINKS_MAX_EXPIRY_HOURS {
+			tools.LogError(logger, c, errors.New("expiresIn must be between 1 and "+strconv.Itoa(types.SHARE_LINKS_MAX_EXPIRY_HOURS)+" hours"))
+			return
+		}
+
+		err = checkPostEditor(c, db, postId)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		secret, err := tools.GetShareLinksSecret(c, db)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		id, err := tools.GenerateRandomName()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		// The expiry is signed in seconds
+		createdAt := time.Now()
+		expiresAt := createdAt.Add(time.Duration(expiresIn) * time.Hour).Truncate(time.Second)
+		shareLink := types.ShareLink{
+			Id:          id,
+			PostId:      postId,
+			Token:       tools.SignShareLinkToken(secret, id, expiresAt),
+			CreatedBy:   getPostEditor(c).uid,
+			CreatedAt:   createdAt.Format(time.RFC3339),
+			ExpiresAt:   expiresAt.Format(time.RFC3339),
+			AccessCount: 0,
+		}
+
+		err = db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+			post, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_POSTS_COLLECTION, postId)
+			if err != nil {
+				return err
+			}
+
+			if post == nil || !canSharePost(convertDocumentToPost(post)) {
+				return errors.New("only published posts which are not private can be shared")
+			}
+
+			return tx.Create(db.Collection(types.FIREBASE_SHARE_LINKS_COLLECTION).Doc(id), map[string]interface{}{
+				types.FIREBASE_SHARE_LINKS_FIELDS_ID:               id,
+				types.FIREBASE_SHARE_LINKS_FIELDS_POST_ID:          postId,
+				types.FIREBASE_SHARE_LINKS_FIELDS_CREATED_BY:       shareLink.CreatedBy,
+				types.FIREBASE_SHARE_LINKS_FIELDS_CREATED_AT:       createdAt,
+				types.FIREBASE_SHARE_LINKS_FIELDS_EXPIRES_AT:       expiresAt,
+				types.FIREBASE_SHARE_LINKS_FIELDS_REVOKED_AT:       nil,
+				types.FIREBASE_SHARE_LINKS_FIELDS_ACCESS_COUNT:     0,
+				types.FIREBASE_SHARE_LINKS_FIELDS_LAST_ACCESSED_AT: nil,
+			})
+		})
+
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"shareLink": shareLink,
+		})
+	}
+}
+
+// Gets the share links of a post with their access counts, the newest first
+func GetShareLinksHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		postId := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+
+		err := checkPostEditor(c, db, postId)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		secret, err := tools.GetShareLinksSecret(c, db)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		docs, err := db.Collection(types.FIREBASE_SHARE_LINKS_COLLECTION).
+			Where(types.FIREBASE_SHARE_LINKS_FIELDS_POST_ID, "==", postId).
+			OrderBy(types.FIREBASE_SHARE_LINKS_FIELDS_CREATED_AT, firestore.Desc).
+			Documents(c).GetAll()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		shareLinks := []types.ShareLink{}
+		for _, doc := range docs {
+			shareLinks = append(shareLinks, convertDocumentToShareLink(secret, doc.Data()))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"shareLinks": shareLinks,
+		})
+	}
+}
+
+// Revokes a share link of a post, the link stops working right away
+func RevokeShareLinkHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		postId := c.Param(types.FIREBASE_POSTS_FIELDS_ID)
+		linkId := c.Param("linkId")
+
+		err := checkPostEditor(c, db, postId)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		err = db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+			shareLink, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_SHARE_LINKS_COLLECTION, linkId)
+			if err != nil {
+				return err
+			}
+
+			if shareLink == nil || shareLink[types.FIREBASE_SHARE_LINKS_FIELDS_POST_ID] != postId {
+				return errors.New("share link " + linkId + " does not exist")
+			}
+
+			if _, revoked := shareLink[types.FIREBASE_SHARE_LINKS_FIELDS_REVOKED_AT].(time.Time); revoked {
+				return nil
+			}
+
+			return tx.Update(db.Collection(types.FIREBASE_SHARE_LINKS_COLLECTION).Doc(linkId), []firestore.Update{
+				{Path: types.FIREBASE_SHARE_LINKS_FIELDS_REVOKED_AT, Value: firestore.ServerTimestamp},
+			})
+		})
+
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "ok",
+		})
+	}
+}
+
+// Gets the post of a share link without signing in. Only the body, hash tags and obscured renditions of the images
+// are served, never the originals or the faces. Each access is counted on the link.
+func GetSharedPostHandler(logger *logging.Logger, db *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		shareLink, post, err := getSharedPost(c, db, c.Param("token"))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		if shareLink == nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "share link does not exist or expired",
+			})
+			return
+		}
+
+		countShareLinkAccess(c, logger, db, shareLink.Id)
+
+		// Revoked links must not be served from caches
+		c.Header("Cache-Control", "private, no-store")
+		c.JSON(http.StatusOK, gin.H{
+			"post":      convertPostToPublicPost(post),
+			"expiresAt": shareLink.ExpiresAt,
+		})
+	}
+}
+
+// Gets a share link and its post from a token, the link is nil when the token is invalid or expired, the link was
+// revoked, or the post can no longer be shared
+func getSharedPost(c context.Context, db *firestore.Client, token string) (*types.ShareLink, types.Post, error) {
+	secret, err := tools.GetShareLinksSecret(c, db)
+	if err != nil {
+		return nil, types.Post{}, err
+	}
+
+	linkId, err := tools.VerifyShareLinkToken(secret, token, time.Now())
+	if err != nil {
+		return nil, types.Post{}, nil
+	}
+
+	data, err := tools.GetFirestoreDocument(c, db, types.FIREBASE_SHARE_LINKS_COLLECTION, linkId)
+	if err != nil || data == nil {
+		return nil, types.Post{}, err
+	}
+
+	shareLink := convertDocumentToShareLink(secret, data)
+	if shareLink.RevokedAt != "" {
+		return nil, types.Post{}, nil
+	}
+
+	postData, err := tools.GetFirestoreDocument(c, db, types.FIREBASE_POSTS_COLLECTION, shareLink.PostId)
+	if err != nil || postData == nil {
+		return nil, types.Post{}, err
+	}
+
+	// Posts trashed, unpublished or made private since the link was created are no longer shared
+	post := convertDocumentToPost(postData)
+	if !canSharePost(post) {
+		return nil, types.Post{}, nil
+	}
+
+	return &shareLink, post, nil
+}
+
+// Counts an access to a share link, a failed count does not prevent the access
+func countShareLinkAccess(c context.Context, logger *logging.Logger, db *firestore.Client, linkId string) {
+	_, err := db.Collection(types.FIREBASE_SHARE_LINKS_COLLECTION).Doc(linkId).Update(c, []firestore.Update{
+		{Path: types.FIREBASE_SHARE_LINKS_FIELDS_ACCESS_COUNT, Value: firestore.Increment(1)},
+		{Path: types.FIREBASE_SHARE_LINKS_FIELDS_LAST_ACCESSED_AT, Value: firestore.ServerTimestamp},
+	})
+	if err != nil {
+		logger.Log(logging.Entry{
+			Severity: logging.Warning,
+			Payload:  "Error counting access to share link " + linkId + ": " + err.Error(),
+			Labels:   map[string]string{"status": "warning"},
+		})
+	}
+}
+
+// Converts a share link document data to a ShareLink, the token is not stored but signed again from its id and expiry
+func convertDocumentToShareLink(secret []byte, data map[string]interface{}) types.ShareLink {
+	shareLink := types.ShareLink{}
+	shareLink.Id, _ = data[types.FIREBASE_SHARE_LINKS_FIELDS_ID].(string)
+	shareLink.PostId, _ = data[types.FIREBASE_SHARE_LINKS_FIELDS_POST_ID].(string)
+	shareLink.CreatedBy, _ = data[types.FIREBASE_SHARE_LINKS_FIELDS_CREATED_BY].(string)
+	shareLink.AccessCount, _ = data[types.FIREBASE_SHARE_LINKS_FIELDS_ACCESS_COUNT].(int64)
+
+	if value, ok := data[types.FIREBASE_SHARE_LINKS_FIELDS_CREATED_AT].(time.Time); ok {
+		shareLink.CreatedAt = value.Format(time.RFC3339)
+	}
+
+	if value, ok := data[types.FIREBASE_SHARE_LINKS_FIELDS_EXPIRES_AT].(time.Time); ok {
+		shareLink.ExpiresAt = value.Format(time.RFC3339)
+		shareLink.Token = tools.SignShareLinkToken(secret, shareLink.Id, value)
+	}
+
+	// Revoked links are listed without their token
+	if value, ok := data[types.FIREBASE_SHARE_LINKS_FIELDS_REVOKED_AT].(time.Time); ok {
+		shareLink.RevokedAt = value.Format(time.RFC3339)
+		shareLink.Token = ""
+	}
+
+	if value, ok := data[types.FIREBASE_SHARE_LINKS_FIELDS_LAST_ACCESSED_AT].(time.Time); ok {
+		shareLink.LastAccessedAt = value.Format(time.RFC3339)
+	}
+
+	return shareLink
+}
@@ -0,0 +1,551 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"proteggo_api/search"
+	"proteggo_api/tools"
+	"proteggo_api/types"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/storage"
+	"github.com/gin-gonic/gin"
+)
+
+func GetTrashSettingsHandler(logger *logging.Logger, firestoreClient *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settings, err := tools.GetTrashSettings(c, firestoreClient)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"settings": settings,
+		})
+	}
+}
+
+func SetTrashSettingsHandler(logger *logging.Logger, firestoreClient *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		retentionDays, err := strconv.Atoi(c.PostForm(types.FIREBASE_TRASH_SETTINGS_FIELDS_RETENTION_DAYS))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		if retentionDays < 0 {
+			tools.LogError(logger, c, errors.New("retentionDays can not be negative"))
+			return
+		}
+
+		err = tools.SetFirestoreDocument(c, firestoreClient, types.FIREBASE_SETTINGS_COLLECTION, types.FIREBASE_SETTINGS_TRASH_DOCUMENT, map[string]interface{}{
+			types.FIREBASE_TRASH_SETTINGS_FIELDS_RETENTION_DAYS: retentionDays,
+		})
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"settings": types.TrashSettings{RetentionDays: retentionDays},
+		})
+	}
+}
+
+// Lists the posts in the trash, the most recently deleted first
+func GetTrashedPostsHandler(logger *logging.Logger, firestoreClient *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cursor := c.Query("cursor")
+		pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		query := firestoreClient.Collection(types.FIREBASE_POSTS_COLLECTION).Where(types.FIREBASE_POSTS_FIELDS_DELETED_AT, "!=", nil)
+		docs, nextCursor, prevCursor, err := getDocumentsPageOrderedBy(c, query, types.FIREBASE_POSTS_FIELDS_DELETED_AT, cursor, pageSize, firestore.Desc, nil)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		posts := []types.Post{}
+		for _, doc := range docs {
+			posts = append(posts, convertDocumentToPost(doc.Data()))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"posts":      posts,
+			"nextCursor": nextCursor,
+			"prevCursor": prevCursor,
+		})
+	}
+}
+
+// Lists the images in the trash, the most recently deleted first
+func GetTrashedImagesHandler(logger *logging.Logger, firestoreClient *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cursor := c.Query("cursor")
+		pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		query := firestoreClient.Collection(types.FIREBASE_IMAGES_COLLECTION).Where(types.FIREBASE_IMAGES_FIELDS_DELETED_AT, "!=", nil)
+		docs, nextCursor, prevCursor, err := getDocumentsPageOrderedBy(c, query, types.FIREBASE_IMAGES_FIELDS_DELETED_AT, cursor, pageSize, firestore.Desc, nil)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		images := []types.Image{}
+		for _, doc := range docs {
+			images = append(images, convertDocumentToImage(doc.Ref.ID, doc.Data()))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"images":     images,
+			"nextCursor": nextCursor,
+			"prevCursor": prevCursor,
+		})
+	}
+}
+
+// Takes posts out of the trash, their hash tags count in the scores again. Scheduled posts whose publish time passed in
+// the trash are published right away.
+func RestorePostsHandler(logger *logging.Logger, firestoreClient *firestore.Client, tasksClient *cloudtasks.Client, postsIndex *search.PostsIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		form, err := c.MultipartForm()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		expectedVersions, err := tools.ParseIfMatch(c)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		var restoredIds []string
+		var failedIds []string
+		publishingScheduled := false
+
+		for _, id := range form.Value["postsIds"] {
+			var post map[string]interface{}
+
+			err := firestoreClient.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+				var err error
+				post, err = tools.GetFirestoreDocumentInTransaction(tx, firestoreClient, types.FIREBASE_POSTS_COLLECTION, id)
+				if err != nil {
+					return err
+				}
+
+				if post == nil || !tools.IsDocumentInTrash(post) {
+					return errors.New("post " + id + " is not in the trash")
+				}
+
+				err = tools.CheckDocumentVersion(expectedVersions, id, post)
+				if err != nil {
+					return err
+				}
+
+				// Read the hash tags, all reads have to be done before the writes
+				hashTagsIds := convertInterfaceToArrayString(post[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_IDS])
+				hashTagsValues := convertInterfaceToArrayString(post[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_VALUES])
+				hashTags := map[string]map[string]interface{}{}
+				for _, hashTagId := range hashTagsIds {
+					hashTags[hashTagId], err = tools.GetFirestoreDocumentInTransaction(tx, firestoreClient, types.FIREBASE_POSTS_HASHTAGS_COLLECTION, hashTagId)
+					if err != nil {
+						return err
+					}
+				}
+
+				// Hash tags whose score dropped to zero were deleted, so they are created again from the post values
+				for i, hashTagId := range hashTagsIds {
+					err = changeHashTagScore(tx, firestoreClient, hashTagId, valueAtIndex(hashTagsValues, i), hashTags[hashTagId], 1)
+					if err != nil {
+						return err
+					}
+				}
+
+				return tx.Update(firestoreClient.Collection(types.FIREBASE_POSTS_COLLECTION).Doc(id), []firestore.Update{
+					{Path: types.FIREBASE_POSTS_FIELDS_DELETED_AT, Value: nil},
+					{Path: types.FIREBASE_POSTS_FIELDS_UPDATED_AT, Value: firestore.ServerTimestamp},
+					{Path: types.FIREBASE_POSTS_FIELDS_VERSION, Value: firestore.Increment(1)},
+				})
+			})
+
+			if err != nil {
+				failedIds = append(failedIds, id)
+				logger.Log(logging.Entry{
+					Severity: logging.Error,
+					Payload:  "Error restoring post " + id + ": " + err.Error(),
+					Labels:   map[string]string{"status": "error"},
+				})
+				continue
+			}
+
+			restoredIds = append(restoredIds, id)
+
+			// The task created when the post was scheduled skipped it while it was in the trash
+			if !publishingScheduled {
+				publishingScheduled = rescheduleDuePostPublishing(c, logger, tasksClient, post)
+			}
+		}
+
+		if len(restoredIds) > 0 {
+			syncPostsIndex(c, logger, firestoreClient, postsIndex, restoredIds...)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"restoredIds": restoredIds,
+			"failedIds":   failedIds,
+		})
+	}
+}
+
+// Takes images out of the trash, the scheduled posts held back by them are published if they are due
+func RestoreImagesHandler(logger *logging.Logger, firestoreClient *firestore.Client, tasksClient *cloudtasks.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		form, err := c.MultipartForm()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		expectedVersions, err := tools.ParseIfMatch(c)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		var restoredIds []string
+		var failedIds []string
+
+		for _, id := range form.Value["imagesIds"] {
+			err := firestoreClient.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+				image, err := tools.GetFirestoreDocumentInTransaction(tx, firestoreClient, types.FIREBASE_IMAGES_COLLECTION, id)
+				if err != nil {
+					return err
+				}
+
+				if image == nil || !tools.IsDocumentInTrash(image) {
+					return errors.New("image " + id + " is not in the trash")
+				}
+
+				err = tools.CheckDocumentVersion(expectedVersions, id, image)
+				if err != nil {
+					return err
+				}
+
+				return tx.Update(firestoreClient.Collection(types.FIREBASE_IMAGES_COLLECTION).Doc(id), []firestore.Update{
+					{Path: types.FIREBASE_IMAGES_FIELDS_DELETED_AT, Value: nil},
+					{Path: types.FIREBASE_IMAGES_FIELDS_VERSION, Value: firestore.Increment(1)},
+				})
+			})
+
+			if err != nil {
+				failedIds = append(failedIds, id)
+				logger.Log(logging.Entry{
+					Severity: logging.Error,
+					Payload:  "Error restoring image " + id + ": " + err.Error(),
+					Labels:   map[string]string{"status": "error"},
+				})
+				continue
+			}
+
+			restoredIds = append(restoredIds, id)
+		}
+
+		if len(restoredIds) > 0 {
+			rescheduleImagesPostsPublishing(c, logger, firestoreClient, tasksClient, restoredIds)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"restoredIds": restoredIds,
+			"failedIds":   failedIds,
+		})
+	}
+}
+
+// Permanently deletes the posts and images which stayed in the trash longer than the retention period, called every day
+// by the trash-purge Cloud Scheduler job
+func PurgeTrashHandler(logger *logging.Logger, firestoreClient *firestore.Client, storage *storage.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settings, err := tools.GetTrashSettings(c, firestoreClient)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		cutoff := tools.GetTrashPurgeCutoff(settings, time.Now())
+
+		postsDocs, err := firestoreClient.Collection(types.FIREBASE_POSTS_COLLECTION).Where(types.FIREBASE_POSTS_FIELDS_DELETED_AT, "<=", cutoff).Documents(c).GetAll()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		var purgedPostsIds []string
+		var purgedImagesIds []string
+		var failedIds []string
+
+		for _, doc := range postsDocs {
+			// The documents attached to the post are too many for a single transaction, they are deleted first
+			err := purgePostChildren(c, firestoreClient, cutoff, doc.Ref.ID)
+			if err == nil {
+				err = purgeTrashDocument(c, firestoreClient, storage, cutoff, types.FIREBASE_POSTS_COLLECTION, doc.Ref.ID, purgePost)
+			}
+			if err != nil {
+				failedIds = append(failedIds, doc.Ref.ID)
+				logger.Log(logging.Entry{
+					Severity: logging.Error,
+					Payload:  "Error purging post " + doc.Ref.ID + ": " + err.Error(),
+					Labels:   map[string]string{"status": "error"},
+				})
+				continue
+			}
+
+			purgedPostsIds = append(purgedPostsIds, doc.Ref.ID)
+		}
+
+		// Images are read after the posts, as purging a post also purges its images
+		imagesDocs, err := firestoreClient.Collection(types.FIREBASE_IMAGES_COLLECTION).Where(types.FIREBASE_IMAGES_FIELDS_DELETED_AT, "<=", cutoff).Documents(c).GetAll()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		for _, doc := range imagesDocs {
+			err := purgeTrashDocument(c, firestoreClient, storage, cutoff, types.FIREBASE_IMAGES_COLLECTION, doc.Ref.ID, purgeImage)
+			if err != nil {
+				failedIds = append(failedIds, doc.Ref.ID)
+				logger.Log(logging.Entry{
+					Severity: logging.Error,
+					Payload:  "Error purging image " + doc.Ref.ID + ": " + err.Error(),
+					Labels:   map[string]string{"status": "error"},
+				})
+				continue
+			}
+
+			purgedImagesIds = append(purgedImagesIds, doc.Ref.ID)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"purgedPostsIds":  purgedPostsIds,
+			"purgedImagesIds": purgedImagesIds,
+			"failedIds":       failedIds,
+		})
+	}
+}
+
+// Deletes the documents of a trashed post or image in a transaction, returning the storage paths to delete
+type trashPurger func(tx *firestore.Transaction, db *firestore.Client, id string, data map[string]interface{}) ([]string, error)
+
+// Purges a document if it is still in the trash past the cutoff, the storage objects are deleted through the outbox
+func purgeTrashDocument(c context.Context, db *firestore.Client, storage *storage.Client, cutoff time.Time, collection string, id string, purge trashPurger) error {
+	var outboxRef *firestore.DocumentRef
+	err := db.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+		outboxRef = nil
+
+		data, err := tools.GetFirestoreDocumentInTransaction(tx, db, collection, id)
+		if err != nil || data == nil {
+			return err
+		}
+
+		// The document could have been restored since the query, posts and images use the same deletedAt field
+		deletedAt, ok := data[types.FIREBASE_POSTS_FIELDS_DELETED_AT].(time.Time)
+		if !ok || deletedAt.After(cutoff) {
+			return nil
+		}
+
+		storagePaths, err := purge(tx, db, id, data)
+		if err != nil {
+			return err
+		}
+
+		outboxRef, err = tools.QueueStorageDeletions(tx, db, storagePaths)
+		return err
+	})
+
+	if err != nil || outboxRef == nil {
+		return err
+	}
+
+	// The documents are deleted at this point, storage objects failing to delete are retried by the outbox task
+	err = tools.ProcessStorageDeletion(c, db, storage, outboxRef)
+	if err != nil {
+		return errors.New("storage deletion left in the outbox: " + err.Error())
+	}
+
+	return nil
+}
+
+// Deletes the revisions, comments, share links and reactions of a post still in the trash past the cutoff, with the
+// shards of its reactions counts, in batches outside of the transaction deleting the post. A post restored while they
+// are deleted loses them, a purge failing halfway is resumed by the next call.
+func purgePostChildren(c context.Context, db *firestore.Client, cutoff time.Time, id string) error {
+	post, err := tools.GetFirestoreDocument(c, db, types.FIREBASE_POSTS_COLLECTION, id)
+	if err != nil || post == nil {
+		return err
+	}
+
+	if deletedAt, ok := post[types.FIREBASE_POSTS_FIELDS_DELETED_AT].(time.Time); !ok || deletedAt.After(cutoff) {
+		return nil
+	}
+
+	queries := []firestore.Query{
+		db.Collection(types.FIREBASE_POST_REVISIONS_COLLECTION).Where(types.FIREBASE_POST_REVISIONS_FIELDS_POST_ID, "==", id),
+		db.Collection(types.FIREBASE_COMMENTS_COLLECTION).Where(types.FIREBASE_COMMENTS_FIELDS_POST_ID, "==", id),
+		db.Collection(types.FIREBASE_SHARE_LINKS_COLLECTION).Where(types.FIREBASE_SHARE_LINKS_FIELDS_POST_ID, "==", id),
+		db.Collection(types.FIREBASE_REACTIONS_COLLECTION).Where(types.FIREBASE_REACTIONS_FIELDS_POST_ID, "==", id),
+	}
+
+	for _, query := range queries {
+		err = deleteQueryDocuments(c, db, query)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Deleting a shard which was never written has no effect
+	shards := []*firestore.DocumentRef{}
+	for shard := 0; shard < types.REACTIONS_SHARDS_COUNT; shard++ {
+		shards = append(shards, db.Collection(types.FIREBASE_REACTIONS_SHARDS_COLLECTION).Doc(tools.GetReactionsShardId(id, shard)))
+	}
+
+	return deleteDocuments(c, db, shards)
+}
+
+// Deletes the documents matching a query, a page at a time
+func deleteQueryDocuments(c context.Context, db *firestore.Client, query firestore.Query) error {
+	for {
+		docs, err := query.Limit(types.TRASH_PURGE_BATCH_SIZE).Documents(c).GetAll()
+		if err != nil {
+			return err
+		}
+
+		if len(docs) == 0 {
+			return nil
+		}
+
+		refs := []*firestore.DocumentRef{}
+		for _, doc := range docs {
+			refs = append(refs, doc.Ref)
+		}
+
+		err = deleteDocuments(c, db, refs)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Deletes documents outside of a transaction, each deletion is applied on its own
+func deleteDocuments(c context.Context, db *firestore.Client, refs []*firestore.DocumentRef) error {
+	writer := db.BulkWriter(c)
+	jobs := []*firestore.BulkWriterJob{}
+	for _, ref := range refs {
+		job, err := writer.Delete(ref)
+		if err != nil {
+			writer.End()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	writer.End()
+
+	for _, job := range jobs {
+		_, err := job.Results()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Deletes a post with its images, faces and text regions, its revisions, comments, share links and reactions are
+// deleted before by purgePostChildren. The hash tag scores were already decreased when it was trashed.
+// Images used by other posts or in albums are kept and only detached from the post.
+func purgePost(tx *firestore.Transaction, db *firestore.Client, id string, post map[string]interface{}) ([]string, error) {
+	imagesIds := convertInterfaceToArrayString(post[types.FIREBASE_POSTS_FIELDS_IMAGES_IDS])
+
+	// Read the images, all reads have to be done before the writes
+	images := map[string]map[string]interface{}{}
+	for _, imageId := range imagesIds {
+		image, err := tools.GetFirestoreDocumentInTransaction(tx, db, types.FIREBASE_IMAGES_COLLECTION, imageId)
+		if err != nil {
+			return nil, err
+		}
+
+		// Images already deleted have nothing left to delete
+		if image != nil {
+			images[imageId] = image
+		}
+	}
+
+	storagePaths := []string{}
+	for imageId, image := range images {
+		// Images still used by other posts or in albums outlive the post
+		postsIds := changeImagePostsIds(image, id, false)
+		if albumsCount, _ := image[types.FIREBASE_IMAGES_FIELDS_ALBUMS_COUNT].(int64); len(postsIds) > 0 || albumsCount > 0 {
+			err := setImagePostsIds(tx, db, imageId, convertImageDocumentToPostMedia(image), postsIds)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		imageStoragePaths, err := purgeImage(tx, db, imageId, image)
+		if err != nil {
+			return nil, err
+		}
+
+		storagePaths = append(storagePaths, imageStoragePaths...)
+	}
+
+	return storagePaths, tx.Delete(db.Collection(types.FIREBASE_POSTS_COLLECTION).Doc(id))
+}
+
+// Deletes an image with its faces and text regions
+func purgeImage(tx *firestore.Transaction, db *firestore.Client, id string, image map[string]interface{}) ([]string, error) {
+	media := convertImageDocumentToPostMedia(image)
+
+	err := tx.Delete(db.Collection(types.FIREBASE_IMAGES_COLLECTION).Doc(id))
+	if err != nil {
+		return nil, err
+	}
+
+	// Delete each face from the faces collection
+	for _, faceId := range media.facesIds {
+		err = tx.Delete(db.Collection(types.FIREBASE_FACES_COLLECTION).Doc(faceId))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Delete each text region from the regions collection
+	for _, regionId := range media.regionsIds {
+		err = tx.Delete(db.Collection(types.FIREBASE_REGIONS_COLLECTION).Doc(regionId))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The image, faces, overlays, and every version of the obscured overlays and animations are deleted from storage
+	storagePaths := getPostImageMediaStoragePaths(media)
+	storagePaths = append(storagePaths, getImageAnimationStoragePaths(image)...)
+	storagePaths = append(storagePaths, convertInterfaceToArrayString(image[types.FIREBASE_IMAGES_FIELDS_OBSCURED_VERSIONS_STORAGE_PATHS])...)
+
+	return storagePaths, nil
+}
@@ -1,15 +1,21 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"proteggo_api/firebase"
 	"proteggo_api/handlers"
 	"proteggo_api/middlewares"
+	"proteggo_api/search"
 	"proteggo_api/tasks"
+	"proteggo_api/tools"
 	"proteggo_api/types"
 
+	"cloud.google.com/go/logging"
 	"github.com/gin-gonic/gin"
 )
 
@@ -43,6 +49,65 @@ func main() {
 		log.Fatalf("Failed to initialize Firebase Task client\n")
 	}
 
+	// Open the posts search index and fill it in the background, searches find the posts indexed so far
+	postsIndex, err := search.OpenPostsIndex(types.POSTS_SEARCH_INDEX_PATH)
+	if err != nil {
+		log.Fatalf("Failed to open posts search index: %v\n", err)
+	}
+	defer postsIndex.Close()
+
+	go func() {
+		// Changes made while the index is rebuilt are synced by the first periodic sync
+		syncedUntil := time.Now()
+
+		// The posts written before some of their fields existed are completed first, the index queries filter on them
+		backfilled, err := tools.BackfillPosts(context.Background(), firebaseApp.DB)
+		if err != nil {
+			firebaseApp.Logger.Log(logging.Entry{
+				Severity: logging.Error,
+				Payload:  "Error backfilling posts: " + err.Error(),
+				Labels:   map[string]string{"status": "error"},
+			})
+		} else if backfilled > 0 {
+			firebaseApp.Logger.Log(logging.Entry{
+				Severity: logging.Info,
+				Payload:  "Backfilled " + strconv.Itoa(backfilled) + " posts",
+				Labels:   map[string]string{"status": "success"},
+			})
+		}
+
+		indexed, err := postsIndex.Rebuild(context.Background(), firebaseApp.DB)
+		if err != nil {
+			firebaseApp.Logger.Log(logging.Entry{
+				Severity: logging.Error,
+				Payload:  "Error rebuilding posts search index: " + err.Error(),
+				Labels:   map[string]string{"status": "error"},
+			})
+		} else {
+			firebaseApp.Logger.Log(logging.Entry{
+				Severity: logging.Info,
+				Payload:  "Posts search index rebuilt with " + strconv.Itoa(indexed) + " posts",
+				Labels:   map[string]string{"status": "success"},
+			})
+		}
+
+		// Every instance has its own index, the posts changed through the other instances are read periodically. They
+		// are still synced when the rebuild failed, until an admin rebuilds the index again.
+		ticker := time.NewTicker(types.POSTS_SEARCH_INDEX_SYNC_INTERVAL_SECONDS * time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			syncedUntil, _, err = postsIndex.SyncChanges(context.Background(), firebaseApp.DB, syncedUntil)
+			if err != nil {
+				firebaseApp.Logger.Log(logging.Entry{
+					Severity: logging.Warning,
+					Payload:  "Error syncing posts search index: " + err.Error(),
+					Labels:   map[string]string{"status": "warning"},
+				})
+			}
+		}
+	}()
+
 	r := gin.Default()
 
 	// Disable TrustedProxies feature
@@ -51,15 +116,33 @@ func main() {
 		log.Fatalf("Failed to set trusted proxies: %v\n", err)
 	}
 
-	// Define the routes for the tasks handler
-	taskGroup := r.Group(types.CLOUD_TASKS_HANDLER_PATH)
-	taskGroup.POST("", tasks.ImageProcessingTaskHandler(firebaseApp.Logger, firebaseApp.MessageClient, firebaseApp.Storage, firebaseApp.DB))
+	// Define the routes for the tasks handlers, they are only called by Cloud Tasks and Cloud Scheduler
+	taskGroup := r.Group("")
+	taskGroup.Use(middlewares.TasksAuthMiddleware(firebaseApp.Logger))
+	taskGroup.POST(types.CLOUD_TASKS_HANDLER_PATH, tasks.ImageProcessingTaskHandler(firebaseApp.Logger, firebaseApp.MessageClient, firebaseApp.Storage, firebaseApp.DB))
+
+	// Retries the storage deletions queued by committed transactions
+	taskGroup.POST(types.CLOUD_STORAGE_OUTBOX_HANDLER_PATH, tasks.StorageOutboxTaskHandler(firebaseApp.Logger, firebaseApp.Storage, firebaseApp.DB))
+
+	// Publishes the scheduled posts which are due
+	taskGroup.POST(types.CLOUD_SCHEDULED_POSTS_HANDLER_PATH, tasks.ScheduledPostsTaskHandler(firebaseApp.Logger, firebaseApp.MessageClient, firebaseApp.DB, postsIndex))
+
+	// Completes the posts written before some of their fields existed
+	taskGroup.POST(types.CLOUD_POSTS_BACKFILL_HANDLER_PATH, tasks.PostsBackfillTaskHandler(firebaseApp.Logger, firebaseApp.DB))
+
+	// Permanently deletes the posts and images kept in the trash longer than the retention period
+	taskGroup.POST(types.CLOUD_TRASH_PURGE_HANDLER_PATH, handlers.PurgeTrashHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.Storage))
+
+	// Rolls the reactions counters changed since the last roll up into their posts
+	taskGroup.POST(types.CLOUD_REACTIONS_ROLLUP_HANDLER_PATH, tasks.ReactionsRollupTaskHandler(firebaseApp.Logger, firebaseApp.DB))
 
 	// Define the routes for the application
 	hashTagsGroup := r.Group("/api/hashTags")
 	hashTagsGroup.Use(middlewares.AuthMiddleware(firebaseApp.Logger, firebaseApp.Auth))
 	hashTagsGroup.GET("", handlers.GetHashTagsHandler(firebaseApp.Logger, firebaseApp.DB))
 	hashTagsGroup.GET("/topScored", handlers.GetTopScoredHashTagsHandler(firebaseApp.Logger, firebaseApp.DB))
+	hashTagsGroup.Use(middlewares.ContributorAuthMiddleware(firebaseApp.Logger))
+	hashTagsGroup.GET("/suggestions", handlers.GetHashTagsSuggestionsHandler(firebaseApp.Logger, firebaseApp.DB))
 	hashTagsGroup.Use(middlewares.AdminAuthMiddleware(firebaseApp.Logger))
 	hashTagsGroup.POST("", handlers.SetHashTagsHandler(firebaseApp.Logger, firebaseApp.DB))
 	hashTagsGroup.DELETE("", handlers.DeleteHashTagsHandler(firebaseApp.Logger, firebaseApp.DB))
@@ -67,30 +150,120 @@ func main() {
 	postsGroup := r.Group("/api/posts")
 	postsGroup.Use(middlewares.AuthMiddleware(firebaseApp.Logger, firebaseApp.Auth))
 	postsGroup.GET("", handlers.GetPostsHandler(firebaseApp.Logger, firebaseApp.DB))
+	postsGroup.GET("/byHashTags", handlers.GetPostsByHashTagsHandler(firebaseApp.Logger, firebaseApp.DB))
 	postsGroup.GET("/byHashTags/:hashTags", handlers.GetPostsByHashTagsHandler(firebaseApp.Logger, firebaseApp.DB))
+	postsGroup.GET("/search", handlers.SearchPostsHandler(firebaseApp.Logger, firebaseApp.DB, postsIndex))
+	postsGroup.GET("/export", handlers.ExportPostsHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.Storage))
+	postsGroup.GET("/:id", handlers.GetPostHandler(firebaseApp.Logger, firebaseApp.DB))
+	postsGroup.GET("/:id/export", handlers.ExportPostHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.Storage))
+	postsGroup.GET("/:id/comments", handlers.GetCommentsHandler(firebaseApp.Logger, firebaseApp.DB))
+	postsGroup.GET("/:id/comments/:commentId/replies", handlers.GetCommentRepliesHandler(firebaseApp.Logger, firebaseApp.DB))
+	postsGroup.POST("/:id/comments", handlers.CreateCommentHandler(firebaseApp.Logger, firebaseApp.DB))
+	postsGroup.PATCH("/:id/comments/:commentId", handlers.UpdateCommentHandler(firebaseApp.Logger, firebaseApp.DB))
+	postsGroup.DELETE("/:id/comments/:commentId", handlers.DeleteCommentHandler(firebaseApp.Logger, firebaseApp.DB))
+	postsGroup.GET("/:id/reactions", handlers.GetPostReactionsHandler(firebaseApp.Logger, firebaseApp.DB))
+	postsGroup.POST("/:id/reactions/:type", handlers.AddReactionHandler(firebaseApp.Logger, firebaseApp.DB))
+	postsGroup.DELETE("/:id/reactions/:type", handlers.RemoveReactionHandler(firebaseApp.Logger, firebaseApp.DB))
+	postsGroup.Use(middlewares.ContributorAuthMiddleware(firebaseApp.Logger))
+	postsGroup.POST("", handlers.SubmitPostHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.TaskClient, postsIndex))
+	postsGroup.PATCH("/:id", handlers.UpdatePostHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.TaskClient, postsIndex))
+	postsGroup.GET("/:id/revisions", handlers.GetPostRevisionsHandler(firebaseApp.Logger, firebaseApp.DB))
+	postsGroup.GET("/:id/revisions/diff", handlers.GetPostRevisionsDiffHandler(firebaseApp.Logger, firebaseApp.DB))
+	postsGroup.POST("/:id/revisions/:revision/rollback", handlers.RollbackPostHandler(firebaseApp.Logger, firebaseApp.DB, postsIndex))
+	postsGroup.DELETE("", handlers.DeletePostHandler(firebaseApp.Logger, firebaseApp.DB, postsIndex))
+	postsGroup.GET("/:id/shareLinks", handlers.GetShareLinksHandler(firebaseApp.Logger, firebaseApp.DB))
+	postsGroup.POST("/:id/shareLinks", handlers.CreateShareLinkHandler(firebaseApp.Logger, firebaseApp.DB))
+	postsGroup.DELETE("/:id/shareLinks/:linkId", handlers.RevokeShareLinkHandler(firebaseApp.Logger, firebaseApp.DB))
 	postsGroup.Use(middlewares.AdminAuthMiddleware(firebaseApp.Logger))
-	postsGroup.POST("", handlers.SubmitPostHandler(firebaseApp.Logger, firebaseApp.DB))
-	postsGroup.DELETE("", handlers.DeletePostHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.Storage))
+	postsGroup.POST("/search/reindex", handlers.ReindexPostsHandler(firebaseApp.Logger, firebaseApp.DB, postsIndex))
+	postsGroup.POST("/:id/pin", handlers.PinPostHandler(firebaseApp.Logger, firebaseApp.DB))
+	postsGroup.DELETE("/:id/pin", handlers.UnpinPostHandler(firebaseApp.Logger, firebaseApp.DB))
+
+	// Shared posts are opened without signing in, the signed token is the only credential
+	sharedGroup := r.Group("/api/shared")
+	sharedGroup.GET("/:token", handlers.GetSharedPostHandler(firebaseApp.Logger, firebaseApp.DB))
+	sharedGroup.GET("/:token/page", handlers.GetSharedPostPageHandler(firebaseApp.Logger, firebaseApp.DB))
+
+	// Public posts are syndicated without signing in
+	publicGroup := r.Group("/api/public")
+	publicGroup.GET("/posts/:id", handlers.GetPublicPostHandler(firebaseApp.Logger, firebaseApp.DB))
+	publicGroup.GET("/posts/:id/page", handlers.GetPublicPostPageHandler(firebaseApp.Logger, firebaseApp.DB))
+	publicGroup.GET("/oembed", handlers.GetOEmbedHandler(firebaseApp.Logger, firebaseApp.DB))
+	publicGroup.GET("/feeds/posts/:format", handlers.GetPostsFeedHandler(firebaseApp.Logger, firebaseApp.DB))
+	publicGroup.GET("/feeds/hashTags/:hashTag/:format", handlers.GetPostsFeedHandler(firebaseApp.Logger, firebaseApp.DB))
+
+	reactionsGroup := r.Group("/api/reactions")
+	reactionsGroup.Use(middlewares.AuthMiddleware(firebaseApp.Logger, firebaseApp.Auth))
+	reactionsGroup.GET("/settings", handlers.GetReactionsSettingsHandler(firebaseApp.Logger, firebaseApp.DB))
+	reactionsGroup.Use(middlewares.AdminAuthMiddleware(firebaseApp.Logger))
+	reactionsGroup.POST("/settings", handlers.SetReactionsSettingsHandler(firebaseApp.Logger, firebaseApp.DB))
+
+	albumsGroup := r.Group("/api/albums")
+	albumsGroup.Use(middlewares.AuthMiddleware(firebaseApp.Logger, firebaseApp.Auth))
+	albumsGroup.GET("", handlers.GetAlbumsHandler(firebaseApp.Logger, firebaseApp.DB))
+	albumsGroup.GET("/:id", handlers.GetAlbumHandler(firebaseApp.Logger, firebaseApp.DB))
+	albumsGroup.Use(middlewares.AdminAuthMiddleware(firebaseApp.Logger))
+	albumsGroup.POST("", handlers.CreateAlbumHandler(firebaseApp.Logger, firebaseApp.DB))
+	albumsGroup.PATCH("/:id", handlers.UpdateAlbumHandler(firebaseApp.Logger, firebaseApp.DB))
+	albumsGroup.DELETE("/:id", handlers.DeleteAlbumHandler(firebaseApp.Logger, firebaseApp.DB))
+	albumsGroup.POST("/:id/images", handlers.AddAlbumImagesHandler(firebaseApp.Logger, firebaseApp.DB))
+	albumsGroup.DELETE("/:id/images", handlers.RemoveAlbumImagesHandler(firebaseApp.Logger, firebaseApp.DB))
+
+	usersGroup := r.Group("/api/users")
+	usersGroup.Use(middlewares.AuthMiddleware(firebaseApp.Logger, firebaseApp.Auth))
+	usersGroup.GET("/:uid/posts", handlers.GetUserPostsHandler(firebaseApp.Logger, firebaseApp.DB))
 
 	imagesGroup := r.Group("/api/images")
 	imagesGroup.DELETE("/deleteTemp", handlers.DeleteTempImagesHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.Storage))
 	imagesGroup.DELETE("/deleteUnused", handlers.DeleteUnusedImagesHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.Storage))
 	imagesGroup.Use(middlewares.AuthMiddleware(firebaseApp.Logger, firebaseApp.Auth))
 	imagesGroup.GET("", handlers.GetImagesHandler(firebaseApp.Logger, firebaseApp.DB))
+	imagesGroup.GET("/search", handlers.SearchImagesByTextHandler(firebaseApp.Logger, firebaseApp.DB))
+	imagesGroup.GET("/:id", handlers.GetImageHandler(firebaseApp.Logger, firebaseApp.DB))
+	imagesGroup.GET("/:id/posts", handlers.GetImagePostsHandler(firebaseApp.Logger, firebaseApp.DB))
 	imagesGroup.Use(middlewares.AdminAuthMiddleware(firebaseApp.Logger))
 	imagesGroup.POST("", handlers.UploadImagesHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.Storage, firebaseApp.MessageClient, firebaseApp.TaskClient))
-	imagesGroup.DELETE("", handlers.DeleteImagesHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.Storage))
+	imagesGroup.DELETE("", handlers.DeleteImagesHandler(firebaseApp.Logger, firebaseApp.DB))
 
 	facesGroup := r.Group("/api/faces")
 	facesGroup.Use(middlewares.AuthMiddleware(firebaseApp.Logger, firebaseApp.Auth))
 	facesGroup.Use(middlewares.AdminAuthMiddleware(firebaseApp.Logger))
+	facesGroup.GET("", handlers.GetFacesHandler(firebaseApp.Logger, firebaseApp.DB))
 	facesGroup.GET("/overlay", handlers.GetFacesOverlayHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.Storage))
 	facesGroup.POST("/overlay/obscured", handlers.SetObscuredOverlayHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.Storage))
 	facesGroup.POST("/overlay/obscured/temp", handlers.CreateTempObscuredOverlayHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.Storage))
+	facesGroup.POST("/overlay/obscured/animation", handlers.CreateObscuredAnimationHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.Storage))
 	facesGroup.DELETE("", handlers.DeleteFacesHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.Storage))
 	facesGroup.DELETE("/overlay", handlers.DeleteFacesOverlayHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.Storage))
 	facesGroup.DELETE("/overlay/obscured", handlers.DeleteObscuredFacesOverlayHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.Storage))
 
+	regionsGroup := r.Group("/api/regions")
+	regionsGroup.Use(middlewares.AuthMiddleware(firebaseApp.Logger, firebaseApp.Auth))
+	regionsGroup.Use(middlewares.AdminAuthMiddleware(firebaseApp.Logger))
+	regionsGroup.GET("", handlers.GetRegionsHandler(firebaseApp.Logger, firebaseApp.DB))
+
+	moderationGroup := r.Group("/api/moderation")
+	moderationGroup.Use(middlewares.AuthMiddleware(firebaseApp.Logger, firebaseApp.Auth))
+	moderationGroup.Use(middlewares.AdminAuthMiddleware(firebaseApp.Logger))
+	moderationGroup.GET("/policy", handlers.GetModerationPolicyHandler(firebaseApp.Logger, firebaseApp.DB))
+	moderationGroup.POST("/policy", handlers.SetModerationPolicyHandler(firebaseApp.Logger, firebaseApp.DB))
+	moderationGroup.GET("/quarantined", handlers.GetQuarantinedImagesHandler(firebaseApp.Logger, firebaseApp.DB))
+	moderationGroup.POST("/review", handlers.ReviewImagesHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.TaskClient))
+	moderationGroup.GET("/comments", handlers.GetModeratedCommentsHandler(firebaseApp.Logger, firebaseApp.DB))
+	moderationGroup.POST("/comments/review", handlers.ModerateCommentsHandler(firebaseApp.Logger, firebaseApp.DB))
+	moderationGroup.GET("/comments/settings", handlers.GetCommentsSettingsHandler(firebaseApp.Logger, firebaseApp.DB))
+	moderationGroup.POST("/comments/settings", handlers.SetCommentsSettingsHandler(firebaseApp.Logger, firebaseApp.DB))
+
+	trashGroup := r.Group("/api/trash")
+	trashGroup.Use(middlewares.AuthMiddleware(firebaseApp.Logger, firebaseApp.Auth))
+	trashGroup.Use(middlewares.AdminAuthMiddleware(firebaseApp.Logger))
+	trashGroup.GET("/posts", handlers.GetTrashedPostsHandler(firebaseApp.Logger, firebaseApp.DB))
+	trashGroup.POST("/posts/restore", handlers.RestorePostsHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.TaskClient, postsIndex))
+	trashGroup.GET("/images", handlers.GetTrashedImagesHandler(firebaseApp.Logger, firebaseApp.DB))
+	trashGroup.POST("/images/restore", handlers.RestoreImagesHandler(firebaseApp.Logger, firebaseApp.DB, firebaseApp.TaskClient))
+	trashGroup.GET("/settings", handlers.GetTrashSettingsHandler(firebaseApp.Logger, firebaseApp.DB))
+	trashGroup.POST("/settings", handlers.SetTrashSettingsHandler(firebaseApp.Logger, firebaseApp.DB))
+
 	messagingGroup := r.Group("/api/messaging")
 	messagingGroup.Use(middlewares.AuthMiddleware(firebaseApp.Logger, firebaseApp.Auth))
 	messagingGroup.POST("", handlers.SetMessagingRegistrationToken(firebaseApp.Logger, firebaseApp.DB))
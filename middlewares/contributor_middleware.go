@@ -0,0 +1,28 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"proteggo_api/tools"
+
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// Contributor authorization middleware, admins are let through too
+func ContributorAuthMiddleware(logger *logging.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Check for the contributor or admin role within the token's claims
+		if tools.IsContributorUser(c) {
+			c.Next()
+			return
+		}
+
+		// If the code reaches this point, the user is either not authenticated or not a contributor
+		logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload:  "You must be a contributor to perform this action",
+		})
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "You must be a contributor to perform this action"})
+	}
+}
@@ -11,7 +11,7 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// Validate file type middleware, only allow images with max 5mb per image
+// Validate file type middleware, only allow jpeg, png and gif images with max 5mb per image
 func ImageValidationMiddleware(logger *logging.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the multipart form data from the request
@@ -83,7 +83,7 @@ func validateAndProcessFile(file *multipart.FileHeader, id string, fileInfos []m
 
 	// Detect content type
 	contentType := http.DetectContentType(buf[:n])
-	if contentType != "image/jpeg" && contentType != "image/png" {
+	if contentType != "image/jpeg" && contentType != "image/png" && contentType != "image/gif" {
 		return nil, fmt.Errorf("unsupported file type: %v", contentType)
 	}
 
@@ -0,0 +1,65 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"proteggo_api/tools"
+	"proteggo_api/types"
+
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/api/idtoken"
+)
+
+// Tasks authorization middleware, only lets through the calls of Cloud Tasks and Cloud Scheduler carrying an OIDC token
+// of the tasks service account issued for the service
+func TasksAuthMiddleware(logger *logging.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		email, err := tools.GetTasksServiceAccountEmail()
+		if err != nil {
+			logger.Log(logging.Entry{
+				Severity: logging.Error,
+				Payload:  "Tasks can not be authorized: " + err.Error(),
+			})
+
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Unexpected error occurred"})
+			return
+		}
+
+		idToken := extractToken(c)
+		if idToken == "" {
+			logger.Log(logging.Entry{
+				Severity: logging.Error,
+				Payload:  "Unauthorized - No OIDC token provided",
+			})
+
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - No OIDC token provided"})
+			return
+		}
+
+		// The token is signed by Google for the service url, it identifies the caller by its email
+		payload, err := idtoken.Validate(c.Request.Context(), idToken, types.CLOUD_TASKS_OIDC_AUDIENCE)
+		if err != nil {
+			logger.Log(logging.Entry{
+				Severity: logging.Error,
+				Payload:  "Unauthorized - Invalid OIDC token: " + err.Error(),
+			})
+
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - Invalid OIDC token"})
+			return
+		}
+
+		if tokenEmail, _ := payload.Claims["email"].(string); tokenEmail == email {
+			if verified, _ := payload.Claims["email_verified"].(bool); verified {
+				c.Next()
+				return
+			}
+		}
+
+		logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload:  "Only the tasks service account can perform this action",
+		})
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Only the tasks service account can perform this action"})
+	}
+}
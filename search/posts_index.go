@@ -0,0 +1,378 @@
+package search
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"proteggo_api/tools"
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/standard"
+	"github.com/blevesearch/bleve/v2/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/v2/analysis/tokenizer/single"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/numeric"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+const postDocumentType = "post"
+const hashTagAnalyzer = "hashTag"
+
+// Fields of the indexed posts
+const fieldBody = "body"
+const fieldHashTags = "hashTags"
+const fieldStatus = "status"
+const fieldVisibility = "visibility"
+const fieldPublishedAt = "publishedAt"
+
+// Full-text index of the body and hash tags of the posts which are not in the trash, embedded in the instance.
+// Firestore stays the source of truth, the index is rebuilt from it when the instance starts, synced after each change
+// made through the instance and synced periodically with the changes made through the other instances.
+type PostsIndex struct {
+	index bleve.Index
+}
+
+// Post as it is stored in the index
+type postDocument struct {
+	Body        string    `json:"body"`
+	HashTags    []string  `json:"hashTags"`
+	Status      string    `json:"status"`
+	Visibility  string    `json:"visibility"`
+	PublishedAt time.Time `json:"publishedAt"`
+}
+
+func (document postDocument) BleveType() string {
+	return postDocumentType
+}
+
+// Filters and position of a posts search, empty filters are not applied
+type PostsSearch struct {
+	Text         string
+	HashTags     []string
+	Status       string
+	Visibilities []string
+	StartDate    *time.Time
+	EndDate      *time.Time
+	Cursor       *types.PostsCursor
+	Size         int
+}
+
+// Post matching a search, in the order of the results
+type PostsSearchHit struct {
+	Id          string
+	PublishedAt time.Time
+}
+
+// Creates an empty posts index at the path. An index left by a previous run is dropped, the posts are indexed again
+// from Firestore anyway and its mapping could be outdated.
+func OpenPostsIndex(path string) (*PostsIndex, error) {
+	err := os.RemoveAll(path)
+	if err != nil {
+		return nil, err
+	}
+
+	indexMapping, err := buildPostsIndexMapping()
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := bleve.New(path, indexMapping)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PostsIndex{index: index}, nil
+}
+
+// The body is searched as text, hash tags are matched as whole values regardless of case
+func buildPostsIndexMapping() (mapping.IndexMapping, error) {
+	indexMapping := bleve.NewIndexMapping()
+
+	err := indexMapping.AddCustomAnalyzer(hashTagAnalyzer, map[string]interface{}{
+		"type":          custom.Name,
+		"tokenizer":     single.Name,
+		"token_filters": []string{lowercase.Name},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bodyMapping := bleve.NewTextFieldMapping()
+	bodyMapping.Analyzer = standard.Name
+
+	hashTagsMapping := bleve.NewTextFieldMapping()
+	hashTagsMapping.Analyzer = hashTagAnalyzer
+
+	statusMapping := bleve.NewKeywordFieldMapping()
+	statusMapping.IncludeInAll = false
+
+	visibilityMapping := bleve.NewKeywordFieldMapping()
+	visibilityMapping.IncludeInAll = false
+
+	publishedAtMapping := bleve.NewDateTimeFieldMapping()
+	publishedAtMapping.IncludeInAll = false
+
+	postMapping := bleve.NewDocumentStaticMapping()
+	postMapping.AddFieldMappingsAt(fieldBody, bodyMapping)
+	postMapping.AddFieldMappingsAt(fieldHashTags, hashTagsMapping)
+	postMapping.AddFieldMappingsAt(fieldStatus, statusMapping)
+	postMapping.AddFieldMappingsAt(fieldVisibility, visibilityMapping)
+	postMapping.AddFieldMappingsAt(fieldPublishedAt, publishedAtMapping)
+
+	indexMapping.AddDocumentMapping(postDocumentType, postMapping)
+	indexMapping.DefaultAnalyzer = standard.Name
+
+	return indexMapping, nil
+}
+
+// Indexes all the posts which are not in the trash and removes the posts which no longer exist from the index
+func (postsIndex *PostsIndex) Rebuild(c context.Context, db *firestore.Client) (int, error) {
+	docs, err := db.Collection(types.FIREBASE_POSTS_COLLECTION).Where(types.FIREBASE_POSTS_FIELDS_DELETED_AT, "==", nil).Documents(c).GetAll()
+	if err != nil {
+		return 0, err
+	}
+
+	batch := postsIndex.index.NewBatch()
+	indexedIds := map[string]bool{}
+	for _, doc := range docs {
+		document, ok := convertDataToPostDocument(doc.Data())
+		if !ok {
+			continue
+		}
+
+		err = batch.Index(doc.Ref.ID, document)
+		if err != nil {
+			return 0, err
+		}
+		indexedIds[doc.Ref.ID] = true
+	}
+
+	// Posts deleted while the index was not synced are still in it
+	count, err := postsIndex.index.DocCount()
+	if err != nil {
+		return 0, err
+	}
+
+	if count > 0 {
+		request := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), int(count), 0, false)
+		result, err := postsIndex.index.Search(request)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, hit := range result.Hits {
+			if !indexedIds[hit.ID] {
+				batch.Delete(hit.ID)
+			}
+		}
+	}
+
+	err = postsIndex.index.Batch(batch)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(indexedIds), nil
+}
+
+// Reads the posts from Firestore and updates them in the index, posts which were deleted or moved to the trash are removed
+func (postsIndex *PostsIndex) Sync(c context.Context, db *firestore.Client, ids ...string) error {
+	batch := postsIndex.index.NewBatch()
+
+	for _, id := range ids {
+		data, err := tools.GetFirestoreDocument(c, db, types.FIREBASE_POSTS_COLLECTION, id)
+		if err != nil {
+			return err
+		}
+
+		document, ok := convertDataToPostDocument(data)
+		if !ok || tools.IsDocumentInTrash(data) {
+			batch.Delete(id)
+			continue
+		}
+
+		err = batch.Index(id, document)
+		if err != nil {
+			return err
+		}
+	}
+
+	return postsIndex.index.Batch(batch)
+}
+
+// Reads the posts changed since the given time from Firestore and updates them in the index, returns the time of the
+// last change read to be given to the next call. Posts changed shortly before that time are read again.
+func (postsIndex *PostsIndex) SyncChanges(c context.Context, db *firestore.Client, since time.Time) (time.Time, int, error) {
+	from := since.Add(-types.POSTS_SEARCH_INDEX_SYNC_OVERLAP_SECONDS * time.Second)
+	docs, err := db.Collection(types.FIREBASE_POSTS_COLLECTION).Where(types.FIREBASE_POSTS_FIELDS_UPDATED_AT, ">=", from).Documents(c).GetAll()
+	if err != nil {
+		return since, 0, err
+	}
+
+	latest := since
+	batch := postsIndex.index.NewBatch()
+	for _, doc := range docs {
+		data := doc.Data()
+		if updatedAt, ok := data[types.FIREBASE_POSTS_FIELDS_UPDATED_AT].(time.Time); ok && updatedAt.After(latest) {
+			latest = updatedAt
+		}
+
+		document, ok := convertDataToPostDocument(data)
+		if !ok || tools.IsDocumentInTrash(data) {
+			batch.Delete(doc.Ref.ID)
+			continue
+		}
+
+		err = batch.Index(doc.Ref.ID, document)
+		if err != nil {
+			return since, 0, err
+		}
+	}
+
+	err = postsIndex.index.Batch(batch)
+	if err != nil {
+		return since, 0, err
+	}
+
+	return latest, len(docs), nil
+}
+
+// Searches the posts from the newest, after or before the cursor. One more hit than the size is returned when
+// there are more posts in the direction of the cursor.
+func (postsIndex *PostsIndex) Search(postsSearch PostsSearch) ([]PostsSearchHit, uint64, error) {
+	conjuncts := []query.Query{}
+
+	if strings.TrimSpace(postsSearch.Text) != "" {
+		// The query string syntax supports "quoted phrases", +required and -excluded terms
+		textQuery := bleve.NewQueryStringQuery(postsSearch.Text)
+		conjuncts = append(conjuncts, textQuery)
+	}
+
+	for _, hashTag := range postsSearch.HashTags {
+		hashTagQuery := bleve.NewTermQuery(strings.ToLower(hashTag))
+		hashTagQuery.SetField(fieldHashTags)
+		conjuncts = append(conjuncts, hashTagQuery)
+	}
+
+	if postsSearch.Status != "" {
+		statusQuery := bleve.NewTermQuery(postsSearch.Status)
+		statusQuery.SetField(fieldStatus)
+		conjuncts = append(conjuncts, statusQuery)
+	}
+
+	if len(postsSearch.Visibilities) > 0 {
+		visibilityQueries := []query.Query{}
+		for _, visibility := range postsSearch.Visibilities {
+			visibilityQuery := bleve.NewTermQuery(visibility)
+			visibilityQuery.SetField(fieldVisibility)
+			visibilityQueries = append(visibilityQueries, visibilityQuery)
+		}
+		conjuncts = append(conjuncts, bleve.NewDisjunctionQuery(visibilityQueries...))
+	}
+
+	if postsSearch.StartDate != nil || postsSearch.EndDate != nil {
+		var start, end time.Time
+		if postsSearch.StartDate != nil {
+			start = *postsSearch.StartDate
+		}
+		if postsSearch.EndDate != nil {
+			end = *postsSearch.EndDate
+		}
+
+		inclusive := true
+		dateQuery := bleve.NewDateRangeInclusiveQuery(start, end, &inclusive, &inclusive)
+		dateQuery.SetField(fieldPublishedAt)
+		conjuncts = append(conjuncts, dateQuery)
+	}
+
+	var searchQuery query.Query = bleve.NewMatchAllQuery()
+	if len(conjuncts) > 0 {
+		searchQuery = bleve.NewConjunctionQuery(conjuncts...)
+	}
+
+	// Posts are ordered like the other listings, so the results can be paged with the same cursors
+	request := bleve.NewSearchRequestOptions(searchQuery, postsSearch.Size+1, 0, false)
+	request.SortByCustom(search.SortOrder{
+		&search.SortField{Field: fieldPublishedAt, Type: search.SortFieldAsDate, Desc: true},
+		&search.SortDocID{Desc: true},
+	})
+
+	if postsSearch.Cursor != nil {
+		position := []string{
+			string(numeric.MustNewPrefixCodedInt64(postsSearch.Cursor.CreatedAt.UnixNano(), 0)),
+			postsSearch.Cursor.Id,
+		}
+
+		if postsSearch.Cursor.Direction == types.POSTS_CURSOR_DIRECTION_PREV {
+			request.SetSearchBefore(position)
+		} else {
+			request.SetSearchAfter(position)
+		}
+	}
+
+	result, err := postsIndex.index.Search(request)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hits := []PostsSearchHit{}
+	for _, hit := range result.Hits {
+		publishedAt, err := numeric.PrefixCoded(hit.Sort[0]).Int64()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		hits = append(hits, PostsSearchHit{
+			Id:          hit.ID,
+			PublishedAt: time.Unix(0, publishedAt),
+		})
+	}
+
+	return hits, result.Total, nil
+}
+
+func (postsIndex *PostsIndex) Close() error {
+	return postsIndex.index.Close()
+}
+
+// Converts a post document data to the indexed post, posts without a creation time are not indexed
+func convertDataToPostDocument(data map[string]interface{}) (postDocument, bool) {
+	if data == nil {
+		return postDocument{}, false
+	}
+
+	createdAt, ok := data[types.FIREBASE_POSTS_FIELDS_CREATED_AT].(time.Time)
+	if !ok {
+		return postDocument{}, false
+	}
+
+	// Posts not backfilled yet were published when they were created
+	publishedAt, ok := data[types.FIREBASE_POSTS_FIELDS_PUBLISHED_AT].(time.Time)
+	if !ok {
+		publishedAt = createdAt
+	}
+
+	document := postDocument{
+		PublishedAt: publishedAt,
+		HashTags:    []string{},
+	}
+	document.Body, _ = data[types.FIREBASE_POSTS_FIELDS_BODY].(string)
+	document.Status, _ = data[types.FIREBASE_POSTS_FIELDS_STATUS].(string)
+	document.Visibility, _ = data[types.FIREBASE_POSTS_FIELDS_VISIBILITY].(string)
+
+	if hashTags, ok := data[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_VALUES].([]interface{}); ok {
+		for _, hashTag := range hashTags {
+			if value, ok := hashTag.(string); ok {
+				document.HashTags = append(document.HashTags, value)
+			}
+		}
+	}
+
+	return document, true
+}
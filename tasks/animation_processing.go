@@ -0,0 +1,82 @@
+package tasks
+
+import (
+	"bytes"
+	"errors"
+	"image/gif"
+
+	"proteggo_api/tools"
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// Stores the original animation and tracks the faces across its frames, returns the fields to save on the image document
+func processAnimation(c *gin.Context, firestoreClient *firestore.Client, storage *storage.Client, filePath string) (map[string]interface{}, error) {
+	g, err := tools.GetGifFromStorage(filePath, storage, c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Single frame GIFs are processed as regular images
+	if len(g.Image) < 2 {
+		return map[string]interface{}{
+			types.FIREBASE_IMAGES_FIELDS_ANIMATED: false,
+		}, nil
+	}
+
+	// Animations too large to be composed in memory are processed as regular images, from their first frame
+	frames, err := tools.ComposeGifFrames(g)
+	if errors.Is(err, tools.ErrAnimationTooLarge) {
+		return map[string]interface{}{
+			types.FIREBASE_IMAGES_FIELDS_ANIMATED: false,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Detect faces and text regions on the sampled frames only, Vision does not accept animations
+	samples := tools.SampleFramesIndexes(len(frames), types.ANIMATION_FACES_DETECTION_MAX_SAMPLED_FRAMES)
+
+	samplesBoxes := [][][]map[string]int{}
+	for _, sample := range samples {
+		boxes, err := tools.DetectFacesBoxesInImage(c, frames[sample], types.ANIMATION_FACES_DETECTION_MAX_RESULTS)
+		if err != nil {
+			return nil, err
+		}
+
+		samplesBoxes = append(samplesBoxes, boxes)
+	}
+
+	// Only the boxes on the samples are stored, a box for every frame could exceed the size of a document
+	tracks := tools.LinkFacesAcrossSamples(samples, samplesBoxes)
+
+	// Keep the original animation, the temp upload is deleted after processing
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		return nil, err
+	}
+
+	randomName, err := tools.GenerateRandomName()
+	if err != nil {
+		return nil, err
+	}
+
+	storagePath := types.FIREBASE_STORAGE_ANIMATIONS_FOLDER + randomName + ".gif"
+	url, err := tools.GenerateImageUrl(c, firestoreClient, storage, buf.Bytes(), types.FIREBASE_STORAGE_BUCKET, storagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		types.FIREBASE_IMAGES_FIELDS_ANIMATED:                 true,
+		types.FIREBASE_IMAGES_FIELDS_ANIMATION_URL:            url,
+		types.FIREBASE_IMAGES_FIELDS_ANIMATION_STORAGE_PATH:   storagePath,
+		types.FIREBASE_IMAGES_FIELDS_ANIMATION_FRAMES_COUNT:   len(frames),
+		types.FIREBASE_IMAGES_FIELDS_ANIMATION_FACES_TRACKS:   tools.ConvertFaceTracksToFirestore(tracks),
+		types.FIREBASE_IMAGES_FIELDS_ANIMATION_SAMPLED_FRAMES: samples,
+	}, nil
+}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
+	"proteggo_api/tools"
 	"proteggo_api/types"
 
 	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
 	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
 	"cloud.google.com/go/logging"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // TODO: Configure the queue using glocud or console or create a code to do it programatically
@@ -30,6 +33,12 @@ func CreateTask(context context.Context, client *cloudtasks.Client, logger *logg
 		return nil, err
 	}
 
+	// The task handlers only accept the OIDC token of the tasks service account
+	oidcToken, err := getTasksOidcToken(logger)
+	if err != nil {
+		return nil, err
+	}
+
 	// Build the Task payload.
 	// https://godoc.org/google.golang.org/genproto/googleapis/cloud/tasks/v2#CreateTaskRequest
 	req := &taskspb.CreateTaskRequest{
@@ -37,8 +46,9 @@ func CreateTask(context context.Context, client *cloudtasks.Client, logger *logg
 		Task: &taskspb.Task{
 			MessageType: &taskspb.Task_HttpRequest{
 				HttpRequest: &taskspb.HttpRequest{
-					HttpMethod: taskspb.HttpMethod_POST,
-					Url:        types.CLOUD_RUN_SERVICE_URL + types.CLOUD_TASKS_HANDLER_PATH,
+					HttpMethod:          taskspb.HttpMethod_POST,
+					Url:                 types.CLOUD_RUN_SERVICE_URL + types.CLOUD_TASKS_HANDLER_PATH,
+					AuthorizationHeader: oidcToken,
 				},
 			},
 		},
@@ -59,3 +69,61 @@ func CreateTask(context context.Context, client *cloudtasks.Client, logger *logg
 
 	return createdTask, nil
 }
+
+// Creates a task running the scheduled posts handler at the publish time of a scheduled post
+func CreateScheduledPostsTask(context context.Context, client *cloudtasks.Client, logger *logging.Logger, publishAt time.Time) (*taskspb.Task, error) {
+	// Build the Task queue path.
+	queuePath := fmt.Sprintf("projects/%s/locations/%s/queues/%s", types.FIREBASE_PROJECT_ID, types.FIREBASE_LOCATION_ID, types.CLOUD_SCHEDULED_POSTS_QUEUE_ID)
+
+	oidcToken, err := getTasksOidcToken(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// The handler publishes every post which is due, so the task needs no payload
+	req := &taskspb.CreateTaskRequest{
+		Parent: queuePath,
+		Task: &taskspb.Task{
+			ScheduleTime: timestamppb.New(publishAt),
+			MessageType: &taskspb.Task_HttpRequest{
+				HttpRequest: &taskspb.HttpRequest{
+					HttpMethod:          taskspb.HttpMethod_POST,
+					Url:                 types.CLOUD_RUN_SERVICE_URL + types.CLOUD_SCHEDULED_POSTS_HANDLER_PATH,
+					AuthorizationHeader: oidcToken,
+				},
+			},
+		},
+	}
+
+	createdTask, err := client.CreateTask(context, req)
+	if err != nil {
+		logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload:  "Error creating scheduled posts task",
+			Labels:   map[string]string{"error": err.Error()},
+		})
+		return nil, err
+	}
+
+	return createdTask, nil
+}
+
+// Builds the OIDC token Cloud Tasks signs as the tasks service account when calling the task handlers
+func getTasksOidcToken(logger *logging.Logger) (*taskspb.HttpRequest_OidcToken, error) {
+	email, err := tools.GetTasksServiceAccountEmail()
+	if err != nil {
+		logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload:  "Error creating task",
+			Labels:   map[string]string{"error": err.Error()},
+		})
+		return nil, err
+	}
+
+	return &taskspb.HttpRequest_OidcToken{
+		OidcToken: &taskspb.OidcToken{
+			ServiceAccountEmail: email,
+			Audience:            types.CLOUD_TASKS_OIDC_AUDIENCE,
+		},
+	}, nil
+}
@@ -44,14 +44,41 @@ func ImageProcessingTaskHandler(logger *logging.Logger, messageClient *messaging
 		// Apply the orientation correction
 		correctedImg, err := tools.CorrectImageOrientation(logger, img, upload.Orientation)
 
-		// Detect faces in the image
-		faces, err := tools.DetectFacesInImage(c, firestoreClient, storage, correctedImg, 10) // TODO: Adjust maxResults as needed
+		// Annotate the image with a single Vision request for the safe search, labels, faces and text
+		annotations, err := tools.AnnotateImage(c, firestoreClient, storage, correctedImg, 10, 10) // TODO: Adjust maxResults as needed
 		if err != nil {
 			tools.LogError(logger, c, err)
 			return
 		}
 
-		// Extract vertices from the faces
+		// Adult, violent and racy content crossing the moderation policy is quarantined until reviewed
+		safeSearch := annotations.SafeSearch
+
+		moderationPolicy, err := tools.GetModerationPolicy(c, firestoreClient)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		moderationStatus := tools.EvaluateSafeSearch(moderationPolicy, safeSearch)
+
+		// Labels are used to suggest hash tags
+		labelsValue := []map[string]interface{}{}
+		for _, label := range annotations.Labels {
+			labelsValue = append(labelsValue, map[string]interface{}{
+				types.FIREBASE_IMAGES_FIELDS_LABELS_DESCRIPTION: label.Description,
+				types.FIREBASE_IMAGES_FIELDS_LABELS_SCORE:       label.Score,
+			})
+		}
+
+		// The visible text lets images be searched by signs, banners etc.
+		text := annotations.Text
+
+		// Sensitive text regions are license plates, name badges and documents
+		faces := annotations.Faces
+		regions := annotations.Regions
+
+		// Extract vertices from the faces and regions
 		facesVertices := []types.FaceVertices{}
 		for _, face := range faces {
 			facesVertices = append(facesVertices, types.FaceVertices{
@@ -60,14 +87,21 @@ func ImageProcessingTaskHandler(logger *logging.Logger, messageClient *messaging
 				Vertices: face.Vertices,
 			})
 		}
+		for _, region := range regions {
+			facesVertices = append(facesVertices, types.FaceVertices{
+				Id:       region.Id,
+				ImageId:  upload.Id,
+				Vertices: region.Vertices,
+			})
+		}
 
 		// Get image dimensions
 		width, height := tools.GetImageDimensions(correctedImg)
 
-		// Draw borders around the faces
+		// Draw borders around the faces and regions
 		overlayUrl := ""
 		overlayStoragePath := ""
-		if len(faces) > 0 {
+		if len(facesVertices) > 0 {
 			overlayStoragePath = types.FIREBASE_STORAGE_FACES_OVERLAY_FOLDER + upload.Id + ".png"
 			overlayUrl, err = tools.DrawBordersAroundFaces(c, firestoreClient, storage, upload.Id, width, height, facesVertices)
 			if err != nil {
@@ -94,7 +128,8 @@ func ImageProcessingTaskHandler(logger *logging.Logger, messageClient *messaging
 				types.FIREBASE_FACES_FIELDS_URL:          face.Url,
 				types.FIREBASE_FACES_FIELDS_IMAGE_ID:     upload.Id,
 				types.FIREBASE_FACES_FIELDS_CREATED_AT:   firestore.ServerTimestamp,
-				types.FIREBASE_FACES_FIELDS_POST_ID:      nil,
+				types.FIREBASE_FACES_FIELDS_POSTS_IDS:    []string{},
+				types.FIREBASE_FACES_FIELDS_VERSION:      1,
 			})
 
 			if err != nil {
@@ -107,6 +142,33 @@ func ImageProcessingTaskHandler(logger *logging.Logger, messageClient *messaging
 			facesStoragePaths = append(facesStoragePaths, face.StoragePath)
 		}
 
+		// Iterate over the regions
+		regionsIds := []string{}
+
+		for _, region := range regions {
+			err = tools.SetFirestoreDocument(c, firestoreClient, types.FIREBASE_REGIONS_COLLECTION, region.Id, map[string]interface{}{
+				types.FIREBASE_REGIONS_FIELDS_ID:         region.Id,
+				types.FIREBASE_REGIONS_FIELDS_TYPE:       region.Type,
+				types.FIREBASE_REGIONS_FIELDS_TEXT:       region.Text,
+				types.FIREBASE_REGIONS_FIELDS_VERTICES:   region.Vertices,
+				types.FIREBASE_REGIONS_FIELDS_IMAGE_ID:   upload.Id,
+				types.FIREBASE_REGIONS_FIELDS_CREATED_AT: firestore.ServerTimestamp,
+				types.FIREBASE_REGIONS_FIELDS_POSTS_IDS:  []string{},
+			})
+
+			if err != nil {
+				tools.LogError(logger, c, err)
+				return
+			}
+
+			regionsIds = append(regionsIds, region.Id)
+		}
+
+		var regionsIdsValue interface{}
+		if len(regionsIds) > 0 {
+			regionsIdsValue = regionsIds
+		}
+
 		facesIdsField := len(facesIds) > 0
 		var facesIdsValue interface{}
 		if facesIdsField {
@@ -154,21 +216,49 @@ func ImageProcessingTaskHandler(logger *logging.Logger, messageClient *messaging
 			return
 		}
 
-		// Save the URL to Firestore
-		err = tools.SetFirestoreDocument(c, firestoreClient, types.FIREBASE_IMAGES_COLLECTION, upload.Id, map[string]interface{}{
+		// Animated GIFs keep all their frames, the still image above is made from the first frame
+		animationFields := map[string]interface{}{
+			types.FIREBASE_IMAGES_FIELDS_ANIMATED: false,
+		}
+		if upload.ContentType == "image/gif" {
+			animationFields, err = processAnimation(c, firestoreClient, storage, upload.FilePath)
+			if err != nil {
+				tools.LogError(logger, c, err)
+				return
+			}
+		}
+
+		imageFields := map[string]interface{}{
 			types.FIREBASE_IMAGES_FIELDS_ID:                         upload.Id,
 			types.FIREBASE_IMAGES_FIELDS_STORAGE_PATH:               storagePath,
 			types.FIREBASE_IMAGES_FIELDS_URL:                        url,
 			types.FIREBASE_IMAGES_FIELDS_CREATED_AT:                 firestore.ServerTimestamp,
 			types.FIREBASE_IMAGES_FIELDS_WIDTH:                      width,
 			types.FIREBASE_IMAGES_FIELDS_HEIGHT:                     height,
-			types.FIREBASE_IMAGES_FIELDS_POST_ID:                    nil,
+			types.FIREBASE_IMAGES_FIELDS_POSTS_IDS:                  []string{},
 			types.FIREBASE_IMAGES_FIELDS_FACES_IDS:                  facesIdsValue,
 			types.FIREBASE_IMAGES_FIELDS_FACES_URLS:                 facesUrlsValue,
 			types.FIREBASE_IMAGES_FIELDS_FACES_STORAGE_PATHS:        facesStoragePathsValue,
 			types.FIREBASE_IMAGES_FIELDS_FACES_OVERLAY_URL:          overlayUrl,
 			types.FIREBASE_IMAGES_FIELDS_FACES_OVERLAY_STORAGE_PATH: overlayStoragePath,
-		})
+			types.FIREBASE_IMAGES_FIELDS_REGIONS_IDS:                regionsIdsValue,
+			types.FIREBASE_IMAGES_FIELDS_SAFE_SEARCH: map[string]interface{}{
+				types.FIREBASE_IMAGES_FIELDS_SAFE_SEARCH_ADULT:    safeSearch.Adult,
+				types.FIREBASE_IMAGES_FIELDS_SAFE_SEARCH_VIOLENCE: safeSearch.Violence,
+				types.FIREBASE_IMAGES_FIELDS_SAFE_SEARCH_RACY:     safeSearch.Racy,
+			},
+			types.FIREBASE_IMAGES_FIELDS_LABELS:            labelsValue,
+			types.FIREBASE_IMAGES_FIELDS_TEXT:              text,
+			types.FIREBASE_IMAGES_FIELDS_TEXT_TOKENS:       tools.TokenizeText(text),
+			types.FIREBASE_IMAGES_FIELDS_MODERATION_STATUS: moderationStatus,
+			types.FIREBASE_IMAGES_FIELDS_VERSION:           1,
+		}
+		for field, value := range animationFields {
+			imageFields[field] = value
+		}
+
+		// Save the URL to Firestore
+		err = tools.SetFirestoreDocument(c, firestoreClient, types.FIREBASE_IMAGES_COLLECTION, upload.Id, imageFields)
 
 		if err != nil {
 			tools.LogError(logger, c, err)
@@ -189,6 +279,8 @@ func ImageProcessingTaskHandler(logger *logging.Logger, messageClient *messaging
 				FacesIds:          facesIds,
 				FacesUrls:         facesUrls,
 				FacesStoragePaths: facesStoragePaths,
+				RegionsIds:        regionsIds,
+				ModerationStatus:  moderationStatus,
 			})
 
 			c.JSON(http.StatusOK, gin.H{types.FIREBASE_IMAGES_FIELDS_URL: url})
@@ -0,0 +1,27 @@
+package tasks
+
+import (
+	"net/http"
+
+	"proteggo_api/tools"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// Completes the posts written before some of their fields existed. It also runs when an instance starts, the task
+// lets it be run again without a deployment.
+func PostsBackfillTaskHandler(logger *logging.Logger, firestoreClient *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		backfilled, err := tools.BackfillPosts(c, firestoreClient)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"backfilled": backfilled,
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package tasks
+
+import (
+	"net/http"
+
+	"proteggo_api/tools"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// Rolls the changed reactions counters up into their posts for the listings, called every minute by the
+// reactions-rollup Cloud Scheduler job
+func ReactionsRollupTaskHandler(logger *logging.Logger, firestoreClient *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rolledUp, err := tools.RollUpReactionsCounts(c, firestoreClient)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"rolledUp": rolledUp,
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"proteggo_api/notifications"
+	"proteggo_api/search"
+	"proteggo_api/tools"
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/logging"
+	"firebase.google.com/go/messaging"
+	"github.com/gin-gonic/gin"
+)
+
+// Publishes the scheduled posts whose publish time has passed and notifies the client about each of them.
+// Called by the tasks created for scheduled posts and periodically by the scheduled-posts Cloud Scheduler job, which
+// catches up on the tasks which could not be created.
+func ScheduledPostsTaskHandler(logger *logging.Logger, messageClient *messaging.Client, firestoreClient *firestore.Client, postsIndex *search.PostsIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := time.Now()
+
+		docs, err := firestoreClient.Collection(types.FIREBASE_POSTS_COLLECTION).
+			Where(types.FIREBASE_POSTS_FIELDS_STATUS, "==", types.POST_STATUS_SCHEDULED).
+			Where(types.FIREBASE_POSTS_FIELDS_PUBLISH_AT, "<=", now).
+			Documents(c).GetAll()
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		publishedIds := []string{}
+		var failedIds []string
+
+		for _, doc := range docs {
+			published := false
+
+			// The post could have been edited since the query, so it is checked again inside the transaction
+			err := firestoreClient.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+				published = false
+
+				post, err := tools.GetFirestoreDocumentInTransaction(tx, firestoreClient, types.FIREBASE_POSTS_COLLECTION, doc.Ref.ID)
+				if err != nil || post == nil {
+					return err
+				}
+
+				publishAt, ok := post[types.FIREBASE_POSTS_FIELDS_PUBLISH_AT].(time.Time)
+				if post[types.FIREBASE_POSTS_FIELDS_STATUS] != types.POST_STATUS_SCHEDULED || !ok || publishAt.After(now) {
+					return nil
+				}
+
+				// Posts moved to the trash keep their status, they are published again only if they are restored
+				if tools.IsDocumentInTrash(post) {
+					return nil
+				}
+
+				// Images quarantined or moved to the trash since the post was scheduled keep it scheduled until they are
+				// reviewed, restored or replaced
+				imagesIds, _ := post[types.FIREBASE_POSTS_FIELDS_IMAGES_IDS].([]interface{})
+				for _, imageIdData := range imagesIds {
+					imageId, _ := imageIdData.(string)
+					image, err := tools.GetFirestoreDocumentInTransaction(tx, firestoreClient, types.FIREBASE_IMAGES_COLLECTION, imageId)
+					if err != nil {
+						return err
+					}
+
+					if image == nil || tools.IsDocumentInTrash(image) || !tools.IsImageModerationApproved(image) {
+						return fmt.Errorf("image %v can not be published", imageId)
+					}
+				}
+
+				// Publishing is recorded as a revision like the other changes, all reads have to be done before the writes
+				revision, _ := post[types.FIREBASE_POSTS_FIELDS_REVISION].(int64)
+				previousRevision, err := tools.GetFirestoreDocumentInTransaction(tx, firestoreClient, types.FIREBASE_POST_REVISIONS_COLLECTION, tools.GetPostRevisionId(doc.Ref.ID, revision))
+				if err != nil {
+					return err
+				}
+
+				// The post enters the feed at the time it is published, its creation time is kept
+				published = true
+				err = tx.Update(doc.Ref, []firestore.Update{
+					{Path: types.FIREBASE_POSTS_FIELDS_STATUS, Value: types.POST_STATUS_PUBLISHED},
+					{Path: types.FIREBASE_POSTS_FIELDS_PUBLISHED_AT, Value: firestore.ServerTimestamp},
+					{Path: types.FIREBASE_POSTS_FIELDS_UPDATED_AT, Value: firestore.ServerTimestamp},
+					{Path: types.FIREBASE_POSTS_FIELDS_REVISION, Value: revision + 1},
+					{Path: types.FIREBASE_POSTS_FIELDS_VERSION, Value: firestore.Increment(1)},
+				})
+				if err != nil {
+					return err
+				}
+
+				return createPublishedPostRevision(tx, firestoreClient, doc.Ref.ID, revision+1, post, previousRevision)
+			})
+
+			if err != nil {
+				failedIds = append(failedIds, doc.Ref.ID)
+				logger.Log(logging.Entry{
+					Severity: logging.Error,
+					Payload:  "Error publishing scheduled post " + doc.Ref.ID + ": " + err.Error(),
+					Labels:   map[string]string{"status": "error"},
+				})
+				continue
+			}
+
+			if !published {
+				continue
+			}
+
+			publishedIds = append(publishedIds, doc.Ref.ID)
+
+			// The index orders the posts by the time they are published
+			err = postsIndex.Sync(c, firestoreClient, doc.Ref.ID)
+			if err != nil {
+				logger.Log(logging.Entry{
+					Severity: logging.Warning,
+					Payload:  "Posts search index is stale until it is rebuilt: " + err.Error(),
+					Labels:   map[string]string{"status": "warning"},
+				})
+			}
+
+			notifications.SendNotificationToClient(c, messageClient, firestoreClient, logger, types.NotificationMessage{
+				PostId:     doc.Ref.ID,
+				PostStatus: types.POST_STATUS_PUBLISHED,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"publishedIds": publishedIds,
+			"failedIds":    failedIds,
+		})
+	}
+}
+
+// Records the published post as a revision within the transaction. Only the status changed since the previous
+// revision, which is copied with its obscured overlays. Posts scheduled before revisions were recorded have none, their
+// revision is read from the post and has no obscured overlays versions. The author is recorded as its creator, the
+// publication was chosen when the post was scheduled.
+func createPublishedPostRevision(tx *firestore.Transaction, firestoreClient *firestore.Client, postId string, revision int64, post map[string]interface{}, previousRevision map[string]interface{}) error {
+	data := map[string]interface{}{
+		types.FIREBASE_POST_REVISIONS_FIELDS_BODY:                  post[types.FIREBASE_POSTS_FIELDS_BODY],
+		types.FIREBASE_POST_REVISIONS_FIELDS_VISIBILITY:            post[types.FIREBASE_POSTS_FIELDS_VISIBILITY],
+		types.FIREBASE_POST_REVISIONS_FIELDS_HASH_TAGS_IDS:         post[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_IDS],
+		types.FIREBASE_POST_REVISIONS_FIELDS_HASH_TAGS_VALUES:      post[types.FIREBASE_POSTS_FIELDS_HASH_TAGS_VALUES],
+		types.FIREBASE_POST_REVISIONS_FIELDS_IMAGES_IDS:            post[types.FIREBASE_POSTS_FIELDS_IMAGES_IDS],
+		types.FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAYS_IDS: post[types.FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_IDS],
+	}
+
+	for field, value := range previousRevision {
+		data[field] = value
+	}
+
+	data[types.FIREBASE_POST_REVISIONS_FIELDS_POST_ID] = postId
+	data[types.FIREBASE_POST_REVISIONS_FIELDS_REVISION] = revision
+	data[types.FIREBASE_POST_REVISIONS_FIELDS_STATUS] = types.POST_STATUS_PUBLISHED
+	data[types.FIREBASE_POST_REVISIONS_FIELDS_CREATED_AT] = firestore.ServerTimestamp
+	data[types.FIREBASE_POST_REVISIONS_FIELDS_CREATED_BY] = post[types.FIREBASE_POSTS_FIELDS_AUTHOR_UID]
+
+	return tx.Create(firestoreClient.Collection(types.FIREBASE_POST_REVISIONS_COLLECTION).Doc(tools.GetPostRevisionId(postId, revision)), data)
+}
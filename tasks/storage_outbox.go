@@ -0,0 +1,35 @@
+package tasks
+
+import (
+	"net/http"
+
+	"proteggo_api/tools"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// Retries the storage deletions left over by failed attempts, called every hour by the storage-outbox Cloud Scheduler job
+func StorageOutboxTaskHandler(logger *logging.Logger, storage *storage.Client, firestoreClient *firestore.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pending, err := tools.ProcessStorageOutbox(c, firestoreClient, storage)
+		if err != nil {
+			tools.LogError(logger, c, err)
+			return
+		}
+
+		if pending > 0 {
+			logger.Log(logging.Entry{
+				Severity: logging.Warning,
+				Payload:  "Some storage deletions are still pending",
+				Labels:   map[string]string{"status": "warning"},
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"pending": pending,
+		})
+	}
+}
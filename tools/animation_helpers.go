@@ -0,0 +1,413 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"strconv"
+
+	"proteggo_api/types"
+
+	"cloud.google.com/go/vision/v2/apiv1/visionpb"
+)
+
+// Minimum overlap of two face boxes on consecutive sampled frames to be considered the same face
+const facesTrackingMinIoU = 0.2
+
+var ErrAnimationTooLarge = errors.New("animation too large")
+
+// Composes the frames of an animated GIF into full images, applying the disposal method of each frame. Animations
+// whose composed frames would take more than the allowed memory are refused with ErrAnimationTooLarge.
+func ComposeGifFrames(g *gif.GIF) ([]*image.RGBA, error) {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() && len(g.Image) > 0 {
+		bounds = g.Image[0].Bounds()
+	}
+
+	if int64(len(g.Image))*int64(bounds.Dx())*int64(bounds.Dy()) > types.ANIMATION_MAX_COMPOSED_PIXELS {
+		return nil, ErrAnimationTooLarge
+	}
+
+	canvas := image.NewRGBA(bounds)
+	frames := make([]*image.RGBA, 0, len(g.Image))
+
+	for i, frame := range g.Image {
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		frames = append(frames, cloneRGBA(canvas))
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return frames, nil
+}
+
+// Returns the indexes of the frames faces are detected on, always including the first and the last frame
+func SampleFramesIndexes(framesCount int, maxSamples int) []int {
+	if framesCount == 0 {
+		return nil
+	}
+
+	step := max(1, (framesCount+maxSamples-1)/maxSamples)
+
+	samples := []int{}
+	for i := 0; i < framesCount; i += step {
+		samples = append(samples, i)
+	}
+
+	if samples[len(samples)-1] != framesCount-1 {
+		samples = append(samples, framesCount-1)
+	}
+
+	return samples
+}
+
+// Detects only the bounding boxes of the faces and of the sensitive text regions, without creating the face images.
+// Both are obscured the same way, so they are tracked together.
+func DetectFacesBoxesInImage(ctx context.Context, img image.Image, maxResults int32) ([][]map[string]int, error) {
+	res, err := annotateImage(ctx, img, []*visionpb.Feature{
+		{
+			Type:       visionpb.Feature_FACE_DETECTION,
+			MaxResults: maxResults,
+		},
+		{
+			Type: visionpb.Feature_TEXT_DETECTION,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	boxes := [][]map[string]int{}
+	for _, face := range res.GetFaceAnnotations() {
+		vertices := boundingBoxVertices(face.GetBoundingPoly().GetVertices())
+		if len(vertices) > 0 {
+			boxes = append(boxes, vertices)
+		}
+	}
+
+	regions, err := getRegionsFromTextAnnotation(res.GetFullTextAnnotation())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, region := range regions {
+		boxes = append(boxes, region.Vertices)
+	}
+
+	return boxes, nil
+}
+
+// Links the faces detected on the sampled frames into tracks, each track keeps the box of its face on the samples only
+func LinkFacesAcrossSamples(samples []int, samplesBoxes [][][]map[string]int) []types.FaceTrack {
+	type activeTrack struct {
+		index    int
+		vertices []map[string]int
+	}
+
+	tracks := []types.FaceTrack{}
+	active := []*activeTrack{}
+
+	for i, sample := range samples {
+		boxes := samplesBoxes[i]
+		used := make([]bool, len(boxes))
+		nextActive := []*activeTrack{}
+
+		for _, track := range active {
+			// Find the box overlapping the most with the last box of the track
+			best := -1
+			bestIoU := facesTrackingMinIoU
+			for j, box := range boxes {
+				if used[j] {
+					continue
+				}
+
+				if iou := boxesIoU(track.vertices, box); iou > bestIoU {
+					best = j
+					bestIoU = iou
+				}
+			}
+
+			// The face is gone, its track ends
+			if best < 0 {
+				continue
+			}
+
+			used[best] = true
+			tracks[track.index].Frames = append(tracks[track.index].Frames, types.FaceTrackFrame{Frame: sample, Vertices: boxes[best]})
+
+			track.vertices = boxes[best]
+			nextActive = append(nextActive, track)
+		}
+
+		// Faces not matched to any track start a new one
+		for j, box := range boxes {
+			if used[j] {
+				continue
+			}
+
+			tracks = append(tracks, types.FaceTrack{
+				Id:     strconv.Itoa(len(tracks)),
+				Frames: []types.FaceTrackFrame{{Frame: sample, Vertices: box}},
+			})
+			nextActive = append(nextActive, &activeTrack{index: len(tracks) - 1, vertices: box})
+		}
+
+		active = nextActive
+	}
+
+	return tracks
+}
+
+// Interpolates the boxes of the tracks linked on the samples on the frames in between. A face is kept obscured over
+// the whole gap to the neighbouring samples, as it could appear or leave anywhere in it.
+func InterpolateFaceTracks(samples []int, tracks []types.FaceTrack, framesCount int) []types.FaceTrack {
+	samplesIndexes := map[int]int{}
+	for i, sample := range samples {
+		samplesIndexes[sample] = i
+	}
+
+	interpolated := []types.FaceTrack{}
+	for _, track := range tracks {
+		if len(track.Frames) == 0 {
+			continue
+		}
+
+		result := types.FaceTrack{Id: track.Id}
+
+		// The face appears right after the previous sample
+		first := track.Frames[0]
+		firstFrame := 0
+		if i := samplesIndexes[first.Frame]; i > 0 {
+			firstFrame = samples[i-1] + 1
+		}
+
+		for frame := firstFrame; frame <= first.Frame; frame++ {
+			result.Frames = append(result.Frames, types.FaceTrackFrame{Frame: frame, Vertices: first.Vertices})
+		}
+
+		for k := 1; k < len(track.Frames); k++ {
+			from, to := track.Frames[k-1], track.Frames[k]
+			for frame := from.Frame + 1; frame <= to.Frame; frame++ {
+				t := float64(frame-from.Frame) / float64(to.Frame-from.Frame)
+				result.Frames = append(result.Frames, types.FaceTrackFrame{Frame: frame, Vertices: interpolateVertices(from.Vertices, to.Vertices, t)})
+			}
+		}
+
+		// The face is kept until the frame before the next sample, or the end of the animation
+		last := track.Frames[len(track.Frames)-1]
+		lastFrame := framesCount - 1
+		if i := samplesIndexes[last.Frame]; i < len(samples)-1 {
+			lastFrame = samples[i+1] - 1
+		}
+
+		for frame := last.Frame + 1; frame <= lastFrame; frame++ {
+			result.Frames = append(result.Frames, types.FaceTrackFrame{Frame: frame, Vertices: last.Vertices})
+		}
+
+		interpolated = append(interpolated, result)
+	}
+
+	return interpolated
+}
+
+// Draws the obscuring rectangles of the tracks on each frame and encodes the frames back into an animated GIF
+func ObscureFacesInGif(g *gif.GIF, frames []*image.RGBA, tracks []types.FaceTrack) ([]byte, error) {
+	if len(frames) != len(g.Image) {
+		return nil, errors.New("frames count does not match the gif")
+	}
+
+	framesVertices := map[int][][]map[string]int{}
+	for _, track := range tracks {
+		for _, trackFrame := range track.Frames {
+			framesVertices[trackFrame.Frame] = append(framesVertices[trackFrame.Frame], trackFrame.Vertices)
+		}
+	}
+
+	obscured := &gif.GIF{
+		Delay:     g.Delay,
+		LoopCount: g.LoopCount,
+	}
+
+	for i, frame := range frames {
+		frameCopy := cloneRGBA(frame)
+		for _, vertices := range framesVertices[i] {
+			drawObscuringRectangle(frameCopy, vertices)
+		}
+
+		// Every frame is written whole, so the original disposal methods do not apply anymore
+		paletted := image.NewPaletted(frameCopy.Bounds(), paletteWithBlack(g.Image[i].Palette))
+		draw.FloydSteinberg.Draw(paletted, frameCopy.Bounds(), frameCopy, frameCopy.Bounds().Min)
+
+		obscured.Image = append(obscured.Image, paletted)
+		obscured.Disposal = append(obscured.Disposal, gif.DisposalNone)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, obscured); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func ConvertFaceTracksToFirestore(tracks []types.FaceTrack) []map[string]interface{} {
+	result := []map[string]interface{}{}
+	for _, track := range tracks {
+		frames := []map[string]interface{}{}
+		for _, frame := range track.Frames {
+			frames = append(frames, map[string]interface{}{
+				types.FIREBASE_IMAGES_FIELDS_FACES_TRACKS_FRAME:    frame.Frame,
+				types.FIREBASE_IMAGES_FIELDS_FACES_TRACKS_VERTICES: frame.Vertices,
+			})
+		}
+
+		result = append(result, map[string]interface{}{
+			types.FIREBASE_IMAGES_FIELDS_FACES_TRACKS_ID:     track.Id,
+			types.FIREBASE_IMAGES_FIELDS_FACES_TRACKS_FRAMES: frames,
+		})
+	}
+
+	return result
+}
+
+func ConvertFirestoreFaceTracks(data interface{}) ([]types.FaceTrack, error) {
+	tracksData, ok := data.([]interface{})
+	if !ok {
+		return nil, errors.New("error casting faces tracks to []interface{}")
+	}
+
+	tracks := []types.FaceTrack{}
+	for _, trackData := range tracksData {
+		trackMap, ok := trackData.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("error casting face track to map[string]interface{}")
+		}
+
+		id, ok := trackMap[types.FIREBASE_IMAGES_FIELDS_FACES_TRACKS_ID].(string)
+		if !ok {
+			return nil, errors.New("error casting face track id to string")
+		}
+
+		framesData, ok := trackMap[types.FIREBASE_IMAGES_FIELDS_FACES_TRACKS_FRAMES].([]interface{})
+		if !ok {
+			return nil, errors.New("error casting face track frames to []interface{}")
+		}
+
+		track := types.FaceTrack{Id: id}
+		for _, frameData := range framesData {
+			frameMap, ok := frameData.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("error casting face track frame to map[string]interface{}")
+			}
+
+			frame, ok := frameMap[types.FIREBASE_IMAGES_FIELDS_FACES_TRACKS_FRAME].(int64) // Firestore uses int64 for numbers
+			if !ok {
+				return nil, errors.New("error casting face track frame to int64")
+			}
+
+			vertices, err := ConvertFirestoreVertices(frameMap[types.FIREBASE_IMAGES_FIELDS_FACES_TRACKS_VERTICES])
+			if err != nil {
+				return nil, err
+			}
+
+			track.Frames = append(track.Frames, types.FaceTrackFrame{Frame: int(frame), Vertices: vertices})
+		}
+
+		tracks = append(tracks, track)
+	}
+
+	return tracks, nil
+}
+
+func ConvertFirestoreSampledFrames(data interface{}) ([]int, error) {
+	samplesData, ok := data.([]interface{})
+	if !ok {
+		return nil, errors.New("error casting sampled frames to []interface{}")
+	}
+
+	samples := []int{}
+	for _, sampleData := range samplesData {
+		sample, ok := sampleData.(int64) // Firestore uses int64 for numbers
+		if !ok {
+			return nil, errors.New("error casting sampled frame to int64")
+		}
+
+		samples = append(samples, int(sample))
+	}
+
+	return samples, nil
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	clone := image.NewRGBA(img.Bounds())
+	copy(clone.Pix, img.Pix)
+	return clone
+}
+
+// Copies the palette and adds black to it if there is room, so the obscuring rectangles stay black
+func paletteWithBlack(palette color.Palette) color.Palette {
+	result := append(color.Palette{}, palette...)
+	if len(result) == 0 {
+		result = append(result, color.Transparent)
+	}
+
+	black := color.RGBA{0, 0, 0, 255}
+	for _, c := range result {
+		if r, g, b, a := c.RGBA(); r == 0 && g == 0 && b == 0 && a == 0xffff {
+			return result
+		}
+	}
+
+	if len(result) < 256 {
+		result = append(result, black)
+	}
+
+	return result
+}
+
+func interpolateVertices(from []map[string]int, to []map[string]int, t float64) []map[string]int {
+	result := make([]map[string]int, len(from))
+	for i := range from {
+		result[i] = map[string]int{
+			"x": from[i]["x"] + int(float64(to[i]["x"]-from[i]["x"])*t),
+			"y": from[i]["y"] + int(float64(to[i]["y"]-from[i]["y"])*t),
+		}
+	}
+
+	return result
+}
+
+// Intersection over union of two boxes given by their top-left and bottom-right vertices
+func boxesIoU(a []map[string]int, b []map[string]int) float64 {
+	rectA := image.Rect(a[0]["x"], a[0]["y"], a[2]["x"], a[2]["y"])
+	rectB := image.Rect(b[0]["x"], b[0]["y"], b[2]["x"], b[2]["y"])
+
+	intersection := rectA.Intersect(rectB)
+	if intersection.Empty() {
+		return 0
+	}
+
+	intersectionArea := intersection.Dx() * intersection.Dy()
+	unionArea := rectA.Dx()*rectA.Dy() + rectB.Dx()*rectB.Dy() - intersectionArea
+
+	return float64(intersectionArea) / float64(unionArea)
+}
@@ -0,0 +1,45 @@
+package tools
+
+import (
+	"errors"
+
+	"proteggo_api/types"
+
+	"firebase.google.com/go/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// Returns the token verified by the auth middleware, nil when the request is not authenticated
+func GetAuthToken(c *gin.Context) *auth.Token {
+	user, exists := c.Get("user")
+	if !exists {
+		return nil
+	}
+
+	token, _ := user.(*auth.Token)
+	return token
+}
+
+// Returns whether the authenticated user has the admin claim
+func IsAdminUser(c *gin.Context) bool {
+	token := GetAuthToken(c)
+	if token == nil {
+		return false
+	}
+
+	admin, ok := token.Claims["admin"].(bool)
+	return ok && admin
+}
+
+// Returns whether the authenticated user can write posts, admins are contributors too
+func IsContributorUser(c *gin.Context) bool {
+	token := GetAuthToken(c)
+	if token == nil {
+		return false
+	}
+
+	contributor, ok := token.Claims[types.AUTH_CLAIM_CONTRIBUTOR].(bool)
+	return (ok && contributor) || IsAdminUser(c)
+}
+
+var ErrForbidden = errors.New("you can only change your own posts and comments")
@@ -0,0 +1,23 @@
+package tools
+
+import (
+	"context"
+
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Gets the comments settings from Firestore, comments are shown without approval until an admin sets them
+func GetCommentsSettings(c context.Context, client *firestore.Client) (types.CommentsSettings, error) {
+	settings := types.CommentsSettings{}
+
+	doc, err := GetFirestoreDocument(c, client, types.FIREBASE_SETTINGS_COLLECTION, types.FIREBASE_SETTINGS_COMMENTS_DOCUMENT)
+	if err != nil {
+		return settings, err
+	}
+
+	settings.RequireApproval, _ = doc[types.FIREBASE_COMMENTS_SETTINGS_FIELDS_REQUIRE_APPROVAL].(bool)
+
+	return settings, nil
+}
@@ -0,0 +1,134 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Posts, images and faces share the name of the field counting their changes
+const versionField = types.FIREBASE_POSTS_FIELDS_VERSION
+
+var ErrPreconditionRequired = errors.New("the If-Match header is required")
+var ErrPreconditionFailed = errors.New("the resource was changed since it was read")
+var ErrConflict = errors.New("the resource already exists")
+
+// Returns the version of a post, image or face document, documents written before versions existed are version 0
+func GetDocumentVersion(data map[string]interface{}) int64 {
+	version, _ := data[versionField].(int64)
+	return version
+}
+
+// Formats the entity tag of a document, the id is part of it so a single If-Match header can list several documents
+func FormatETag(id string, version int64) string {
+	return fmt.Sprintf("\"%s:%d\"", id, version)
+}
+
+// Sets the ETag header from the version of a document
+func SetETagHeader(c *gin.Context, id string, data map[string]interface{}) {
+	c.Header("ETag", FormatETag(id, GetDocumentVersion(data)))
+}
+
+// Parses the If-Match header into the expected version of each document
+func ParseIfMatch(c *gin.Context) (map[string]int64, error) {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return nil, ErrPreconditionRequired
+	}
+
+	versions := map[string]int64{}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+
+		// Weak entity tags can not be used for If-Match
+		if len(tag) < 2 || !strings.HasPrefix(tag, "\"") || !strings.HasSuffix(tag, "\"") {
+			return nil, fmt.Errorf("invalid entity tag in If-Match: %v", tag)
+		}
+
+		tag = tag[1 : len(tag)-1]
+		separator := strings.LastIndex(tag, ":")
+		if separator < 0 {
+			return nil, fmt.Errorf("invalid entity tag in If-Match: %v", tag)
+		}
+
+		version, err := strconv.ParseInt(tag[separator+1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entity tag in If-Match: %v", tag)
+		}
+
+		versions[tag[:separator]] = version
+	}
+
+	return versions, nil
+}
+
+// Checks the version of a document against the If-Match header
+func CheckDocumentVersion(expected map[string]int64, id string, data map[string]interface{}) error {
+	version, ok := expected[id]
+	if !ok {
+		return fmt.Errorf("%w: no entity tag for %v", ErrPreconditionRequired, id)
+	}
+
+	if version != GetDocumentVersion(data) {
+		return fmt.Errorf("%w: %v", ErrPreconditionFailed, id)
+	}
+
+	return nil
+}
+
+// Updates the fields of a document if its version matches the If-Match header and increments the version.
+// The update is conditioned on the update time of the checked document, so a change in between fails it too.
+func UpdateFirestoreDocumentIfMatch(c context.Context, client *firestore.Client, collection, documentName string, expected map[string]int64, data map[string]interface{}) error {
+	docRef := client.Collection(collection).Doc(documentName)
+
+	doc, err := docRef.Get(c)
+	if err != nil {
+		return err
+	}
+
+	err = CheckDocumentVersion(expected, documentName, doc.Data())
+	if err != nil {
+		return err
+	}
+
+	updates := ConvertToFirestoreUpdates(data)
+	updates = append(updates, firestore.Update{Path: versionField, Value: firestore.Increment(1)})
+
+	_, err = docRef.Update(c, updates, firestore.LastUpdateTime(doc.UpdateTime))
+	return convertPreconditionError(documentName, err)
+}
+
+// Deletes a document if its version matches the If-Match header
+func DeleteFirestoreDocumentIfMatch(c context.Context, client *firestore.Client, collection, documentName string, expected map[string]int64) error {
+	docRef := client.Collection(collection).Doc(documentName)
+
+	doc, err := docRef.Get(c)
+	if err != nil {
+		return err
+	}
+
+	err = CheckDocumentVersion(expected, documentName, doc.Data())
+	if err != nil {
+		return err
+	}
+
+	_, err = docRef.Delete(c, firestore.LastUpdateTime(doc.UpdateTime))
+	return convertPreconditionError(documentName, err)
+}
+
+func convertPreconditionError(documentName string, err error) error {
+	if status.Code(err) == codes.FailedPrecondition {
+		return fmt.Errorf("%w: %v", ErrPreconditionFailed, documentName)
+	}
+
+	return err
+}
@@ -0,0 +1,96 @@
+package tools
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseIfMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		expected map[string]int64
+		err      error
+	}{
+		{
+			name:     "single tag",
+			header:   `"post1:3"`,
+			expected: map[string]int64{"post1": 3},
+		},
+		{
+			name:     "several tags with spaces",
+			header:   `"post1:3", "image1:0" ,"face1:12"`,
+			expected: map[string]int64{"post1": 3, "image1": 0, "face1": 12},
+		},
+		{
+			name:     "id containing a colon",
+			header:   `"a:b:7"`,
+			expected: map[string]int64{"a:b": 7},
+		},
+		{
+			name:   "missing header",
+			header: "",
+			err:    ErrPreconditionRequired,
+		},
+		{
+			name:   "weak tag",
+			header: `W/"post1:3"`,
+		},
+		{
+			name:   "unquoted tag",
+			header: `post1:3`,
+		},
+		{
+			name:   "single quote",
+			header: `"`,
+		},
+		{
+			name:   "tag without version",
+			header: `"post1"`,
+		},
+		{
+			name:   "version which is not a number",
+			header: `"post1:three"`,
+		},
+		{
+			name:   "empty tag in the list",
+			header: `"post1:3",`,
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPatch, "/", nil)
+			if test.header != "" {
+				c.Request.Header.Set("If-Match", test.header)
+			}
+
+			versions, err := ParseIfMatch(c)
+
+			if test.expected == nil {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", versions)
+				}
+				if test.err != nil && !errors.Is(err, test.err) {
+					t.Fatalf("expected %v, got %v", test.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(versions, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, versions)
+			}
+		})
+	}
+}
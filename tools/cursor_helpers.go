@@ -0,0 +1,43 @@
+package tools
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"proteggo_api/types"
+)
+
+func EncodePostsCursor(createdAt time.Time, id string, direction string) (string, error) {
+	data, err := json.Marshal(types.PostsCursor{
+		CreatedAt: createdAt,
+		Id:        id,
+		Direction: direction,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func DecodePostsCursor(token string) (types.PostsCursor, error) {
+	var cursor types.PostsCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cursor, errors.New("invalid cursor")
+	}
+
+	err = json.Unmarshal(data, &cursor)
+	if err != nil || cursor.Id == "" {
+		return cursor, errors.New("invalid cursor")
+	}
+
+	if cursor.Direction != types.POSTS_CURSOR_DIRECTION_NEXT && cursor.Direction != types.POSTS_CURSOR_DIRECTION_PREV {
+		return cursor, errors.New("invalid cursor direction")
+	}
+
+	return cursor, nil
+}
@@ -0,0 +1,92 @@
+package tools
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"proteggo_api/types"
+)
+
+func TestEncodeDecodePostsCursor(t *testing.T) {
+	publishedAt := time.Date(2024, 5, 17, 10, 30, 15, 123456789, time.UTC)
+
+	tests := []struct {
+		name      string
+		id        string
+		direction string
+	}{
+		{
+			name:      "next page",
+			id:        "post1",
+			direction: types.POSTS_CURSOR_DIRECTION_NEXT,
+		},
+		{
+			name:      "previous page",
+			id:        "post2",
+			direction: types.POSTS_CURSOR_DIRECTION_PREV,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token, err := EncodePostsCursor(publishedAt, test.id, test.direction)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			cursor, err := DecodePostsCursor(token)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !cursor.CreatedAt.Equal(publishedAt) || cursor.Id != test.id || cursor.Direction != test.direction {
+				t.Fatalf("expected %v %v %v, got %+v", publishedAt, test.id, test.direction, cursor)
+			}
+		})
+	}
+}
+
+func TestDecodePostsCursorInvalid(t *testing.T) {
+	encode := func(data string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(data))
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{
+			name:  "empty token",
+			token: "",
+		},
+		{
+			name:  "not base64",
+			token: "not a cursor!",
+		},
+		{
+			name:  "not json",
+			token: encode("post1"),
+		},
+		{
+			name:  "missing id",
+			token: encode(`{"direction":"next"}`),
+		},
+		{
+			name:  "missing direction",
+			token: encode(`{"id":"post1"}`),
+		},
+		{
+			name:  "unknown direction",
+			token: encode(`{"id":"post1","direction":"up"}`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := DecodePostsCursor(test.token); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package tools
 
 import (
+	"errors"
 	"net/http"
 
 	"cloud.google.com/go/logging"
@@ -14,7 +15,19 @@ func LogError(logger *logging.Logger, c *gin.Context, err error) {
 		Labels:   map[string]string{"status": "error"},
 	})
 
-	c.JSON(http.StatusBadRequest, gin.H{
+	// Version conflicts and changes to the posts of other users have their own status, so clients know to read the resource again
+	status := http.StatusBadRequest
+	if errors.Is(err, ErrPreconditionFailed) {
+		status = http.StatusPreconditionFailed
+	} else if errors.Is(err, ErrPreconditionRequired) {
+		status = http.StatusPreconditionRequired
+	} else if errors.Is(err, ErrForbidden) {
+		status = http.StatusForbidden
+	} else if errors.Is(err, ErrConflict) {
+		status = http.StatusConflict
+	}
+
+	c.JSON(status, gin.H{
 		"error": err.Error(),
 	})
 }
@@ -2,21 +2,21 @@ package tools
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/jpeg"
 	"image/png"
-	"log"
 	"proteggo_api/types"
 
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/storage"
-	vision "cloud.google.com/go/vision/v2/apiv1"
 	"cloud.google.com/go/vision/v2/apiv1/visionpb"
 	"github.com/disintegration/imaging"
 	"github.com/gin-gonic/gin"
+	_ "golang.org/x/image/webp" // Import for side effects, the originals are stored as WebP
 	"google.golang.org/api/iterator"
 )
 
@@ -53,131 +53,84 @@ func DetectFaceEmotions(face *visionpb.FaceAnnotation) string {
 	return maxEmotion
 }
 
-func DetectFacesInImage(ctx *gin.Context, firestoreClient *firestore.Client, storage *storage.Client, img image.Image, maxResults int32) ([]types.Face, error) {
-	client, err := vision.NewImageAnnotatorClient(ctx)
-	if err != nil {
-		return nil, err
-	}
-	defer client.Close()
-
-	// Encode the image.Image (img) as a JPEG into a bytes.Buffer
-	var buf bytes.Buffer
-	if err := jpeg.Encode(&buf, img, nil); err != nil {
-		return nil, err
-	}
-
-	// Create the request.
-	req := &visionpb.BatchAnnotateImagesRequest{
-		Requests: []*visionpb.AnnotateImageRequest{
-			{
-				Image: &visionpb.Image{
-					Content: buf.Bytes(),
-				},
-				Features: []*visionpb.Feature{
-					{
-						Type:       visionpb.Feature_FACE_DETECTION,
-						MaxResults: maxResults,
-					},
-				},
-			},
-		},
-	}
-
-	// Execute the request.
-	resp, err := client.BatchAnnotateImages(ctx, req)
-	if err != nil {
-		return nil, err
-	}
-
-	// Handle the response.
+// Crops and uploads the detected faces, returns them with their landmarks and angles
+func getFacesFromAnnotations(ctx *gin.Context, firestoreClient *firestore.Client, storage *storage.Client, img image.Image, annotations []*visionpb.FaceAnnotation) ([]types.Face, error) {
 	faces := []types.Face{}
 
-	for _, res := range resp.Responses {
-		if err := res.GetError(); err != nil {
-			log.Printf("Response error: %v", err)
-			continue
-		}
+	for _, face := range annotations {
+		// Create image with only the face
+		vertices := face.GetBoundingPoly().GetVertices()
+		faceImg := CreateFaceImage(img, vertices)
 
-		if len(res.FaceAnnotations) == 0 {
-			continue
+		// Generate the URL for the face image
+		var buf bytes.Buffer
+		err := jpeg.Encode(&buf, faceImg, nil)
+		if err != nil {
+			return nil, err
 		}
+		faceImgBytes := buf.Bytes()
 
-		// Print detected face annotations.
-		for _, face := range res.FaceAnnotations {
-			// Create image with only the face
-			vertices := face.GetBoundingPoly().GetVertices()
-			faceImg := CreateFaceImage(img, vertices)
-
-			// Generate the URL for the face image
-			var buf bytes.Buffer
-			err := jpeg.Encode(&buf, faceImg, nil)
-			if err != nil {
-				return nil, err
-			}
-			faceImgBytes := buf.Bytes()
-
-			// Generate random name for the face image
-			randomFaceName, err := GenerateRandomName()
-			if err != nil {
-				return nil, err
-			}
+		// Generate random name for the face image
+		randomFaceName, err := GenerateRandomName()
+		if err != nil {
+			return nil, err
+		}
 
-			faceStoragePath := types.FIREBASE_STORAGE_FACES_FOLDER + randomFaceName + ".jpg"
-			url, err := GenerateImageUrl(ctx, firestoreClient, storage, faceImgBytes, types.FIREBASE_STORAGE_BUCKET, faceStoragePath)
+		faceStoragePath := types.FIREBASE_STORAGE_FACES_FOLDER + randomFaceName + ".jpg"
+		url, err := GenerateImageUrl(ctx, firestoreClient, storage, faceImgBytes, types.FIREBASE_STORAGE_BUCKET, faceStoragePath)
 
-			if err != nil {
-				return nil, err
-			}
+		if err != nil {
+			return nil, err
+		}
 
-			// Detect face emotions
-			emotion := DetectFaceEmotions(face)
+		// Detect face emotions
+		emotion := DetectFaceEmotions(face)
 
-			// Get the bounding box coordinates
-			verticesData := make([]map[string]int, len(vertices))
-			for i, vertex := range vertices {
-				verticesData[i] = map[string]int{
-					"x": int(vertex.GetX()),
-					"y": int(vertex.GetY()),
-				}
+		// Get the bounding box coordinates
+		verticesData := make([]map[string]int, len(vertices))
+		for i, vertex := range vertices {
+			verticesData[i] = map[string]int{
+				"x": int(vertex.GetX()),
+				"y": int(vertex.GetY()),
 			}
+		}
 
-			// Get the landmarks
-			landmarks := face.GetLandmarks()
-			landmarkData := make([]map[string]interface{}, len(landmarks))
-			for i, landmark := range landmarks {
-				landmarkData[i] = map[string]interface{}{
-					"type": landmark.GetType().String(),
-					"position": map[string]float32{
-						"x": landmark.GetPosition().GetX(),
-						"y": landmark.GetPosition().GetY(),
-						"z": landmark.GetPosition().GetZ(),
-					},
-				}
+		// Get the landmarks
+		landmarks := face.GetLandmarks()
+		landmarkData := make([]map[string]interface{}, len(landmarks))
+		for i, landmark := range landmarks {
+			landmarkData[i] = map[string]interface{}{
+				"type": landmark.GetType().String(),
+				"position": map[string]float32{
+					"x": landmark.GetPosition().GetX(),
+					"y": landmark.GetPosition().GetY(),
+					"z": landmark.GetPosition().GetZ(),
+				},
 			}
+		}
 
-			// Get the angles
-			rollAngle := face.GetRollAngle()
-			panAngle := face.GetPanAngle()
-			tiltAngle := face.GetTiltAngle()
-
-			// Save these coordinates along with the face data
-			faceName, err := GenerateRandomName()
-			if err != nil {
-				return nil, err
-			}
+		// Get the angles
+		rollAngle := face.GetRollAngle()
+		panAngle := face.GetPanAngle()
+		tiltAngle := face.GetTiltAngle()
 
-			faces = append(faces, types.Face{
-				Id:          faceName,
-				Url:         url,
-				StoragePath: faceStoragePath,
-				Emotion:     emotion,
-				Vertices:    verticesData,
-				Landmarks:   landmarkData,
-				RollAngle:   rollAngle,
-				PanAngle:    panAngle,
-				TiltAngle:   tiltAngle,
-			})
+		// Save these coordinates along with the face data
+		faceName, err := GenerateRandomName()
+		if err != nil {
+			return nil, err
 		}
+
+		faces = append(faces, types.Face{
+			Id:          faceName,
+			Url:         url,
+			StoragePath: faceStoragePath,
+			Emotion:     emotion,
+			Vertices:    verticesData,
+			Landmarks:   landmarkData,
+			RollAngle:   rollAngle,
+			PanAngle:    panAngle,
+			TiltAngle:   tiltAngle,
+		})
 	}
 
 	return faces, nil
@@ -235,12 +188,7 @@ func ObscureFacesInImage(ctx *gin.Context, firestoreClient *firestore.Client, st
 
 	// Create a black rectangle for each face
 	for _, face := range facesToObscure {
-		// Get the vertices of the face
-		vertices := face.Vertices
-
-		// Create a black rectangle for the face
-		black := color.RGBA{0, 0, 0, 255}
-		draw.Draw(imgCopy, image.Rect(vertices[0]["x"], vertices[0]["y"], vertices[2]["x"], vertices[2]["y"]), &image.Uniform{black}, image.Point{}, draw.Over)
+		drawObscuringRectangle(imgCopy, face.Vertices)
 	}
 
 	// Generate the URL for the face image
@@ -260,6 +208,43 @@ func ObscureFacesInImage(ctx *gin.Context, firestoreClient *firestore.Client, st
 	return overlayUrl, nil
 }
 
+// Draws the obscured overlay over the original image and encodes the result as a JPEG. The overlay is drawn at the size
+// the client displayed the image, so it is scaled to the original if the sizes differ.
+func RenderObscuredImage(ctx context.Context, storage *storage.Client, imageStoragePath string, overlayStoragePath string) ([]byte, error) {
+	img, err := GetImageFromStorage(imageStoragePath, storage, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	overlay, err := GetImageFromStorage(overlayStoragePath, storage, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	if overlay.Bounds().Dx() != bounds.Dx() || overlay.Bounds().Dy() != bounds.Dy() {
+		overlay = imaging.Resize(overlay, bounds.Dx(), bounds.Dy(), imaging.NearestNeighbor)
+	}
+
+	rendered := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rendered, rendered.Bounds(), img, bounds.Min, draw.Src)
+	draw.Draw(rendered, rendered.Bounds(), overlay, overlay.Bounds().Min, draw.Over)
+
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, rendered, &jpeg.Options{Quality: 90})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Draws a black rectangle over the face
+func drawObscuringRectangle(img draw.Image, vertices []map[string]int) {
+	black := color.RGBA{0, 0, 0, 255}
+	draw.Draw(img, image.Rect(vertices[0]["x"], vertices[0]["y"], vertices[2]["x"], vertices[2]["y"]), &image.Uniform{black}, image.Point{}, draw.Over)
+}
+
 func GetFacesVertices(imageId string, facesIds []string, c *gin.Context, firestoreClient *firestore.Client) ([]types.FaceVertices, error) {
 	if len(facesIds) == 0 {
 		return nil, nil
@@ -294,42 +279,51 @@ func GetFacesVertices(imageId string, facesIds []string, c *gin.Context, firesto
 			return nil, errors.New("error casting face image id to string")
 		}
 
-		verticesData := doc.Data()[types.FIREBASE_FACES_FIELDS_VERTICES]
-		vertices, ok := verticesData.([]interface{})
-		if !ok {
-			return nil, errors.New("error casting vertices to []interface{}")
+		verticesMap, err := ConvertFirestoreVertices(doc.Data()[types.FIREBASE_FACES_FIELDS_VERTICES])
+		if err != nil {
+			return nil, err
 		}
 
-		var verticesMap []map[string]int
+		faceVertices = append(faceVertices, types.FaceVertices{
+			Id:       id,
+			ImageId:  imageId,
+			Vertices: verticesMap,
+		})
+	}
+
+	return faceVertices, nil
+}
 
-		for _, vertex := range vertices {
-			vertexMap, ok := vertex.(map[string]interface{})
-			if !ok {
-				return nil, errors.New("error casting vertex to map[string]interface{}")
-			}
+// Converts vertices read from Firestore back to the x, y maps
+func ConvertFirestoreVertices(verticesData interface{}) ([]map[string]int, error) {
+	vertices, ok := verticesData.([]interface{})
+	if !ok {
+		return nil, errors.New("error casting vertices to []interface{}")
+	}
 
-			x, ok := vertexMap["x"].(int64) // Firestore uses int64 for numbers
-			if !ok {
-				return nil, errors.New("error casting x to int64")
-			}
+	var verticesMap []map[string]int
 
-			y, ok := vertexMap["y"].(int64) // Firestore uses int64 for numbers
-			if !ok {
-				return nil, errors.New("error casting y to int64")
-			}
+	for _, vertex := range vertices {
+		vertexMap, ok := vertex.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("error casting vertex to map[string]interface{}")
+		}
 
-			verticesMap = append(verticesMap, map[string]int{
-				"x": int(x),
-				"y": int(y),
-			})
+		x, ok := vertexMap["x"].(int64) // Firestore uses int64 for numbers
+		if !ok {
+			return nil, errors.New("error casting x to int64")
 		}
 
-		faceVertices = append(faceVertices, types.FaceVertices{
-			Id:       id,
-			ImageId:  imageId,
-			Vertices: verticesMap,
+		y, ok := vertexMap["y"].(int64) // Firestore uses int64 for numbers
+		if !ok {
+			return nil, errors.New("error casting y to int64")
+		}
+
+		verticesMap = append(verticesMap, map[string]int{
+			"x": int(x),
+			"y": int(y),
 		})
 	}
 
-	return faceVertices, nil
+	return verticesMap, nil
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"image"
+	"image/gif"
 	"proteggo_api/types"
 	"strings"
 
@@ -35,6 +36,16 @@ func UpdateFirestoreDocument(c context.Context, client *firestore.Client, collec
 	return err
 }
 
+// Converts fields to updates which replace whole fields, unlike merging with MergeAll nested maps are not merged
+func ConvertToFirestoreUpdates(data map[string]interface{}) []firestore.Update {
+	var updates []firestore.Update
+	for field, value := range data {
+		updates = append(updates, firestore.Update{Path: field, Value: value})
+	}
+
+	return updates
+}
+
 func AddFirestoreDocument(c context.Context, client *firestore.Client, collection string, data map[string]interface{}) error {
 	// Create a reference to the document you want to create
 	_, _, err := client.Collection(collection).Add(c, data)
@@ -64,6 +75,23 @@ func GetFirestoreDocument(c context.Context, client *firestore.Client, collectio
 	return doc.Data(), nil
 }
 
+// Gets a document from a Firestore collection inside a transaction
+func GetFirestoreDocumentInTransaction(tx *firestore.Transaction, client *firestore.Client, collection, documentName string) (map[string]interface{}, error) {
+	docRef := client.Collection(collection).Doc(documentName)
+
+	// Get the document
+	doc, err := tx.Get(docRef)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	return doc.Data(), nil
+}
+
 func GetFirestoreDocuments(c context.Context, client *firestore.Client, collection string) ([]map[string]interface{}, error) {
 	// Create a reference to the document you want to create
 	iter := client.Collection(collection).Documents(c)
@@ -187,3 +215,20 @@ func GetImageFromStorage(filePath string, storage *gcs.Client, c context.Context
 
 	return img, nil
 }
+
+func GetGifFromStorage(filePath string, storage *gcs.Client, c context.Context) (*gif.GIF, error) {
+	// Download the animation from the GCS
+	rc, err := storage.Bucket(types.FIREBASE_STORAGE_BUCKET).Object(filePath).NewReader(c)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	// Decode all the frames
+	g, err := gif.DecodeAll(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
@@ -177,5 +177,31 @@ func UploadImageToStorage(context context.Context, file multipart.File, logger *
 		Id:          id,
 		FilePath:    objectName,
 		Orientation: orientation,
+		ContentType: contentType,
 	}, nil
 }
+
+// Returns the ids of the posts using an image, including the post id of images written before they could be used by
+// several posts
+func GetImagePostsIds(image map[string]interface{}) []string {
+	postsIds := []string{}
+
+	if values, ok := image[types.FIREBASE_IMAGES_FIELDS_POSTS_IDS].([]interface{}); ok {
+		for _, value := range values {
+			if postId, ok := value.(string); ok && postId != "" {
+				postsIds = append(postsIds, postId)
+			}
+		}
+	}
+
+	if postId, ok := image[types.FIREBASE_IMAGES_FIELDS_POST_ID].(string); ok && postId != "" {
+		for _, id := range postsIds {
+			if id == postId {
+				return postsIds
+			}
+		}
+		postsIds = append(postsIds, postId)
+	}
+
+	return postsIds
+}
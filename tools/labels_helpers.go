@@ -0,0 +1,132 @@
+package tools
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"proteggo_api/types"
+
+	"cloud.google.com/go/vision/v2/apiv1/visionpb"
+)
+
+func getLabelsFromAnnotations(annotations []*visionpb.EntityAnnotation) []types.Label {
+	labels := []types.Label{}
+	for _, annotation := range annotations {
+		labels = append(labels, types.Label{
+			Description: annotation.GetDescription(),
+			Score:       annotation.GetScore(),
+		})
+	}
+
+	return labels
+}
+
+// Maps labels to the most similar existing hash tags, labels without a similar hash tag are proposed as new values
+func SuggestHashTags(labels []types.Label, hashTags []types.HashTag) []types.HashTagSuggestion {
+	// Merge labels detected in multiple images, keeping the highest score
+	scores := map[string]float32{}
+	descriptions := map[string]string{}
+	for _, label := range labels {
+		value := NormalizeHashTagValue(label.Description)
+		if value == "" {
+			continue
+		}
+
+		if label.Score > scores[value] {
+			scores[value] = label.Score
+			descriptions[value] = label.Description
+		}
+	}
+
+	// Highest scores first, so the label with the highest score wins when several map to the same hash tag
+	values := make([]string, 0, len(scores))
+	for value := range scores {
+		values = append(values, value)
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		if scores[values[i]] != scores[values[j]] {
+			return scores[values[i]] > scores[values[j]]
+		}
+		return values[i] < values[j]
+	})
+
+	suggestions := []types.HashTagSuggestion{}
+	suggested := map[string]bool{}
+
+	for _, value := range values {
+		suggestion := types.HashTagSuggestion{
+			Label: descriptions[value],
+			Score: scores[value],
+			Value: value,
+		}
+
+		// Find the most similar existing hash tag
+		bestSimilarity := 0.0
+		for i, hashTag := range hashTags {
+			similarity := HashTagSimilarity(value, NormalizeHashTagValue(hashTag.Value))
+			if similarity >= types.HASHTAGS_SUGGESTIONS_MIN_SIMILARITY && similarity > bestSimilarity {
+				bestSimilarity = similarity
+				suggestion.HashTag = &hashTags[i]
+				suggestion.Value = hashTag.Value
+			}
+		}
+
+		// Different labels can map to the same hash tag, suggest it only once
+		if suggested[suggestion.Value] {
+			continue
+		}
+		suggested[suggestion.Value] = true
+
+		suggestions = append(suggestions, suggestion)
+	}
+
+	return suggestions
+}
+
+// Lowercases the value and strips everything that is not a letter or digit, e.g. "#Street Art" becomes "streetart"
+func NormalizeHashTagValue(value string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(value) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+		}
+	}
+
+	return sb.String()
+}
+
+// Returns the similarity of two values between 0 and 1, based on their Levenshtein distance
+func HashTagSimilarity(a string, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+
+	return 1 - float64(levenshteinDistance(ra, rb))/float64(longest)
+}
+
+func levenshteinDistance(a []rune, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
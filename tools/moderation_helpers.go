@@ -0,0 +1,85 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/vision/v2/apiv1/visionpb"
+)
+
+func getSafeSearchFromAnnotation(annotation *visionpb.SafeSearchAnnotation) types.SafeSearch {
+	return types.SafeSearch{
+		Adult:    annotation.GetAdult().String(),
+		Violence: annotation.GetViolence().String(),
+		Racy:     annotation.GetRacy().String(),
+	}
+}
+
+// Gets the moderation policy from Firestore, falls back to the default policy if none was set
+func GetModerationPolicy(c context.Context, client *firestore.Client) (types.ModerationPolicy, error) {
+	policy := types.ModerationPolicy{
+		Adult:    types.MODERATION_POLICY_DEFAULT_ADULT,
+		Violence: types.MODERATION_POLICY_DEFAULT_VIOLENCE,
+		Racy:     types.MODERATION_POLICY_DEFAULT_RACY,
+	}
+
+	doc, err := GetFirestoreDocument(c, client, types.FIREBASE_SETTINGS_COLLECTION, types.FIREBASE_SETTINGS_MODERATION_POLICY_DOCUMENT)
+	if err != nil {
+		return policy, err
+	}
+
+	if adult, ok := doc[types.FIREBASE_MODERATION_POLICY_FIELDS_ADULT].(string); ok {
+		policy.Adult = adult
+	}
+	if violence, ok := doc[types.FIREBASE_MODERATION_POLICY_FIELDS_VIOLENCE].(string); ok {
+		policy.Violence = violence
+	}
+	if racy, ok := doc[types.FIREBASE_MODERATION_POLICY_FIELDS_RACY].(string); ok {
+		policy.Racy = racy
+	}
+
+	return policy, nil
+}
+
+// Checks that a likelihood name is one of the Vision likelihoods, e.g. "POSSIBLE"
+func ValidateLikelihood(likelihood string) error {
+	if _, ok := visionpb.Likelihood_value[likelihood]; !ok {
+		return fmt.Errorf("invalid likelihood: %v", likelihood)
+	}
+
+	return nil
+}
+
+// Returns the moderation status of an image, it is quarantined if any category reaches the policy threshold
+func EvaluateSafeSearch(policy types.ModerationPolicy, safeSearch types.SafeSearch) string {
+	if reachesLikelihood(safeSearch.Adult, policy.Adult) ||
+		reachesLikelihood(safeSearch.Violence, policy.Violence) ||
+		reachesLikelihood(safeSearch.Racy, policy.Racy) {
+		return types.MODERATION_STATUS_QUARANTINED
+	}
+
+	return types.MODERATION_STATUS_APPROVED
+}
+
+// Checks if the image can be shown and used in posts, images processed before moderation have no status and are usable
+func IsImageModerationApproved(image map[string]interface{}) bool {
+	status, ok := image[types.FIREBASE_IMAGES_FIELDS_MODERATION_STATUS].(string)
+	if !ok {
+		return true
+	}
+
+	return status == types.MODERATION_STATUS_APPROVED
+}
+
+func reachesLikelihood(likelihood string, threshold string) bool {
+	thresholdValue, ok := visionpb.Likelihood_value[threshold]
+	if !ok || thresholdValue == int32(visionpb.Likelihood_UNKNOWN) {
+		// Unknown threshold disables the category
+		return false
+	}
+
+	return visionpb.Likelihood_value[likelihood] >= thresholdValue
+}
@@ -0,0 +1,108 @@
+package tools
+
+import (
+	"context"
+
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Fields added to the posts after the first posts were written, with the value the older posts are completed with.
+// Queries filtering on a field never match the documents without it, so these posts would be missing from the lists.
+func getPostsBackfillDefaults() map[string]interface{} {
+	return map[string]interface{}{
+		// Posts written before the trash existed are not in it
+		types.FIREBASE_POSTS_FIELDS_DELETED_AT: nil,
+		// Posts written before drafts and scheduling existed were published right away
+		types.FIREBASE_POSTS_FIELDS_STATUS: types.POST_STATUS_PUBLISHED,
+		// Posts written before visibilities existed could only be read by signed in users
+		types.FIREBASE_POSTS_FIELDS_VISIBILITY: types.POST_VISIBILITY_AUTHENTICATED,
+	}
+}
+
+// Fields added to the posts after the first posts were written, with the field of the older posts they are completed from
+func getPostsBackfillSources() map[string]string {
+	return map[string]string{
+		// Posts written before the publication time was kept apart from the creation time were published when created,
+		// or had their creation time replaced when they were published
+		types.FIREBASE_POSTS_FIELDS_PUBLISHED_AT: types.FIREBASE_POSTS_FIELDS_CREATED_AT,
+	}
+}
+
+// Sets the fields missing on the posts written before they existed, returns the number of posts completed. Fields
+// which are present are never changed, so it can run any number of times. The version is left alone, the posts read
+// the same before and after.
+func BackfillPosts(c context.Context, client *firestore.Client) (int, error) {
+	defaults := getPostsBackfillDefaults()
+	sources := getPostsBackfillSources()
+
+	fields := []string{}
+	for field := range defaults {
+		fields = append(fields, field)
+	}
+	for field, source := range sources {
+		fields = append(fields, field, source)
+	}
+
+	// Only the backfilled fields are read, a field set to null is returned while a missing field is not
+	docs, err := client.Collection(types.FIREBASE_POSTS_COLLECTION).Select(fields...).Documents(c).GetAll()
+	if err != nil {
+		return 0, err
+	}
+
+	backfilled := 0
+
+	for _, doc := range docs {
+		if len(getMissingPostFields(doc.Data(), defaults, sources)) == 0 {
+			continue
+		}
+
+		// The post could have been written since the query, so the missing fields are checked again inside the transaction
+		err = client.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+			post, err := GetFirestoreDocumentInTransaction(tx, client, types.FIREBASE_POSTS_COLLECTION, doc.Ref.ID)
+			if err != nil || post == nil {
+				return err
+			}
+
+			updates := []firestore.Update{}
+			for field, value := range getMissingPostFields(post, defaults, sources) {
+				updates = append(updates, firestore.Update{Path: field, Value: value})
+			}
+
+			if len(updates) == 0 {
+				return nil
+			}
+
+			// The search indexes of the other instances pick up the posts which became listed
+			updates = append(updates, firestore.Update{Path: types.FIREBASE_POSTS_FIELDS_UPDATED_AT, Value: firestore.ServerTimestamp})
+
+			return tx.Update(doc.Ref, updates)
+		})
+		if err != nil {
+			return backfilled, err
+		}
+
+		backfilled++
+	}
+
+	return backfilled, nil
+}
+
+// Returns the backfilled fields missing on a post with their default value or the value of their source field
+func getMissingPostFields(post map[string]interface{}, defaults map[string]interface{}, sources map[string]string) map[string]interface{} {
+	missing := map[string]interface{}{}
+	for field, value := range defaults {
+		if _, ok := post[field]; !ok {
+			missing[field] = value
+		}
+	}
+
+	for field, source := range sources {
+		if _, ok := post[field]; !ok {
+			missing[field] = post[source]
+		}
+	}
+
+	return missing
+}
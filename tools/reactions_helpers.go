@@ -0,0 +1,143 @@
+package tools
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// Gets the reactions settings from Firestore, falling back to the default emojis when they were never set
+func GetReactionsSettings(c context.Context, client *firestore.Client) (types.ReactionsSettings, error) {
+	settings := types.ReactionsSettings{
+		Emojis: strings.Split(types.REACTIONS_DEFAULT_EMOJIS, ","),
+	}
+
+	doc, err := GetFirestoreDocument(c, client, types.FIREBASE_SETTINGS_COLLECTION, types.FIREBASE_SETTINGS_REACTIONS_DOCUMENT)
+	if err != nil {
+		return settings, err
+	}
+
+	if emojis, ok := doc[types.FIREBASE_REACTIONS_SETTINGS_FIELDS_EMOJIS].([]interface{}); ok {
+		settings.Emojis = []string{}
+		for _, emoji := range emojis {
+			if value, ok := emoji.(string); ok {
+				settings.Emojis = append(settings.Emojis, value)
+			}
+		}
+	}
+
+	return settings, nil
+}
+
+// Returns the id of the reaction of a user to a post with a type
+func GetReactionId(postId string, uid string, reactionType string) string {
+	return postId + "_" + uid + "_" + reactionType
+}
+
+// Returns the id of a shard of the reactions counters of a post
+func GetReactionsShardId(postId string, shard int) string {
+	return postId + "_" + strconv.Itoa(shard)
+}
+
+// Rolls the shards changed since the last roll up into the reactions counts of their posts, returns the number of posts
+// rolled up. The first roll up reads every shard, including those written before the shards kept their change time.
+func RollUpReactionsCounts(c context.Context, client *firestore.Client) (int, error) {
+	rollup, err := GetFirestoreDocument(c, client, types.FIREBASE_SETTINGS_COLLECTION, types.FIREBASE_SETTINGS_REACTIONS_ROLLUP_DOCUMENT)
+	if err != nil {
+		return 0, err
+	}
+
+	startedAt := time.Now()
+	query := client.Collection(types.FIREBASE_REACTIONS_SHARDS_COLLECTION).Query
+	if rolledUpAt, ok := rollup[types.FIREBASE_REACTIONS_ROLLUP_FIELDS_ROLLED_UP_AT].(time.Time); ok {
+		query = query.Where(types.FIREBASE_REACTIONS_SHARDS_FIELDS_UPDATED_AT, ">=", rolledUpAt.Add(-types.REACTIONS_ROLLUP_OVERLAP_SECONDS*time.Second))
+	}
+
+	iter := query.Documents(c)
+	defer iter.Stop()
+
+	postsIds := []string{}
+	changed := map[string]bool{}
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		postId, _ := doc.Data()[types.FIREBASE_REACTIONS_SHARDS_FIELDS_POST_ID].(string)
+		if postId != "" && !changed[postId] {
+			changed[postId] = true
+			postsIds = append(postsIds, postId)
+		}
+	}
+
+	for _, postId := range postsIds {
+		err = rollUpPostReactionsCounts(c, client, postId)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = SetFirestoreDocument(c, client, types.FIREBASE_SETTINGS_COLLECTION, types.FIREBASE_SETTINGS_REACTIONS_ROLLUP_DOCUMENT, map[string]interface{}{
+		types.FIREBASE_REACTIONS_ROLLUP_FIELDS_ROLLED_UP_AT: startedAt,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(postsIds), nil
+}
+
+// Sums the shards of a post into its reactions counts, every reaction type is kept so emojis added back to the settings
+// get their counts again
+func rollUpPostReactionsCounts(c context.Context, client *firestore.Client, postId string) error {
+	return client.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+		post, err := GetFirestoreDocumentInTransaction(tx, client, types.FIREBASE_POSTS_COLLECTION, postId)
+		if err != nil {
+			return err
+		}
+
+		refs := make([]*firestore.DocumentRef, types.REACTIONS_SHARDS_COUNT)
+		for shard := range refs {
+			refs[shard] = client.Collection(types.FIREBASE_REACTIONS_SHARDS_COLLECTION).Doc(GetReactionsShardId(postId, shard))
+		}
+
+		shards, err := tx.GetAll(refs)
+		if err != nil {
+			return err
+		}
+
+		// The shards of a purged post are deleted with it
+		if post == nil {
+			return nil
+		}
+
+		counts := map[string]int64{}
+		for _, shard := range shards {
+			if !shard.Exists() {
+				continue
+			}
+
+			shardCounts, _ := shard.Data()[types.FIREBASE_REACTIONS_SHARDS_FIELDS_COUNTS].(map[string]interface{})
+			for reactionType, count := range shardCounts {
+				if value, ok := count.(int64); ok {
+					counts[reactionType] += value
+				}
+			}
+		}
+
+		return tx.Update(client.Collection(types.FIREBASE_POSTS_COLLECTION).Doc(postId), []firestore.Update{
+			{Path: types.FIREBASE_POSTS_FIELDS_REACTIONS_COUNTS, Value: counts},
+		})
+	})
+}
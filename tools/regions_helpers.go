@@ -0,0 +1,180 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/vision/v2/apiv1/visionpb"
+	"google.golang.org/api/iterator"
+)
+
+// Plate formats vary by country, so only the overall shape is checked: two alphanumeric groups
+var licensePlatePattern = regexp.MustCompile(`^[A-Z0-9]{1,4}[ -]?[A-Z0-9]{2,6}$`)
+
+// Minimum amount of words or lines for a text block to be considered a document
+const documentMinWords = 15
+const documentMinLines = 4
+
+// Classifies the blocks of a text annotation, blocks which are not sensitive are left out
+func getRegionsFromTextAnnotation(annotation *visionpb.TextAnnotation) ([]types.Region, error) {
+	regions := []types.Region{}
+
+	// Each block of the full text annotation is a separate text area in the image
+	for _, page := range annotation.GetPages() {
+		for _, block := range page.GetBlocks() {
+			text, words, lines := readTextBlock(block)
+			vertices := boundingBoxVertices(block.GetBoundingBox().GetVertices())
+			if len(vertices) == 0 {
+				continue
+			}
+
+			width := vertices[2]["x"] - vertices[0]["x"]
+			height := vertices[2]["y"] - vertices[0]["y"]
+
+			regionType := ClassifyTextRegion(text, words, lines, width, height)
+			if regionType == "" {
+				continue
+			}
+
+			regionName, err := GenerateRandomName()
+			if err != nil {
+				return nil, err
+			}
+
+			regions = append(regions, types.Region{
+				Id:       regionName,
+				Type:     regionType,
+				Text:     text,
+				Vertices: vertices,
+			})
+		}
+	}
+
+	return regions, nil
+}
+
+// Classifies a text block as a license plate, name badge or document, returns empty string if the text is not sensitive
+func ClassifyTextRegion(text string, words int, lines int, width int, height int) string {
+	if words >= documentMinWords || lines >= documentMinLines {
+		return types.REGION_TYPE_DOCUMENT
+	}
+
+	normalized := strings.ToUpper(strings.TrimSpace(text))
+	if lines <= 1 && words <= 3 && width >= 2*height && licensePlatePattern.MatchString(normalized) && containsLetterAndDigit(normalized) {
+		return types.REGION_TYPE_LICENSE_PLATE
+	}
+
+	if lines <= 3 && words >= 2 && words <= 4 && looksLikeName(text) {
+		return types.REGION_TYPE_NAME_BADGE
+	}
+
+	return ""
+}
+
+// Joins the symbols of a text block and counts its words and lines
+func readTextBlock(block *visionpb.Block) (string, int, int) {
+	var sb strings.Builder
+	words := 0
+	lines := 1
+
+	for _, paragraph := range block.GetParagraphs() {
+		for _, word := range paragraph.GetWords() {
+			words++
+
+			for _, symbol := range word.GetSymbols() {
+				sb.WriteString(symbol.GetText())
+
+				switch symbol.GetProperty().GetDetectedBreak().GetType() {
+				case visionpb.TextAnnotation_DetectedBreak_SPACE, visionpb.TextAnnotation_DetectedBreak_SURE_SPACE:
+					sb.WriteString(" ")
+				case visionpb.TextAnnotation_DetectedBreak_EOL_SURE_SPACE, visionpb.TextAnnotation_DetectedBreak_LINE_BREAK:
+					sb.WriteString("\n")
+					lines++
+				}
+			}
+		}
+	}
+
+	text := strings.TrimSpace(sb.String())
+	if strings.HasSuffix(sb.String(), "\n") {
+		lines--
+	}
+
+	return text, words, lines
+}
+
+func containsLetterAndDigit(text string) bool {
+	hasLetter := strings.IndexFunc(text, unicode.IsLetter) >= 0
+	hasDigit := strings.IndexFunc(text, unicode.IsDigit) >= 0
+
+	return hasLetter && hasDigit
+}
+
+// Checks if every word of the text is capitalized and made only of letters, e.g. "Jane Doe"
+func looksLikeName(text string) bool {
+	for _, word := range strings.Fields(text) {
+		runes := []rune(word)
+		if !unicode.IsUpper(runes[0]) {
+			return false
+		}
+
+		for _, r := range runes {
+			if !unicode.IsLetter(r) && r != '-' && r != '\'' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func GetRegionsVertices(imageId string, regionsIds []string, c context.Context, firestoreClient *firestore.Client) ([]types.FaceVertices, error) {
+	if len(regionsIds) == 0 {
+		return nil, nil
+	}
+
+	// Get the regions from Firestore
+	query := firestoreClient.Collection(types.FIREBASE_REGIONS_COLLECTION).
+		Where(types.FIREBASE_REGIONS_FIELDS_IMAGE_ID, "==", imageId).
+		Where(types.FIREBASE_REGIONS_FIELDS_ID, "in", regionsIds)
+
+	iter := query.Documents(c)
+	defer iter.Stop()
+
+	// Regions are obscured the same way as faces, so they are returned as face vertices
+	var regionsVertices []types.FaceVertices
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		id, ok := doc.Data()[types.FIREBASE_REGIONS_FIELDS_ID].(string)
+		if !ok {
+			return nil, errors.New("error casting region id to string")
+		}
+
+		vertices, err := ConvertFirestoreVertices(doc.Data()[types.FIREBASE_REGIONS_FIELDS_VERTICES])
+		if err != nil {
+			return nil, err
+		}
+
+		regionsVertices = append(regionsVertices, types.FaceVertices{
+			Id:       id,
+			ImageId:  imageId,
+			Vertices: vertices,
+		})
+	}
+
+	return regionsVertices, nil
+}
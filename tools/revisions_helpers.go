@@ -0,0 +1,10 @@
+package tools
+
+import (
+	"fmt"
+)
+
+// Returns the id of a revision of a post, revisions are identified by the post id and the revision number
+func GetPostRevisionId(postId string, revision int64) string {
+	return fmt.Sprintf("%s_%d", postId, revision)
+}
@@ -0,0 +1,91 @@
+package tools
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+)
+
+var ErrInvalidShareLinkToken = errors.New("invalid share link token")
+
+// Gets the secret signing the share links, it is created on first use and shared by all the instances
+func GetShareLinksSecret(c context.Context, client *firestore.Client) ([]byte, error) {
+	doc, err := GetFirestoreDocument(c, client, types.FIREBASE_SETTINGS_COLLECTION, types.FIREBASE_SETTINGS_SHARE_LINKS_DOCUMENT)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret, ok := doc[types.FIREBASE_SHARE_LINKS_SETTINGS_FIELDS_SECRET].([]byte); ok && len(secret) > 0 {
+		return secret, nil
+	}
+
+	// Instances creating the secret at the same time agree on a single one
+	var secret []byte
+	err = client.RunTransaction(c, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := GetFirestoreDocumentInTransaction(tx, client, types.FIREBASE_SETTINGS_COLLECTION, types.FIREBASE_SETTINGS_SHARE_LINKS_DOCUMENT)
+		if err != nil {
+			return err
+		}
+
+		if value, ok := doc[types.FIREBASE_SHARE_LINKS_SETTINGS_FIELDS_SECRET].([]byte); ok && len(value) > 0 {
+			secret = value
+			return nil
+		}
+
+		secret = make([]byte, types.SHARE_LINKS_SECRET_BYTES)
+		_, err = rand.Read(secret)
+		if err != nil {
+			return err
+		}
+
+		return tx.Set(client.Collection(types.FIREBASE_SETTINGS_COLLECTION).Doc(types.FIREBASE_SETTINGS_SHARE_LINKS_DOCUMENT), map[string]interface{}{
+			types.FIREBASE_SHARE_LINKS_SETTINGS_FIELDS_SECRET: secret,
+		})
+	})
+
+	return secret, err
+}
+
+// Signs the id and expiry of a share link, the token has the form <id>.<expiry>.<signature>
+func SignShareLinkToken(secret []byte, id string, expiresAt time.Time) string {
+	payload := id + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signShareLinkPayload(secret, payload))
+}
+
+// Checks the signature and expiry of a share link token and returns the id of the link
+func VerifyShareLinkToken(secret []byte, token string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] == "" {
+		return "", ErrInvalidShareLinkToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, signShareLinkPayload(secret, parts[0]+"."+parts[1])) {
+		return "", ErrInvalidShareLinkToken
+	}
+
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || !now.Before(time.Unix(expiresAt, 0)) {
+		return "", ErrInvalidShareLinkToken
+	}
+
+	return parts[0], nil
+}
+
+func signShareLinkPayload(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,131 @@
+package tools
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyShareLinkToken(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Date(2024, 5, 17, 10, 0, 0, 0, time.UTC)
+	token := SignShareLinkToken(secret, "link1", now.Add(time.Hour))
+	parts := strings.Split(token, ".")
+
+	tests := []struct {
+		name   string
+		secret []byte
+		token  string
+		now    time.Time
+		id     string
+	}{
+		{
+			name:   "valid token",
+			secret: secret,
+			token:  token,
+			now:    now,
+			id:     "link1",
+		},
+		{
+			name:   "valid until the second before the expiry",
+			secret: secret,
+			token:  token,
+			now:    now.Add(time.Hour - time.Second),
+			id:     "link1",
+		},
+		{
+			name:   "expired at the expiry",
+			secret: secret,
+			token:  token,
+			now:    now.Add(time.Hour),
+		},
+		{
+			name:   "expired",
+			secret: secret,
+			token:  SignShareLinkToken(secret, "link1", now.Add(-time.Minute)),
+			now:    now,
+		},
+		{
+			name:   "signed with another secret",
+			secret: []byte("other secret"),
+			token:  token,
+			now:    now,
+		},
+		{
+			name:   "tampered id",
+			secret: secret,
+			token:  "link2." + parts[1] + "." + parts[2],
+			now:    now,
+		},
+		{
+			name:   "tampered expiry",
+			secret: secret,
+			token:  parts[0] + "." + "4102444800" + "." + parts[2],
+			now:    now,
+		},
+		{
+			name:   "tampered signature",
+			secret: secret,
+			token:  parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2])),
+			now:    now,
+		},
+		{
+			name:   "empty token",
+			secret: secret,
+			token:  "",
+			now:    now,
+		},
+		{
+			name:   "missing signature",
+			secret: secret,
+			token:  parts[0] + "." + parts[1],
+			now:    now,
+		},
+		{
+			name:   "too many parts",
+			secret: secret,
+			token:  token + ".extra",
+			now:    now,
+		},
+		{
+			name:   "empty id",
+			secret: secret,
+			token:  SignShareLinkToken(secret, "", now.Add(time.Hour)),
+			now:    now,
+		},
+		{
+			name:   "signature which is not base64",
+			secret: secret,
+			token:  parts[0] + "." + parts[1] + ".not base64!",
+			now:    now,
+		},
+		{
+			name:   "expiry which is not a number",
+			secret: secret,
+			token:  "link1.soon." + base64.RawURLEncoding.EncodeToString(signShareLinkPayload(secret, "link1.soon")),
+			now:    now,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id, err := VerifyShareLinkToken(test.secret, test.token, test.now)
+
+			if test.id == "" {
+				if !errors.Is(err, ErrInvalidShareLinkToken) {
+					t.Fatalf("expected %v, got %v %v", ErrInvalidShareLinkToken, id, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if id != test.id {
+				t.Fatalf("expected %v, got %v", test.id, id)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package tools
+
+import (
+	"context"
+	"errors"
+
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	gcs "cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// Queues storage objects for deletion within the transaction, the objects are deleted only if the transaction commits
+func QueueStorageDeletions(tx *firestore.Transaction, client *firestore.Client, storagePaths []string) (*firestore.DocumentRef, error) {
+	docRef := client.Collection(types.FIREBASE_STORAGE_OUTBOX_COLLECTION).NewDoc()
+
+	err := tx.Create(docRef, map[string]interface{}{
+		types.FIREBASE_STORAGE_OUTBOX_FIELDS_STORAGE_PATHS: storagePaths,
+		types.FIREBASE_STORAGE_OUTBOX_FIELDS_CREATED_AT:    firestore.ServerTimestamp,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return docRef, nil
+}
+
+// Deletes the queued storage objects, the paths that failed stay in the outbox for the next attempt
+func ProcessStorageDeletion(c context.Context, client *firestore.Client, storage *gcs.Client, docRef *firestore.DocumentRef) error {
+	doc, err := docRef.Get(c)
+	if err != nil {
+		return err
+	}
+
+	storagePathsData, _ := doc.Data()[types.FIREBASE_STORAGE_OUTBOX_FIELDS_STORAGE_PATHS].([]interface{})
+
+	var failedPaths []string
+	var lastErr error
+
+	for _, storagePathData := range storagePathsData {
+		storagePath, ok := storagePathData.(string)
+		if !ok || storagePath == "" {
+			continue
+		}
+
+		// Objects already deleted by a previous attempt are fine
+		err := DeleteObjectFromStorage(c, storagePath, storage)
+		if err != nil && !errors.Is(err, gcs.ErrObjectNotExist) {
+			failedPaths = append(failedPaths, storagePath)
+			lastErr = err
+		}
+	}
+
+	if len(failedPaths) > 0 {
+		_, err = docRef.Update(c, []firestore.Update{
+			{Path: types.FIREBASE_STORAGE_OUTBOX_FIELDS_STORAGE_PATHS, Value: failedPaths},
+		})
+		if err != nil {
+			return err
+		}
+
+		return lastErr
+	}
+
+	_, err = docRef.Delete(c)
+
+	return err
+}
+
+// Retries all the queued storage deletions, returns the number of entries which are still pending
+func ProcessStorageOutbox(c context.Context, client *firestore.Client, storage *gcs.Client) (int, error) {
+	iter := client.Collection(types.FIREBASE_STORAGE_OUTBOX_COLLECTION).Documents(c)
+	defer iter.Stop()
+
+	pending := 0
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return pending, err
+		}
+
+		if err := ProcessStorageDeletion(c, client, storage, doc.Ref); err != nil {
+			pending++
+		}
+	}
+
+	return pending, nil
+}
@@ -0,0 +1,18 @@
+package tools
+
+import (
+	"errors"
+	"os"
+
+	"proteggo_api/types"
+)
+
+// Returns the service account Cloud Tasks and Cloud Scheduler sign the OIDC tokens of the task handlers calls as
+func GetTasksServiceAccountEmail() (string, error) {
+	email := os.Getenv(types.TASKS_SERVICE_ACCOUNT_EMAIL_ENV)
+	if email == "" {
+		return "", errors.New(types.TASKS_SERVICE_ACCOUNT_EMAIL_ENV + " is not configured")
+	}
+
+	return email, nil
+}
@@ -0,0 +1,60 @@
+package tools
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Splits the text into unique lowercase words, those are stored to search images by contained text
+func TokenizeText(text string) []string {
+	seen := map[string]bool{}
+	tokens := []string{}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for _, word := range words {
+		// Single characters are mostly OCR noise
+		if len([]rune(word)) < 2 || seen[word] {
+			continue
+		}
+
+		seen[word] = true
+		tokens = append(tokens, word)
+	}
+
+	return tokens
+}
+
+// Checks if the text contains all the tokens of the query and, for multi word queries, the query as a phrase
+func TextMatchesQuery(text string, query string) bool {
+	textTokens := map[string]bool{}
+	for _, token := range TokenizeText(text) {
+		textTokens[token] = true
+	}
+
+	queryTokens := TokenizeText(query)
+	for _, token := range queryTokens {
+		if !textTokens[token] {
+			return false
+		}
+	}
+
+	if len(queryTokens) > 1 {
+		phrase := " " + strings.Join(strings.Fields(normalizePhrase(query)), " ") + " "
+		return strings.Contains(" "+strings.Join(strings.Fields(normalizePhrase(text)), " ")+" ", phrase)
+	}
+
+	return true
+}
+
+// Replaces punctuation with spaces and lowercases the text, so phrases can be compared word by word
+func normalizePhrase(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, text)
+}
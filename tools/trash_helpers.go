@@ -0,0 +1,39 @@
+package tools
+
+import (
+	"context"
+	"time"
+
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Gets the trash settings from Firestore, falling back to the default retention when they were never set
+func GetTrashSettings(c context.Context, client *firestore.Client) (types.TrashSettings, error) {
+	settings := types.TrashSettings{
+		RetentionDays: types.TRASH_DEFAULT_RETENTION_DAYS,
+	}
+
+	doc, err := GetFirestoreDocument(c, client, types.FIREBASE_SETTINGS_COLLECTION, types.FIREBASE_SETTINGS_TRASH_DOCUMENT)
+	if err != nil {
+		return settings, err
+	}
+
+	if retentionDays, ok := doc[types.FIREBASE_TRASH_SETTINGS_FIELDS_RETENTION_DAYS].(int64); ok {
+		settings.RetentionDays = int(retentionDays)
+	}
+
+	return settings, nil
+}
+
+// Returns the time before which documents in the trash are purged
+func GetTrashPurgeCutoff(settings types.TrashSettings, now time.Time) time.Time {
+	return now.AddDate(0, 0, -settings.RetentionDays)
+}
+
+// Returns whether a post or image document was moved to the trash, both collections use the same deletedAt field
+func IsDocumentInTrash(data map[string]interface{}) bool {
+	_, ok := data[types.FIREBASE_IMAGES_FIELDS_DELETED_AT].(time.Time)
+	return ok
+}
@@ -0,0 +1,19 @@
+package tools
+
+import (
+	"errors"
+	"os"
+	"proteggo_api/types"
+	"strings"
+)
+
+// Returns the scheme and host the public pages and feeds are served from. It is configured rather than read from the
+// request, the responses are cached publicly and must not carry a host sent by the client.
+func GetPublicBaseUrl() (string, error) {
+	baseUrl := strings.TrimSuffix(os.Getenv(types.PUBLIC_BASE_URL_ENV), "/")
+	if baseUrl == "" {
+		return "", errors.New(types.PUBLIC_BASE_URL_ENV + " is not configured")
+	}
+
+	return baseUrl, nil
+}
@@ -0,0 +1,127 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+
+	"proteggo_api/types"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	vision "cloud.google.com/go/vision/v2/apiv1"
+	"cloud.google.com/go/vision/v2/apiv1/visionpb"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/status"
+)
+
+// Annotates the image with a single Vision request, the text, faces and regions are all read from its response
+func AnnotateImage(ctx *gin.Context, firestoreClient *firestore.Client, storage *storage.Client, img image.Image, maxFaces int32, maxLabels int32) (types.ImageAnnotations, error) {
+	res, err := annotateImage(ctx, img, []*visionpb.Feature{
+		{
+			Type: visionpb.Feature_SAFE_SEARCH_DETECTION,
+		},
+		{
+			Type:       visionpb.Feature_LABEL_DETECTION,
+			MaxResults: maxLabels,
+		},
+		{
+			Type:       visionpb.Feature_FACE_DETECTION,
+			MaxResults: maxFaces,
+		},
+		{
+			// Document detection reads dense text better, its blocks are also classified as regions
+			Type: visionpb.Feature_DOCUMENT_TEXT_DETECTION,
+		},
+	})
+	if err != nil {
+		return types.ImageAnnotations{}, err
+	}
+
+	faces, err := getFacesFromAnnotations(ctx, firestoreClient, storage, img, res.GetFaceAnnotations())
+	if err != nil {
+		return types.ImageAnnotations{}, err
+	}
+
+	regions, err := getRegionsFromTextAnnotation(res.GetFullTextAnnotation())
+	if err != nil {
+		return types.ImageAnnotations{}, err
+	}
+
+	return types.ImageAnnotations{
+		SafeSearch: getSafeSearchFromAnnotation(res.GetSafeSearchAnnotation()),
+		Labels:     getLabelsFromAnnotations(res.GetLabelAnnotations()),
+		Text:       res.GetFullTextAnnotation().GetText(),
+		Faces:      faces,
+		Regions:    regions,
+	}, nil
+}
+
+// Sends a single image annotation request with the given features to the Vision API
+func annotateImage(ctx context.Context, img image.Image, features []*visionpb.Feature) (*visionpb.AnnotateImageResponse, error) {
+	client, err := vision.NewImageAnnotatorClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	// Encode the image.Image (img) as a JPEG into a bytes.Buffer
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		return nil, err
+	}
+
+	// Create the request.
+	req := &visionpb.BatchAnnotateImagesRequest{
+		Requests: []*visionpb.AnnotateImageRequest{
+			{
+				Image: &visionpb.Image{
+					Content: buf.Bytes(),
+				},
+				Features: features,
+			},
+		},
+	}
+
+	// Execute the request.
+	resp, err := client.BatchAnnotateImages(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Responses) == 0 {
+		return nil, errors.New("vision returned no responses")
+	}
+
+	res := resp.Responses[0]
+	if res.GetError() != nil {
+		return nil, status.ErrorProto(res.GetError())
+	}
+
+	return res, nil
+}
+
+// Converts a bounding polygon into the axis aligned top-left, top-right, bottom-right, bottom-left vertices used by the overlays
+func boundingBoxVertices(vertices []*visionpb.Vertex) []map[string]int {
+	if len(vertices) == 0 {
+		return nil
+	}
+
+	minX, minY := int(vertices[0].GetX()), int(vertices[0].GetY())
+	maxX, maxY := minX, minY
+
+	for _, vertex := range vertices[1:] {
+		x, y := int(vertex.GetX()), int(vertex.GetY())
+		minX, maxX = min(minX, x), max(maxX, x)
+		minY, maxY = min(minY, y), max(maxY, y)
+	}
+
+	return []map[string]int{
+		{"x": minX, "y": minY},
+		{"x": maxX, "y": minY},
+		{"x": maxX, "y": maxY},
+		{"x": minX, "y": maxY},
+	}
+}
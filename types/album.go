@@ -0,0 +1,15 @@
+package types
+
+// Ordered group of images, the cover is the first image until another one is chosen
+type Album struct {
+	Id            string   `json:"id"`
+	Title         string   `json:"title"`
+	Description   string   `json:"description"`
+	ImagesIds     []string `json:"imagesIds"`
+	CoverImageId  string   `json:"coverImageId"`
+	CoverImageUrl string   `json:"coverImageUrl"`
+	CreatedBy     string   `json:"createdBy"`
+	CreatedAt     string   `json:"createdAt"`
+	UpdatedAt     string   `json:"updatedAt"`
+	Version       int64    `json:"version"`
+}
@@ -0,0 +1,18 @@
+package types
+
+type Comment struct {
+	Id           string `json:"id"`
+	PostId       string `json:"postId"`
+	ParentId     string `json:"parentId"`
+	AuthorUid    string `json:"authorUid"`
+	Body         string `json:"body"`
+	Status       string `json:"status"`
+	RepliesCount int64  `json:"repliesCount"`
+	CreatedAt    string `json:"createdAt"`
+	UpdatedAt    string `json:"updatedAt"`
+}
+
+// Whether new comments wait for an admin approval before they are shown
+type CommentsSettings struct {
+	RequireApproval bool `json:"requireApproval"`
+}
@@ -12,4 +12,5 @@ type Face struct {
 	TiltAngle   float32                  `json:"tiltAngle"`
 	ImageId     string                   `json:"imageId"`
 	CreatedAt   string                   `json:"createdAt"`
+	Version     int64                    `json:"version"`
 }
@@ -0,0 +1,12 @@
+package types
+
+// Face followed across the frames of an animated image
+type FaceTrack struct {
+	Id     string           `json:"id"`
+	Frames []FaceTrackFrame `json:"frames"`
+}
+
+type FaceTrackFrame struct {
+	Frame    int              `json:"frame"`
+	Vertices []map[string]int `json:"vertices"`
+}
@@ -0,0 +1,8 @@
+package types
+
+type HashTagSuggestion struct {
+	Label   string   `json:"label"`
+	Score   float32  `json:"score"`
+	Value   string   `json:"value"`
+	HashTag *HashTag `json:"hashTag"`
+}
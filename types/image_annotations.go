@@ -0,0 +1,9 @@
+package types
+
+type ImageAnnotations struct {
+	SafeSearch SafeSearch `json:"safeSearch"`
+	Labels     []Label    `json:"labels"`
+	Text       string     `json:"text"`
+	Faces      []Face     `json:"faces"`
+	Regions    []Region   `json:"regions"`
+}
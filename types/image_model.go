@@ -6,5 +6,9 @@ type Image struct {
 	StoragePath string   `json:"storagePath"`
 	CreatedAt   string   `json:"createdAt"`
 	PostsIds    []string `json:"postsIds"`
+	AlbumsCount int64    `json:"albumsCount"`
 	FacesIds    []string `json:"facesIds"`
+	Text        string   `json:"text"`
+	DeletedAt   string   `json:"deletedAt"`
+	Version     int64    `json:"version"`
 }
@@ -0,0 +1,6 @@
+package types
+
+type Label struct {
+	Description string  `json:"description"`
+	Score       float32 `json:"score"`
+}
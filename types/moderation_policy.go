@@ -0,0 +1,8 @@
+package types
+
+// Lowest likelihood of each category that quarantines an image, e.g. "LIKELY"
+type ModerationPolicy struct {
+	Adult    string `json:"adult"`
+	Violence string `json:"violence"`
+	Racy     string `json:"racy"`
+}
@@ -7,4 +7,8 @@ type NotificationMessage struct {
 	FacesIds          []string `json:"facesIds"`
 	FacesUrls         []string `json:"facesUrls"`
 	FacesStoragePaths []string `json:"facesStoragePaths"`
+	RegionsIds        []string `json:"regionsIds"`
+	ModerationStatus  string   `json:"moderationStatus"`
+	PostId            string   `json:"postId,omitempty"`
+	PostStatus        string   `json:"postStatus,omitempty"`
 }
@@ -4,4 +4,12 @@ type ObscuredOverlay struct {
 	Id          string `json:"obscuredId"`
 	Url         string `json:"obscuredUrl"`
 	StoragePath string `json:"obscuredStoragePath"`
+
+	// The original with the overlay drawn over it
+	ImageUrl         string `json:"obscuredImageUrl"`
+	ImageStoragePath string `json:"obscuredImageStoragePath"`
+
+	// Faces and text regions covered by the overlay
+	FacesIds   []string `json:"obscuredFacesIds"`
+	RegionsIds []string `json:"obscuredRegionsIds"`
 }
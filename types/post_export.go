@@ -0,0 +1,43 @@
+package types
+
+// Manifest of a ZIP export of posts, the files are named relative to the root of the archive
+type PostsExportManifest struct {
+	ExportedAt string       `json:"exportedAt"`
+	ExportedBy string       `json:"exportedBy"`
+	Originals  bool         `json:"originals"`
+	Posts      []PostExport `json:"posts"`
+}
+
+type PostExport struct {
+	Id          string            `json:"id"`
+	Body        string            `json:"body"`
+	CreatedAt   string            `json:"createdAt"`
+	PublishedAt string            `json:"publishedAt"`
+	Status      string            `json:"status"`
+	Visibility  string            `json:"visibility"`
+	HashTags    []string          `json:"hashTags"`
+	Images      []PostExportImage `json:"images"`
+}
+
+// Image of an exported post, obscured tells whether the file is the obscured rendition of the image and the obscured
+// faces and regions ids which of its faces and text regions the rendition covers. Images with faces or text regions the
+// post does not obscure have no file.
+type PostExportImage struct {
+	Id                 string             `json:"id"`
+	Width              int64              `json:"width"`
+	Height             int64              `json:"height"`
+	Obscured           bool               `json:"obscured"`
+	ObscuredFacesIds   []string           `json:"obscuredFacesIds"`
+	ObscuredRegionsIds []string           `json:"obscuredRegionsIds"`
+	File               string             `json:"file"`
+	OriginalFile       string             `json:"originalFile"`
+	Faces              []FaceVertices     `json:"faces"`
+	Regions            []PostExportRegion `json:"regions"`
+}
+
+// Text region of an exported image, the text read in it is left out
+type PostExportRegion struct {
+	Id       string           `json:"id"`
+	Type     string           `json:"type"`
+	Vertices []map[string]int `json:"vertices"`
+}
@@ -1,21 +1,44 @@
 package types
 
 type Post struct {
-	Id                           string              `json:"id"`
-	Body                         string              `json:"body"`
-	CreatedAt                    string              `json:"createdAt"`
-	HashTagsValues               []string            `json:"hashTagsValues"`
-	HashTagsIds                  []string            `json:"hashTagsIds"`
-	ImagesIds                    []string            `json:"imagesIds"`
-	ImagesUrls                   []string            `json:"imagesUrls"`
-	ImagesStoragePaths           []string            `json:"imagesStoragePaths"`
-	FacesIds                     map[string][]string `json:"facesIds"`
-	FacesUrls                    map[string][]string `json:"facesUrls"`
-	FacesStoragePaths            map[string][]string `json:"facesStoragePaths"`
-	OverlaysIds                  []string            `json:"overlaysIds"`
-	OverlaysUrls                 []string            `json:"overlaysUrls"`
-	OverlaysStoragePaths         []string            `json:"overlaysStoragePaths"`
-	ObscuredOverlaysIds          []string            `json:"obscuredOverlaysIds"`
-	ObscuredOverlaysUrls         []string            `json:"obscuredOverlaysUrls"`
-	ObscuredOverlaysStoragePaths []string            `json:"obscuredOverlaysStoragePaths"`
+	Id                             string              `json:"id"`
+	Body                           string              `json:"body"`
+	CreatedAt                      string              `json:"createdAt"`
+	PublishedAt                    string              `json:"publishedAt"`
+	UpdatedAt                      string              `json:"updatedAt"`
+	Status                         string              `json:"status"`
+	Visibility                     string              `json:"visibility"`
+	AuthorUid                      string              `json:"authorUid"`
+	PublishAt                      string              `json:"publishAt"`
+	DeletedAt                      string              `json:"deletedAt"`
+	Revision                       int64               `json:"revision"`
+	Version                        int64               `json:"version"`
+	CommentsCount                  int64               `json:"commentsCount"`
+	Pinned                         bool                `json:"pinned"`
+	PinnedAt                       string              `json:"pinnedAt"`
+	PinnedUntil                    string              `json:"pinnedUntil"`
+	PinWeight                      int64               `json:"pinWeight"`
+	ReactionsCounts                map[string]int64    `json:"reactionsCounts"`
+	Reactions                      []string            `json:"reactions"`
+	HashTagsValues                 []string            `json:"hashTagsValues"`
+	HashTagsIds                    []string            `json:"hashTagsIds"`
+	ImagesIds                      []string            `json:"imagesIds"`
+	ImagesUrls                     []string            `json:"imagesUrls"`
+	ImagesStoragePaths             []string            `json:"imagesStoragePaths"`
+	FacesIds                       map[string][]string `json:"facesIds"`
+	FacesUrls                      map[string][]string `json:"facesUrls"`
+	FacesStoragePaths              map[string][]string `json:"facesStoragePaths"`
+	RegionsIds                     map[string][]string `json:"regionsIds"`
+	OverlaysIds                    []string            `json:"overlaysIds"`
+	OverlaysUrls                   []string            `json:"overlaysUrls"`
+	OverlaysStoragePaths           []string            `json:"overlaysStoragePaths"`
+	ObscuredOverlaysIds            []string            `json:"obscuredOverlaysIds"`
+	ObscuredOverlaysUrls           []string            `json:"obscuredOverlaysUrls"`
+	ObscuredOverlaysStoragePaths   []string            `json:"obscuredOverlaysStoragePaths"`
+	ObscuredImagesUrls             []string            `json:"obscuredImagesUrls"`
+	ObscuredImagesStoragePaths     []string            `json:"obscuredImagesStoragePaths"`
+	ObscuredAnimationsUrls         []string            `json:"obscuredAnimationsUrls"`
+	ObscuredAnimationsStoragePaths []string            `json:"obscuredAnimationsStoragePaths"`
+	ObscuredFacesIds               map[string][]string `json:"obscuredFacesIds"`
+	ObscuredRegionsIds             map[string][]string `json:"obscuredRegionsIds"`
 }
@@ -0,0 +1,52 @@
+package types
+
+// Snapshot of the content of a post after a change
+type PostRevision struct {
+	PostId              string   `json:"postId"`
+	Revision            int64    `json:"revision"`
+	Body                string   `json:"body"`
+	Status              string   `json:"status"`
+	Visibility          string   `json:"visibility"`
+	HashTagsIds         []string `json:"hashTagsIds"`
+	HashTagsValues      []string `json:"hashTagsValues"`
+	ImagesIds           []string `json:"imagesIds"`
+	ObscuredOverlaysIds []string `json:"obscuredOverlaysIds"`
+	CreatedAt           string   `json:"createdAt"`
+	CreatedBy           string   `json:"createdBy"`
+
+	// Version of the obscured overlay of each image shown obscured, revisions written before they were recorded have none
+	ObscuredOverlays map[string]PostRevisionObscuredOverlay `json:"obscuredOverlays"`
+}
+
+// Obscured overlay of an image as a revision shows it, with its rendition over the original and what it covers
+type PostRevisionObscuredOverlay struct {
+	Url                  string   `json:"url"`
+	StoragePath          string   `json:"storagePath"`
+	ImageUrl             string   `json:"imageUrl"`
+	ImageStoragePath     string   `json:"imageStoragePath"`
+	AnimationUrl         string   `json:"animationUrl"`
+	AnimationStoragePath string   `json:"animationStoragePath"`
+	FacesIds             []string `json:"facesIds"`
+	RegionsIds           []string `json:"regionsIds"`
+}
+
+// Changes between two revisions of a post, the body, status and visibility are only set when they changed
+type PostRevisionDiff struct {
+	PostId                     string   `json:"postId"`
+	From                       int64    `json:"from"`
+	To                         int64    `json:"to"`
+	FromBody                   string   `json:"fromBody,omitempty"`
+	ToBody                     string   `json:"toBody,omitempty"`
+	FromStatus                 string   `json:"fromStatus,omitempty"`
+	ToStatus                   string   `json:"toStatus,omitempty"`
+	FromVisibility             string   `json:"fromVisibility,omitempty"`
+	ToVisibility               string   `json:"toVisibility,omitempty"`
+	AddedHashTagsValues        []string `json:"addedHashTagsValues"`
+	RemovedHashTagsValues      []string `json:"removedHashTagsValues"`
+	AddedImagesIds             []string `json:"addedImagesIds"`
+	RemovedImagesIds           []string `json:"removedImagesIds"`
+	ImagesReordered            bool     `json:"imagesReordered"`
+	AddedObscuredOverlaysIds   []string `json:"addedObscuredOverlaysIds"`
+	RemovedObscuredOverlaysIds []string `json:"removedObscuredOverlaysIds"`
+	ChangedObscuredOverlaysIds []string `json:"changedObscuredOverlaysIds"`
+}
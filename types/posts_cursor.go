@@ -0,0 +1,10 @@
+package types
+
+import "time"
+
+// Position in a posts listing, encoded into an opaque cursor token
+type PostsCursor struct {
+	CreatedAt time.Time `json:"createdAt"`
+	Id        string    `json:"id"`
+	Direction string    `json:"direction"`
+}
@@ -0,0 +1,14 @@
+package types
+
+// Post as it is shown outside the app, the images are the obscured renditions and images without one are left out
+type PublicPost struct {
+	Id             string   `json:"id"`
+	Body           string   `json:"body"`
+	CreatedAt      string   `json:"createdAt"`
+	PublishedAt    string   `json:"publishedAt"`
+	UpdatedAt      string   `json:"updatedAt"`
+	HashTagsValues []string `json:"hashTagsValues"`
+	ImagesIds      []string `json:"imagesIds"`
+	ImagesUrls     []string `json:"imagesUrls"`
+	ImagesTypes    []string `json:"imagesTypes"`
+}
@@ -0,0 +1,17 @@
+package types
+
+// Reactions to a post, the counts of each type and the types the user reacted with
+type PostReactions struct {
+	Counts    map[string]int64 `json:"counts"`
+	Reactions []string         `json:"reactions"`
+}
+
+// Emojis users can react with besides likes
+type ReactionsSettings struct {
+	Emojis []string `json:"emojis"`
+}
+
+// Returns the reaction types users can react with, likes first
+func (settings ReactionsSettings) Types() []string {
+	return append([]string{REACTION_TYPE_LIKE}, settings.Emojis...)
+}
@@ -0,0 +1,10 @@
+package types
+
+type Region struct {
+	Id        string           `json:"id"`
+	Type      string           `json:"type"`
+	Text      string           `json:"text"`
+	Vertices  []map[string]int `json:"vertices"`
+	ImageId   string           `json:"imageId"`
+	CreatedAt string           `json:"createdAt"`
+}
@@ -0,0 +1,7 @@
+package types
+
+type SafeSearch struct {
+	Adult    string `json:"adult"`
+	Violence string `json:"violence"`
+	Racy     string `json:"racy"`
+}
@@ -0,0 +1,13 @@
+package types
+
+type ShareLink struct {
+	Id             string `json:"id"`
+	PostId         string `json:"postId"`
+	Token          string `json:"token"`
+	CreatedBy      string `json:"createdBy"`
+	CreatedAt      string `json:"createdAt"`
+	ExpiresAt      string `json:"expiresAt"`
+	RevokedAt      string `json:"revokedAt"`
+	AccessCount    int64  `json:"accessCount"`
+	LastAccessedAt string `json:"lastAccessedAt"`
+}
@@ -9,14 +9,44 @@ const FIREBASE_STORAGE_IMAGES_FOLDER = "images/"
 const FIREBASE_STORAGE_FACES_FOLDER = "faces/"
 const FIREBASE_STORAGE_FACES_OVERLAY_FOLDER = "faces_overlay/"
 const FIREBASE_STORAGE_OBSCURED_FACES_OVERLAY_FOLDER = "obscured_faces_overlay/"
+const FIREBASE_STORAGE_OBSCURED_FOLDER = "obscured/"
+const FIREBASE_STORAGE_ANIMATIONS_FOLDER = "animations/"
+
+// Faces are detected on every n-th frame of an animated image and interpolated in between
+const ANIMATION_FACES_DETECTION_MAX_SAMPLED_FRAMES = 12
+const ANIMATION_FACES_DETECTION_MAX_RESULTS = 10
+
+// Frames count times canvas area above which an animation is processed as a still image, every frame is composed in
+// memory at 4 bytes per pixel
+const ANIMATION_MAX_COMPOSED_PIXELS = 50_000_000
+
+// Embedded full-text index of the posts, rebuilt from Firestore when the instance starts
+const POSTS_SEARCH_INDEX_PATH = "/tmp/posts_search_index"
+
+// Each instance has its own index, it reads the posts changed by the other instances from Firestore on this interval.
+// Posts changed slightly before the last change seen are read again, in case their write became visible later.
+const POSTS_SEARCH_INDEX_SYNC_INTERVAL_SECONDS = 30
+const POSTS_SEARCH_INDEX_SYNC_OVERLAP_SECONDS = 10
 
 const FIREBASE_MESSAGING_TOKEN_COLLECTION = "messaging_registration_tokens"
 const FIREBASE_MESSAGING_TOKEN_DOCUMENT = "token"
 
 const CLOUD_RUN_SERVICE_URL = "https://go-todo-app-p257zlltoa-lm.a.run.app"
 
+// The task handlers are called with an OIDC token of the tasks service account, issued for the service url
+const CLOUD_TASKS_OIDC_AUDIENCE = CLOUD_RUN_SERVICE_URL
+const TASKS_SERVICE_ACCOUNT_EMAIL_ENV = "TASKS_SERVICE_ACCOUNT_EMAIL"
+
 const CLOUD_IMAGES_QUEUE_ID = "image-processing-queue"
+
+// Scheduled posts are published from their own queue, so a backlog of images does not delay them
+const CLOUD_SCHEDULED_POSTS_QUEUE_ID = "scheduled-posts-queue"
 const CLOUD_TASKS_HANDLER_PATH = "/api/tasks/image_processing_task_handler"
+const CLOUD_STORAGE_OUTBOX_HANDLER_PATH = "/api/tasks/storage_outbox_handler"
+const CLOUD_SCHEDULED_POSTS_HANDLER_PATH = "/api/tasks/scheduled_posts_handler"
+const CLOUD_TRASH_PURGE_HANDLER_PATH = "/api/tasks/trash_purge_handler"
+const CLOUD_POSTS_BACKFILL_HANDLER_PATH = "/api/tasks/posts_backfill_handler"
+const CLOUD_REACTIONS_ROLLUP_HANDLER_PATH = "/api/tasks/reactions_rollup_handler"
 
 const FIREBASE_IMAGES_COLLECTION = "images"
 const FIREBASE_IMAGES_FIELDS_ID = "id"
@@ -25,6 +55,9 @@ const FIREBASE_IMAGES_FIELDS_STORAGE_PATH = "storagePath"
 const FIREBASE_IMAGES_FIELDS_CREATED_AT = "createdAt"
 const FIREBASE_IMAGES_FIELDS_WIDTH = "width"
 const FIREBASE_IMAGES_FIELDS_HEIGHT = "height"
+const FIREBASE_IMAGES_FIELDS_POSTS_IDS = "postsIds"
+
+// Post of the images written before they could be used by several posts, replaced by the posts ids when they join or leave a post
 const FIREBASE_IMAGES_FIELDS_POST_ID = "postId"
 const FIREBASE_IMAGES_FIELDS_FACES_IDS = "facesIds"
 const FIREBASE_IMAGES_FIELDS_FACES_URLS = "facesUrls"
@@ -34,6 +67,185 @@ const FIREBASE_IMAGES_FIELDS_FACES_OVERLAY_STORAGE_PATH = "facesOverlayStoragePa
 const FIREBASE_IMAGES_FIELDS_FACES_OBSCURED_OVERLAY_URL = "facesObscuredOverlayUrl"
 const FIREBASE_IMAGES_FIELDS_FACES_OBSCURED_OVERLAY_STORAGE_PATH = "facesObscuredOverlayStoragePath"
 
+// The original with the obscured overlay drawn over it, the only form of an obscured image served outside the app
+const FIREBASE_IMAGES_FIELDS_OBSCURED_URL = "obscuredUrl"
+const FIREBASE_IMAGES_FIELDS_OBSCURED_STORAGE_PATH = "obscuredStoragePath"
+
+// Faces and text regions covered by the obscured overlay
+const FIREBASE_IMAGES_FIELDS_OBSCURED_FACES_IDS = "obscuredFacesIds"
+const FIREBASE_IMAGES_FIELDS_OBSCURED_REGIONS_IDS = "obscuredRegionsIds"
+const FIREBASE_IMAGES_FIELDS_REGIONS_IDS = "regionsIds"
+const FIREBASE_IMAGES_FIELDS_ANIMATED = "animated"
+const FIREBASE_IMAGES_FIELDS_ANIMATION_URL = "animationUrl"
+const FIREBASE_IMAGES_FIELDS_ANIMATION_STORAGE_PATH = "animationStoragePath"
+const FIREBASE_IMAGES_FIELDS_ANIMATION_FRAMES_COUNT = "animationFramesCount"
+const FIREBASE_IMAGES_FIELDS_ANIMATION_FACES_TRACKS = "animationFacesTracks"
+
+// Frames the faces were detected on, the tracks only keep their boxes on those frames and are interpolated when read
+const FIREBASE_IMAGES_FIELDS_ANIMATION_SAMPLED_FRAMES = "animationSampledFrames"
+const FIREBASE_IMAGES_FIELDS_ANIMATION_OBSCURED_URL = "animationObscuredUrl"
+const FIREBASE_IMAGES_FIELDS_ANIMATION_OBSCURED_STORAGE_PATH = "animationObscuredStoragePath"
+
+// Every version of the obscured objects of an image, kept for the posts showing an older one and deleted with the image
+const FIREBASE_IMAGES_FIELDS_OBSCURED_VERSIONS_STORAGE_PATHS = "obscuredVersionsStoragePaths"
+const FIREBASE_IMAGES_FIELDS_FACES_TRACKS_ID = "id"
+const FIREBASE_IMAGES_FIELDS_FACES_TRACKS_FRAMES = "frames"
+const FIREBASE_IMAGES_FIELDS_FACES_TRACKS_FRAME = "frame"
+const FIREBASE_IMAGES_FIELDS_FACES_TRACKS_VERTICES = "vertices"
+const FIREBASE_IMAGES_FIELDS_SAFE_SEARCH = "safeSearch"
+const FIREBASE_IMAGES_FIELDS_SAFE_SEARCH_ADULT = "adult"
+const FIREBASE_IMAGES_FIELDS_SAFE_SEARCH_VIOLENCE = "violence"
+const FIREBASE_IMAGES_FIELDS_SAFE_SEARCH_RACY = "racy"
+const FIREBASE_IMAGES_FIELDS_LABELS = "labels"
+const FIREBASE_IMAGES_FIELDS_LABELS_DESCRIPTION = "description"
+const FIREBASE_IMAGES_FIELDS_LABELS_SCORE = "score"
+const FIREBASE_IMAGES_FIELDS_TEXT = "text"
+const FIREBASE_IMAGES_FIELDS_TEXT_TOKENS = "textTokens"
+const FIREBASE_IMAGES_FIELDS_MODERATION_STATUS = "moderationStatus"
+const FIREBASE_IMAGES_FIELDS_MODERATION_REVIEWED_BY = "moderationReviewedBy"
+const FIREBASE_IMAGES_FIELDS_MODERATION_REVIEWED_AT = "moderationReviewedAt"
+const FIREBASE_IMAGES_FIELDS_DELETED_AT = "deletedAt"
+const FIREBASE_IMAGES_FIELDS_VERSION = "version"
+const FIREBASE_IMAGES_FIELDS_ALBUMS_COUNT = "albumsCount"
+
+const MODERATION_STATUS_APPROVED = "approved"
+const MODERATION_STATUS_QUARANTINED = "quarantined"
+const MODERATION_STATUS_REJECTED = "rejected"
+
+const FIREBASE_SETTINGS_COLLECTION = "settings"
+const FIREBASE_SETTINGS_MODERATION_POLICY_DOCUMENT = "moderation_policy"
+const FIREBASE_MODERATION_POLICY_FIELDS_ADULT = "adult"
+const FIREBASE_MODERATION_POLICY_FIELDS_VIOLENCE = "violence"
+const FIREBASE_MODERATION_POLICY_FIELDS_RACY = "racy"
+
+// Default moderation policy, used until an admin sets one
+const MODERATION_POLICY_DEFAULT_ADULT = "LIKELY"
+const MODERATION_POLICY_DEFAULT_VIOLENCE = "LIKELY"
+const MODERATION_POLICY_DEFAULT_RACY = "VERY_LIKELY"
+
+const FIREBASE_SETTINGS_TRASH_DOCUMENT = "trash"
+const FIREBASE_TRASH_SETTINGS_FIELDS_RETENTION_DAYS = "retentionDays"
+
+// Days deleted posts and images stay in the trash before they are purged, used until an admin sets them
+const TRASH_DEFAULT_RETENTION_DAYS = 30
+
+// Documents deleted per batch when purging the documents attached to a post or the replies of a comment, a transaction
+// is limited to 500 writes
+const TRASH_PURGE_BATCH_SIZE = 200
+
+const FIREBASE_SETTINGS_COMMENTS_DOCUMENT = "comments"
+const FIREBASE_COMMENTS_SETTINGS_FIELDS_REQUIRE_APPROVAL = "requireApproval"
+
+// Comments on posts, replies point to the top level comment of their thread
+const FIREBASE_COMMENTS_COLLECTION = "comments"
+const FIREBASE_COMMENTS_FIELDS_ID = "id"
+const FIREBASE_COMMENTS_FIELDS_POST_ID = "postId"
+const FIREBASE_COMMENTS_FIELDS_PARENT_ID = "parentId"
+const FIREBASE_COMMENTS_FIELDS_AUTHOR_UID = "authorUid"
+const FIREBASE_COMMENTS_FIELDS_BODY = "body"
+const FIREBASE_COMMENTS_FIELDS_STATUS = "status"
+const FIREBASE_COMMENTS_FIELDS_REPLIES_COUNT = "repliesCount"
+const FIREBASE_COMMENTS_FIELDS_CREATED_AT = "createdAt"
+const FIREBASE_COMMENTS_FIELDS_UPDATED_AT = "updatedAt"
+const FIREBASE_COMMENTS_FIELDS_MODERATED_BY = "moderatedBy"
+const FIREBASE_COMMENTS_FIELDS_MODERATED_AT = "moderatedAt"
+
+// Only approved comments are shown to non admin users and counted on their post
+const COMMENT_STATUS_PENDING = "pending"
+const COMMENT_STATUS_APPROVED = "approved"
+const COMMENT_STATUS_HIDDEN = "hidden"
+
+const COMMENT_BODY_MAX_LENGTH = 2000
+
+const FIREBASE_SETTINGS_REACTIONS_DOCUMENT = "reactions"
+const FIREBASE_REACTIONS_SETTINGS_FIELDS_EMOJIS = "emojis"
+
+// Reactions of the users to posts, identified by the post, the user and the type so a user reacts once per type
+const FIREBASE_REACTIONS_COLLECTION = "reactions"
+const FIREBASE_REACTIONS_FIELDS_ID = "id"
+const FIREBASE_REACTIONS_FIELDS_POST_ID = "postId"
+const FIREBASE_REACTIONS_FIELDS_UID = "uid"
+const FIREBASE_REACTIONS_FIELDS_TYPE = "type"
+const FIREBASE_REACTIONS_FIELDS_CREATED_AT = "createdAt"
+
+// Counters of the reactions to each post, split in shards identified by the post id and the shard number so
+// concurrent reactions do not contend for a single document
+const FIREBASE_REACTIONS_SHARDS_COLLECTION = "reactions_shards"
+const FIREBASE_REACTIONS_SHARDS_FIELDS_POST_ID = "postId"
+const FIREBASE_REACTIONS_SHARDS_FIELDS_COUNTS = "counts"
+const FIREBASE_REACTIONS_SHARDS_FIELDS_UPDATED_AT = "updatedAt"
+
+const REACTIONS_SHARDS_COUNT = 10
+
+// Sums of the shards kept on each post for the listings, rolled up from the shards changed since the last roll up
+const FIREBASE_POSTS_FIELDS_REACTIONS_COUNTS = "reactionsCounts"
+const FIREBASE_SETTINGS_REACTIONS_ROLLUP_DOCUMENT = "reactions_rollup"
+const FIREBASE_REACTIONS_ROLLUP_FIELDS_ROLLED_UP_AT = "rolledUpAt"
+
+// Shards changed shortly before the last roll up are read again, in case their write became visible later
+const REACTIONS_ROLLUP_OVERLAP_SECONDS = 10
+
+// Likes are always available, the emojis can be changed by an admin
+const REACTION_TYPE_LIKE = "like"
+const REACTIONS_DEFAULT_EMOJIS = "❤️,😂,😮,😢"
+const REACTIONS_MAX_EMOJIS = 8
+const REACTION_EMOJI_MAX_LENGTH = 16
+
+const FIREBASE_SETTINGS_SHARE_LINKS_DOCUMENT = "share_links"
+const FIREBASE_SHARE_LINKS_SETTINGS_FIELDS_SECRET = "secret"
+
+// Links giving access to a post without signing in, until they expire or are revoked
+const FIREBASE_SHARE_LINKS_COLLECTION = "share_links"
+const FIREBASE_SHARE_LINKS_FIELDS_ID = "id"
+const FIREBASE_SHARE_LINKS_FIELDS_POST_ID = "postId"
+const FIREBASE_SHARE_LINKS_FIELDS_CREATED_BY = "createdBy"
+const FIREBASE_SHARE_LINKS_FIELDS_CREATED_AT = "createdAt"
+const FIREBASE_SHARE_LINKS_FIELDS_EXPIRES_AT = "expiresAt"
+const FIREBASE_SHARE_LINKS_FIELDS_REVOKED_AT = "revokedAt"
+const FIREBASE_SHARE_LINKS_FIELDS_ACCESS_COUNT = "accessCount"
+const FIREBASE_SHARE_LINKS_FIELDS_LAST_ACCESSED_AT = "lastAccessedAt"
+
+const SHARE_LINKS_DEFAULT_EXPIRY_HOURS = 168
+const SHARE_LINKS_MAX_EXPIRY_HOURS = 2160
+const SHARE_LINKS_SECRET_BYTES = 32
+
+// Feeds of the latest public posts syndicated outside the app
+const FEEDS_TITLE = "Proteggo"
+const FEEDS_DESCRIPTION = "Latest public posts, with faces obscured"
+const FEEDS_MAX_ITEMS = 20
+const FEEDS_ITEM_TITLE_MAX_LENGTH = 80
+const FEEDS_CACHE_MAX_AGE_SECONDS = 300
+
+// Environment variable with the scheme and host of the API as reached from outside, used in the links of feeds and pages
+const PUBLIC_BASE_URL_ENV = "PUBLIC_BASE_URL"
+
+// Albums group images independently of the posts, an image can be in several albums
+const FIREBASE_ALBUMS_COLLECTION = "albums"
+const FIREBASE_ALBUMS_FIELDS_ID = "id"
+const FIREBASE_ALBUMS_FIELDS_TITLE = "title"
+const FIREBASE_ALBUMS_FIELDS_DESCRIPTION = "description"
+const FIREBASE_ALBUMS_FIELDS_IMAGES_IDS = "imagesIds"
+const FIREBASE_ALBUMS_FIELDS_COVER_IMAGE_ID = "coverImageId"
+const FIREBASE_ALBUMS_FIELDS_CREATED_BY = "createdBy"
+const FIREBASE_ALBUMS_FIELDS_CREATED_AT = "createdAt"
+const FIREBASE_ALBUMS_FIELDS_UPDATED_AT = "updatedAt"
+const FIREBASE_ALBUMS_FIELDS_VERSION = "version"
+
+// Each image added or removed is written in the same transaction as its album
+const ALBUM_MAX_IMAGES = 200
+const ALBUM_TITLE_MAX_LENGTH = 200
+
+// Posts exported at once in a ZIP archive
+const POSTS_EXPORT_MAX_POSTS = 50
+
+// Link previews of public and shared posts
+const PREVIEWS_DESCRIPTION_MAX_LENGTH = 200
+const OEMBED_VERSION = "1.0"
+
+const FEED_FORMAT_RSS = "rss"
+const FEED_FORMAT_ATOM = "atom"
+const FEED_FORMAT_JSON = "json"
+
 const FIREBASE_FACES_COLLECTION = "faces"
 const FIREBASE_FACES_FIELDS_ID = "id"
 const FIREBASE_FACES_FIELDS_EMOTION = "emotion"
@@ -45,14 +257,37 @@ const FIREBASE_FACES_FIELDS_TILT_ANGLE = "tiltAngle"
 const FIREBASE_FACES_FIELDS_STORAGE_PATH = "storagePath"
 const FIREBASE_FACES_FIELDS_URL = "url"
 const FIREBASE_FACES_FIELDS_IMAGE_ID = "imageId"
+const FIREBASE_FACES_FIELDS_POSTS_IDS = "postsIds"
+
+// Post of the faces written before their image could be used by several posts
 const FIREBASE_FACES_FIELDS_POST_ID = "postId"
 const FIREBASE_FACES_FIELDS_CREATED_AT = "createdAt"
+const FIREBASE_FACES_FIELDS_VERSION = "version"
+
+const FIREBASE_REGIONS_COLLECTION = "regions"
+const FIREBASE_REGIONS_FIELDS_ID = "id"
+const FIREBASE_REGIONS_FIELDS_TYPE = "type"
+const FIREBASE_REGIONS_FIELDS_TEXT = "text"
+const FIREBASE_REGIONS_FIELDS_VERTICES = "vertices"
+const FIREBASE_REGIONS_FIELDS_IMAGE_ID = "imageId"
+const FIREBASE_REGIONS_FIELDS_POSTS_IDS = "postsIds"
+
+// Post of the regions written before their image could be used by several posts
+const FIREBASE_REGIONS_FIELDS_POST_ID = "postId"
+const FIREBASE_REGIONS_FIELDS_CREATED_AT = "createdAt"
+
+const REGION_TYPE_LICENSE_PLATE = "license_plate"
+const REGION_TYPE_NAME_BADGE = "name_badge"
+const REGION_TYPE_DOCUMENT = "document"
 
 const FIREBASE_POSTS_HASHTAGS_COLLECTION = "hashTags"
 const FIREBASE_POSTS_HASHTAGS_FIELDS_ID = "id"
 const FIREBASE_POSTS_HASHTAGS_FIELDS_SCORE = "score"
 const FIREBASE_POSTS_HASHTAGS_FIELDS_VALUE = "value"
 
+// Minimum similarity between a label and a hash tag value to suggest the existing hash tag
+const HASHTAGS_SUGGESTIONS_MIN_SIMILARITY = 0.8
+
 const FIREBASE_POSTS_FIELDS_BODY = "body"
 const FIREBASE_POSTS_FIELDS_CREATED_AT = "createdAt"
 const FIREBASE_POSTS_FIELDS_HASH_TAGS_VALUES = "hashTagsValues"
@@ -64,11 +299,104 @@ const FIREBASE_POSTS_FIELDS_IMAGES_STORAGE_PATHS = "imagesStoragePaths"
 const FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_IDS = "obscuredOverlaysIds"
 const FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_URLS = "obscuredOverlaysUrls"
 const FIREBASE_POSTS_FIELDS_OBSCURED_OVERLAYS_STORAGE_PATHS = "obscuredOverlaysStoragePaths"
+const FIREBASE_POSTS_FIELDS_OBSCURED_IMAGES_URLS = "obscuredImagesUrls"
+const FIREBASE_POSTS_FIELDS_OBSCURED_IMAGES_STORAGE_PATHS = "obscuredImagesStoragePaths"
+const FIREBASE_POSTS_FIELDS_OBSCURED_ANIMATIONS_URLS = "obscuredAnimationsUrls"
+const FIREBASE_POSTS_FIELDS_OBSCURED_ANIMATIONS_STORAGE_PATHS = "obscuredAnimationsStoragePaths"
+const FIREBASE_POSTS_FIELDS_OBSCURED_FACES_IDS = "obscuredFacesIds"
+const FIREBASE_POSTS_FIELDS_OBSCURED_REGIONS_IDS = "obscuredRegionsIds"
 const FIREBASE_POSTS_FIELDS_FACES_IDS = "facesIds"
 const FIREBASE_POSTS_FIELDS_FACES_URLS = "facesUrls"
 const FIREBASE_POSTS_FIELDS_FACES_STORAGE_PATHS = "facesStoragePaths"
 const FIREBASE_POSTS_FIELDS_OVERLAYS_IDS = "overlaysIds"
 const FIREBASE_POSTS_FIELDS_OVERLAYS_URLS = "overlaysUrls"
 const FIREBASE_POSTS_FIELDS_OVERLAYS_STORAGE_PATHS = "overlaysStoragePaths"
+const FIREBASE_POSTS_FIELDS_REGIONS_IDS = "regionsIds"
+const FIREBASE_POSTS_FIELDS_STATUS = "status"
+const FIREBASE_POSTS_FIELDS_PUBLISH_AT = "publishAt"
+
+// Time a post entered the feed, the listings, feeds and search are ordered and filtered by it. Drafts and scheduled posts
+// hold their creation time until they are published, so they are listed among the other posts to their authors.
+const FIREBASE_POSTS_FIELDS_PUBLISHED_AT = "publishedAt"
+const FIREBASE_POSTS_FIELDS_DELETED_AT = "deletedAt"
+
+// Time of the last change of the body, hash tags, status, visibility or trash state of a post, the search indexes of
+// the instances sync the posts changed since their last sync
+const FIREBASE_POSTS_FIELDS_UPDATED_AT = "updatedAt"
+const FIREBASE_POSTS_FIELDS_REVISION = "revision"
+const FIREBASE_POSTS_FIELDS_VERSION = "version"
+const FIREBASE_POSTS_FIELDS_AUTHOR_UID = "authorUid"
+const FIREBASE_POSTS_FIELDS_VISIBILITY = "visibility"
+const FIREBASE_POSTS_FIELDS_COMMENTS_COUNT = "commentsCount"
+const FIREBASE_POSTS_FIELDS_PINNED = "pinned"
+const FIREBASE_POSTS_FIELDS_PINNED_AT = "pinnedAt"
+const FIREBASE_POSTS_FIELDS_PINNED_UNTIL = "pinnedUntil"
+const FIREBASE_POSTS_FIELDS_PIN_WEIGHT = "pinWeight"
+
+// Pinned posts are listed before the other posts, the ones with the highest weight first
+const POSTS_MAX_PINNED = 10
+
+// Only published posts are listed to non admin users
+const POST_STATUS_DRAFT = "draft"
+const POST_STATUS_SCHEDULED = "scheduled"
+const POST_STATUS_PUBLISHED = "published"
+const POST_STATUS_ARCHIVED = "archived"
+
+// Public posts can be shared outside the app, unlisted posts are only read from their link and private posts by their author
+const POST_VISIBILITY_PUBLIC = "public"
+const POST_VISIBILITY_AUTHENTICATED = "authenticated"
+const POST_VISIBILITY_PRIVATE = "private"
+const POST_VISIBILITY_UNLISTED = "unlisted"
+
+// Custom claim of the users who can write their own posts, admins can write all posts
+const AUTH_CLAIM_CONTRIBUTOR = "contributor"
 
 const FIREBASE_POSTS_COLLECTION = "posts"
+
+// Snapshots of the posts written on every change, identified by the post id and the revision number
+const FIREBASE_POST_REVISIONS_COLLECTION = "post_revisions"
+const FIREBASE_POST_REVISIONS_FIELDS_POST_ID = "postId"
+const FIREBASE_POST_REVISIONS_FIELDS_REVISION = "revision"
+const FIREBASE_POST_REVISIONS_FIELDS_BODY = "body"
+const FIREBASE_POST_REVISIONS_FIELDS_STATUS = "status"
+const FIREBASE_POST_REVISIONS_FIELDS_VISIBILITY = "visibility"
+const FIREBASE_POST_REVISIONS_FIELDS_HASH_TAGS_IDS = "hashTagsIds"
+const FIREBASE_POST_REVISIONS_FIELDS_HASH_TAGS_VALUES = "hashTagsValues"
+const FIREBASE_POST_REVISIONS_FIELDS_IMAGES_IDS = "imagesIds"
+const FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAYS_IDS = "obscuredOverlaysIds"
+
+// Versions of the obscured overlays shown by the revision and the faces and text regions they cover, by image id
+const FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAYS = "obscuredOverlays"
+const FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_URL = "url"
+const FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_STORAGE_PATH = "storagePath"
+const FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_IMAGE_URL = "imageUrl"
+const FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_IMAGE_STORAGE_PATH = "imageStoragePath"
+const FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_ANIMATION_URL = "animationUrl"
+const FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_ANIMATION_STORAGE_PATH = "animationStoragePath"
+const FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_FACES_IDS = "facesIds"
+const FIREBASE_POST_REVISIONS_FIELDS_OBSCURED_OVERLAY_REGIONS_IDS = "regionsIds"
+const FIREBASE_POST_REVISIONS_FIELDS_CREATED_AT = "createdAt"
+const FIREBASE_POST_REVISIONS_FIELDS_CREATED_BY = "createdBy"
+
+const POSTS_CURSOR_DIRECTION_NEXT = "next"
+const POSTS_CURSOR_DIRECTION_PREV = "prev"
+
+// Posts read at once when a listing is filtered on the server
+const POSTS_SCAN_BATCH_SIZE = 100
+
+// Batches read at most for one page, a page of rarely matching posts is returned short with a cursor to continue
+const POSTS_SCAN_MAX_BATCHES = 10
+
+// Largest page size of the listings, larger sizes are lowered to it
+const POSTS_PAGE_MAX_SIZE = 100
+
+// Firestore limits the values of array-contains-any
+const FIRESTORE_ARRAY_CONTAINS_ANY_MAX_VALUES = 10
+
+// Firestore limits the values of in
+const FIRESTORE_IN_MAX_VALUES = 30
+
+// Storage objects queued for deletion by committed transactions, deleted after the commit and retried until they succeed
+const FIREBASE_STORAGE_OUTBOX_COLLECTION = "storage_outbox"
+const FIREBASE_STORAGE_OUTBOX_FIELDS_STORAGE_PATHS = "storagePaths"
+const FIREBASE_STORAGE_OUTBOX_FIELDS_CREATED_AT = "createdAt"
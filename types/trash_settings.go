@@ -0,0 +1,6 @@
+package types
+
+// Days deleted posts and images are kept in the trash before being purged
+type TrashSettings struct {
+	RetentionDays int `json:"retentionDays"`
+}
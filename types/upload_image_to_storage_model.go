@@ -4,4 +4,5 @@ type UploadImageToStorageModel struct {
 	Id          string `json:"id"`
 	FilePath    string `json:"filePath"`
 	Orientation int    `json:"imageOrientation"`
+	ContentType string `json:"contentType"`
 }